@@ -0,0 +1,138 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// Filter 计数布隆过滤器 每个位置是一个计数器 所以除了添加还支持删除
+// MayContain 返回 false 时元素一定不存在 返回 true 时元素可能存在
+type Filter struct {
+	counters []uint8
+	hashes   uint32
+	rwLock   sync.RWMutex
+}
+
+// New 根据预计的元素数量和可以接受的误判率创建过滤器
+func New(expectedItems uint, falsePositiveRate float64) *Filter {
+	if expectedItems == 0 {
+		expectedItems = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	n := float64(expectedItems)
+	m := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/n*math.Ln2))
+	return &Filter{
+		counters: make([]uint8, uint64(m)),
+		hashes:   uint32(k),
+	}
+}
+
+// Add 添加元素
+func (f *Filter) Add(item string) {
+	f.rwLock.Lock()
+	defer f.rwLock.Unlock()
+	f.each(item, func(i uint64) {
+		// 计数器饱和后不再增加 也不会再减少 避免误删其他元素
+		if f.counters[i] < math.MaxUint8 {
+			f.counters[i]++
+		}
+	})
+}
+
+// Remove 删除元素 只能删除确实添加过的元素 否则可能删掉其他元素
+func (f *Filter) Remove(item string) {
+	f.rwLock.Lock()
+	defer f.rwLock.Unlock()
+	if !f.contains(item) {
+		return
+	}
+	f.each(item, func(i uint64) {
+		if f.counters[i] > 0 && f.counters[i] < math.MaxUint8 {
+			f.counters[i]--
+		}
+	})
+}
+
+// MayContain 判断元素是否可能存在
+func (f *Filter) MayContain(item string) bool {
+	f.rwLock.RLock()
+	defer f.rwLock.RUnlock()
+	return f.contains(item)
+}
+
+// Reset 清空过滤器
+func (f *Filter) Reset() {
+	f.rwLock.Lock()
+	defer f.rwLock.Unlock()
+	for i := range f.counters {
+		f.counters[i] = 0
+	}
+}
+
+func (f *Filter) contains(item string) bool {
+	found := true
+	f.each(item, func(i uint64) {
+		if f.counters[i] == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// each 使用双重哈希计算元素对应的所有位置
+func (f *Filter) each(item string, fn func(i uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	size := uint64(len(f.counters))
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		fn((h1 + i*h2) % size)
+	}
+}
+
+// Replace 用另一个过滤器的内容替换当前过滤器 替换后两者互不影响
+func (f *Filter) Replace(other *Filter) {
+	other.rwLock.RLock()
+	counters := make([]uint8, len(other.counters))
+	copy(counters, other.counters)
+	hashes := other.hashes
+	other.rwLock.RUnlock()
+	f.rwLock.Lock()
+	defer f.rwLock.Unlock()
+	f.hashes = hashes
+	f.counters = counters
+}
+
+// MarshalBinary 序列化过滤器 用于快照
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	f.rwLock.RLock()
+	defer f.rwLock.RUnlock()
+	data := make([]byte, 4, 4+len(f.counters))
+	binary.BigEndian.PutUint32(data, f.hashes)
+	return append(data, f.counters...), nil
+}
+
+// UnmarshalBinary 从快照中恢复过滤器
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < 5 {
+		return errors.New("布隆过滤器数据不完整")
+	}
+	hashes := binary.BigEndian.Uint32(data)
+	if hashes == 0 {
+		return errors.New("布隆过滤器的哈希函数数量不能为0")
+	}
+	counters := make([]uint8, len(data)-4)
+	copy(counters, data[4:])
+	f.rwLock.Lock()
+	defer f.rwLock.Unlock()
+	f.hashes = hashes
+	f.counters = counters
+	return nil
+}
@@ -0,0 +1,113 @@
+package bus
+
+import (
+	"container/list"
+	"sync"
+)
+
+// 学生变更的类型
+const (
+	OpAdd    = "add"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	// OpResync 发布方丢弃了事件 接收方应当像重新连接后一样淘汰所有本地数据
+	OpResync = "resync"
+)
+
+// Event 学生变更事件
+type Event struct {
+	StudentID string `json:"student_id"`
+	Op        string `json:"op"`
+	// Name 添加和更新后学生的姓名 接收方直接用它更新姓名索引 不需要读取数据库 删除时为空
+	Name string `json:"name,omitempty"`
+	// Seq 发布实例内单调递增的序号 接收方按实例比较 忽略重复和乱序到达的旧消息
+	Seq int64 `json:"seq"`
+	// Origin 发布事件的实例 实例会忽略自己发布的事件
+	Origin string `json:"origin"`
+}
+
+// Handler 处理收到的事件
+type Handler func(event Event)
+
+// InvalidationBus 在多个实例之间广播学生变更事件 让每个实例淘汰自己内存中的旧数据
+type InvalidationBus interface {
+	// Publish 发布事件
+	Publish(event Event) error
+	// Subscribe 开始接收事件 resync 在可能丢失了事件(例如重新连接)时调用 接收方应当淘汰所有本地数据
+	Subscribe(handler Handler, resync func()) error
+	Close() error
+}
+
+// versionFilterCapacity 版本过滤器最多记录的实例和学生的组合数 超过时淘汰最久没有收到事件的记录
+// 被淘汰的记录再收到旧事件时会重复处理一次 处理失效通知是幂等的 只会多淘汰一次本地数据
+const versionFilterCapacity = 100000
+
+// versionFilter 按发布实例记录每个学生最近处理过的序号 过滤重复和乱序的事件
+// 不同实例的序号互不相关 所以只和同一个实例之前的事件比较
+type versionFilter struct {
+	mu       sync.Mutex
+	capacity int
+	seqs     map[versionKey]*list.Element
+	// order 最近收到事件的记录在前面
+	order *list.List
+}
+
+type versionKey struct {
+	origin    string
+	studentID string
+}
+
+type versionEntry struct {
+	key versionKey
+	seq int64
+}
+
+func newVersionFilter() *versionFilter {
+	return newVersionFilterWithCapacity(versionFilterCapacity)
+}
+
+func newVersionFilterWithCapacity(capacity int) *versionFilter {
+	return &versionFilter{
+		capacity: capacity,
+		seqs:     make(map[versionKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// accept 事件的序号比同一个实例已处理过的序号新时返回true并记录
+func (f *versionFilter) accept(event Event) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := versionKey{origin: event.Origin, studentID: event.StudentID}
+	if element, exists := f.seqs[key]; exists {
+		entry := element.Value.(*versionEntry)
+		if event.Seq <= entry.seq {
+			return false
+		}
+		entry.seq = event.Seq
+		f.order.MoveToFront(element)
+		return true
+	}
+	f.seqs[key] = f.order.PushFront(&versionEntry{key: key, seq: event.Seq})
+	if f.order.Len() > f.capacity {
+		oldest := f.order.Back()
+		f.order.Remove(oldest)
+		delete(f.seqs, oldest.Value.(*versionEntry).key)
+	}
+	return true
+}
+
+// len 返回记录的数量
+func (f *versionFilter) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.order.Len()
+}
+
+// reset 可能丢失了事件时清空记录
+func (f *versionFilter) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seqs = make(map[versionKey]*list.Element)
+	f.order.Init()
+}
@@ -0,0 +1,45 @@
+package bus
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestVersionFilter 按实例过滤重复和乱序的事件
+func TestVersionFilter(t *testing.T) {
+	filter := newVersionFilter()
+	cases := []struct {
+		event Event
+		want  bool
+	}{
+		{Event{StudentID: "s1", Origin: "a", Seq: 5}, true},
+		{Event{StudentID: "s1", Origin: "a", Seq: 5}, false},
+		{Event{StudentID: "s1", Origin: "a", Seq: 4}, false},
+		{Event{StudentID: "s1", Origin: "b", Seq: 1}, true},
+		{Event{StudentID: "s2", Origin: "a", Seq: 1}, true},
+		{Event{StudentID: "s1", Origin: "a", Seq: 6}, true},
+	}
+	for i, c := range cases {
+		if got := filter.accept(c.event); got != c.want {
+			t.Fatalf("第%d个事件 %+v: accept = %v，期望 %v", i, c.event, got, c.want)
+		}
+	}
+}
+
+// TestVersionFilterCapacity 记录数超过上限时淘汰最久没有收到事件的记录
+func TestVersionFilterCapacity(t *testing.T) {
+	filter := newVersionFilterWithCapacity(3)
+	for i := 0; i < 10; i++ {
+		filter.accept(Event{StudentID: fmt.Sprintf("s%d", i), Origin: "a", Seq: 1})
+	}
+	if n := filter.len(); n != 3 {
+		t.Fatalf("记录数 = %d，期望 3", n)
+	}
+	// 最近的记录仍然过滤重复事件 被淘汰的记录重新接受
+	if filter.accept(Event{StudentID: "s9", Origin: "a", Seq: 1}) {
+		t.Fatalf("最近的记录没有过滤重复事件")
+	}
+	if !filter.accept(Event{StudentID: "s0", Origin: "a", Seq: 1}) {
+		t.Fatalf("被淘汰的记录仍然在过滤事件")
+	}
+}
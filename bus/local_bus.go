@@ -0,0 +1,85 @@
+package bus
+
+import (
+	"errors"
+	"sync"
+)
+
+// LocalHub 进程内的消息中心 同一进程中的多个 LocalBus 通过它互相通信 用于单机运行和测试
+type LocalHub struct {
+	mu   sync.RWMutex
+	subs map[*LocalBus]struct{}
+}
+
+func NewLocalHub() *LocalHub {
+	return &LocalHub{subs: make(map[*LocalBus]struct{})}
+}
+
+// LocalBus 进程内的 InvalidationBus 实现 每个实例各有一个
+type LocalBus struct {
+	hub    *LocalHub
+	origin string
+	filter *versionFilter
+	events chan Event
+	once   sync.Once
+	done   chan struct{}
+}
+
+// NewLocalBus 创建连接到 hub 的总线 origin 是当前实例的标识
+func NewLocalBus(hub *LocalHub, origin string) *LocalBus {
+	return &LocalBus{
+		hub:    hub,
+		origin: origin,
+		filter: newVersionFilter(),
+		events: make(chan Event, 1024),
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *LocalBus) Publish(event Event) error {
+	event.Origin = b.origin
+	b.hub.mu.RLock()
+	defer b.hub.mu.RUnlock()
+	for sub := range b.hub.subs {
+		if sub == b {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+		}
+	}
+	return nil
+}
+
+func (b *LocalBus) Subscribe(handler Handler, resync func()) error {
+	if handler == nil {
+		return errors.New("事件处理函数不能为空")
+	}
+	b.hub.mu.Lock()
+	b.hub.subs[b] = struct{}{}
+	b.hub.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case event := <-b.events:
+				if event.Origin != b.origin && b.filter.accept(event) {
+					handler(event)
+				}
+			case <-b.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *LocalBus) Close() error {
+	b.once.Do(func() {
+		b.hub.mu.Lock()
+		delete(b.hub.subs, b)
+		b.hub.mu.Unlock()
+		close(b.done)
+	})
+	return nil
+}
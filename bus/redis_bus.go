@@ -0,0 +1,222 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RedisBus 基于 Redis 发布订阅的 InvalidationBus 实现
+// Redis 的发布订阅本身不保证送达 这里通过三点做到至少一次：
+// 发布失败时在后台带退避地重试 直到发布成功；订阅连接断开重连后调用 resync 让接收方淘汰全部本地数据；
+// 发布队列一直是满的只能丢弃事件时 之后发布一个 OpResync 事件 让所有接收方调用 resync
+type RedisBus struct {
+	client  *redis.Client
+	channel string
+	origin  string
+	filter  *versionFilter
+	pending chan Event
+	// dropped 有事件因为队列已满被丢弃 resync 唤醒发布协程发布 OpResync 事件
+	dropped int32
+	resync  chan struct{}
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+	done   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// 发布重试的退避时间
+const (
+	publishRetryMin = 100 * time.Millisecond
+	publishRetryMax = 5 * time.Second
+
+	publishQueueSize = 4096
+)
+
+// publishBlockTimeout 发布队列已满时 Publish 最多等待的时间 超时后丢弃事件并通知所有实例重新同步
+var publishBlockTimeout = time.Second
+
+// NewRedisBus 创建使用指定频道的总线 origin 是当前实例的标识
+func NewRedisBus(client *redis.Client, channel, origin string) *RedisBus {
+	return newRedisBus(client, channel, origin, publishQueueSize)
+}
+
+func newRedisBus(client *redis.Client, channel, origin string, queueSize int) *RedisBus {
+	b := &RedisBus{
+		client:  client,
+		channel: channel,
+		origin:  origin,
+		filter:  newVersionFilter(),
+		pending: make(chan Event, queueSize),
+		resync:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.publishLoop()
+	return b
+}
+
+// Publish 把事件放入发布队列 队列满时最多等待 publishBlockTimeout
+// 仍然放不进去时丢弃事件 并在之后发布 OpResync 事件 接收方不会一直保留被丢弃的变更之前的数据
+func (b *RedisBus) Publish(event Event) error {
+	event.Origin = b.origin
+	select {
+	case b.pending <- event:
+		return nil
+	case <-b.done:
+		return errors.New("失效通知总线已关闭")
+	default:
+	}
+	timer := time.NewTimer(publishBlockTimeout)
+	defer timer.Stop()
+	select {
+	case b.pending <- event:
+		return nil
+	case <-b.done:
+		return errors.New("失效通知总线已关闭")
+	case <-timer.C:
+		atomic.StoreInt32(&b.dropped, 1)
+		select {
+		case b.resync <- struct{}{}:
+		default:
+		}
+		return errors.New("失效通知发布队列已满，已通知其他实例重新同步")
+	}
+}
+
+// publishLoop 按顺序发布队列中的事件 失败时一直重试 有事件被丢弃时发布 OpResync 事件
+func (b *RedisBus) publishLoop() {
+	defer b.wg.Done()
+	for {
+		select {
+		case event := <-b.pending:
+			b.publishWithRetry(event)
+		case <-b.resync:
+		case <-b.done:
+			return
+		}
+		if atomic.CompareAndSwapInt32(&b.dropped, 1, 0) {
+			log.Printf("发布队列已满时丢弃了失效通知，通知其他实例重新同步")
+			b.publishWithRetry(Event{Op: OpResync, Origin: b.origin})
+		}
+	}
+}
+
+func (b *RedisBus) publishWithRetry(event Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("序列化失效通知失败：%v", err)
+		return
+	}
+	backoff := publishRetryMin
+	for {
+		err = b.client.Publish(context.Background(), b.channel, payload).Err()
+		if err == nil {
+			return
+		}
+		log.Printf("发布学生：%s的失效通知失败：%v，%v后重试", event.StudentID, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-b.done:
+			return
+		}
+		backoff *= 2
+		if backoff > publishRetryMax {
+			backoff = publishRetryMax
+		}
+	}
+}
+
+// Subscribe 订阅频道 在后台处理收到的事件
+func (b *RedisBus) Subscribe(handler Handler, resync func()) error {
+	if handler == nil {
+		return errors.New("事件处理函数不能为空")
+	}
+	ctx := context.Background()
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	// 等待第一次订阅成功
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return err
+	}
+	b.mu.Lock()
+	b.pubsub = pubsub
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.receiveLoop(pubsub, handler, resync)
+	}()
+	return nil
+}
+
+func (b *RedisBus) receiveLoop(pubsub *redis.PubSub, handler Handler, resync func()) {
+	ctx := context.Background()
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			select {
+			case <-b.done:
+				return
+			default:
+			}
+			log.Printf("接收失效通知失败：%v", err)
+			time.Sleep(publishRetryMin)
+			continue
+		}
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			// 断线重连后会重新订阅 断开期间的事件可能已经丢失
+			if m.Kind == "subscribe" {
+				log.Printf("重新订阅失效通知频道：%s，淘汰本地数据", m.Channel)
+				b.filter.reset()
+				if resync != nil {
+					resync()
+				}
+			}
+		case *redis.Message:
+			var event Event
+			if err = json.Unmarshal([]byte(m.Payload), &event); err != nil {
+				log.Printf("解析失效通知失败：%v", err)
+				continue
+			}
+			if event.Origin == b.origin {
+				continue
+			}
+			if event.Op == OpResync {
+				log.Printf("实例：%s丢弃了失效通知，淘汰本地数据", event.Origin)
+				b.filter.reset()
+				if resync != nil {
+					resync()
+				}
+				continue
+			}
+			if !b.filter.accept(event) {
+				continue
+			}
+			handler(event)
+		}
+	}
+}
+
+func (b *RedisBus) Close() error {
+	var err error
+	b.once.Do(func() {
+		close(b.done)
+		b.mu.Lock()
+		if b.pubsub != nil {
+			err = b.pubsub.Close()
+		}
+		b.mu.Unlock()
+		b.wg.Wait()
+	})
+	return err
+}
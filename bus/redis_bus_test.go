@@ -0,0 +1,96 @@
+package bus
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// gateHook 让 PUBLISH 等待 gate 关闭 模拟发布很慢时队列被填满
+type gateHook struct {
+	gate chan struct{}
+}
+
+func (h gateHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h gateHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "publish" {
+			<-h.gate
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h gateHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestRedisBusResyncAfterDrop 发布队列已满时丢弃的事件不会静默丢失 之后的 OpResync 事件让接收方重新同步
+func TestRedisBusResyncAfterDrop(t *testing.T) {
+	mr := miniredis.RunT(t)
+	oldTimeout := publishBlockTimeout
+	publishBlockTimeout = 20 * time.Millisecond
+	defer func() { publishBlockTimeout = oldTimeout }()
+
+	subClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer subClient.Close()
+	receiver := NewRedisBus(subClient, "students", "node-b")
+	defer receiver.Close()
+	var mu sync.Mutex
+	var received []string
+	var resyncs int32
+	err := receiver.Subscribe(func(event Event) {
+		mu.Lock()
+		received = append(received, event.StudentID)
+		mu.Unlock()
+	}, func() { atomic.AddInt32(&resyncs, 1) })
+	if err != nil {
+		t.Fatalf("订阅失败：%v", err)
+	}
+
+	gate := make(chan struct{})
+	pubClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer pubClient.Close()
+	pubClient.AddHook(gateHook{gate: gate})
+	publisher := newRedisBus(pubClient, "students", "node-a", 1)
+	defer publisher.Close()
+
+	// s1 正在发布 s2 占满队列 s3 等待超时后被丢弃
+	if err = publisher.Publish(Event{StudentID: "s1", Op: OpUpdate, Seq: 1}); err != nil {
+		t.Fatalf("发布 s1 失败：%v", err)
+	}
+	waitUntil(t, "s1 离开队列", func() bool { return len(publisher.pending) == 0 })
+	if err = publisher.Publish(Event{StudentID: "s2", Op: OpUpdate, Seq: 2}); err != nil {
+		t.Fatalf("发布 s2 失败：%v", err)
+	}
+	if err = publisher.Publish(Event{StudentID: "s3", Op: OpUpdate, Seq: 3}); err == nil {
+		t.Fatalf("队列已满时发布 s3 没有返回错误")
+	}
+	close(gate)
+
+	waitUntil(t, "接收方重新同步", func() bool { return atomic.LoadInt32(&resyncs) == 1 })
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0] != "s1" || received[1] != "s2" {
+		t.Fatalf("收到的事件 = %v，期望 s1 s2", received)
+	}
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// 缓存层的实现
+const (
+	CacheBackendRedis  = "redis"
+	CacheBackendMemory = "memory"
+)
+
+// 持久层的数据库
+const (
+	DBDriverMySQL  = "mysql"
+	DBDriverSQLite = "sqlite"
+)
+
+// Config 服务启动参数 默认值就是原来写死在 main 中的配置
+type Config struct {
+	// DBDriver 持久层使用的数据库 mysql 或 sqlite(嵌入式 不需要数据库服务器)
+	DBDriver   string
+	MysqlDSN   string
+	SQLitePath string
+	// AutoMigrate 启动时执行没有执行过的数据库迁移
+	AutoMigrate   bool
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	// CacheBackend 缓存层的实现 redis 或 memory(进程内 不需要 Redis 服务器)
+	CacheBackend string
+	// CacheScanBatchSize 从 Redis 批量加载学生时每批的键数量
+	CacheScanBatchSize int
+	// CacheTTLJitter 缓存键过期时间的随机抖动比例
+	CacheTTLJitter float64
+	// CacheSlidingTTL 读取缓存中的学生时把过期时间延长到多久之后 0表示不延长
+	CacheSlidingTTL time.Duration
+	// EarlyRefreshBeta 内存中的学生快过期时提前刷新的激进程度 0表示不提前刷新
+	EarlyRefreshBeta float64
+	// NegativeCacheTTL 不存在的学号在内存中缓存多久 0表示不缓存
+	NegativeCacheTTL time.Duration
+	// StudentFilter 是否使用布隆过滤器拒绝不存在的学号
+	StudentFilter                  bool
+	StudentFilterExpectedItems     uint
+	StudentFilterFalsePositiveRate float64
+	// Invalidation 是否通过 Redis 发布订阅在多个实例之间广播学生变更
+	Invalidation        bool
+	InvalidationChannel string
+	// InstanceID 当前实例的标识 用于忽略自己发布的失效通知
+	InstanceID string
+	// OutboxOriginFile 保存发件箱实例标识的文件 共享数据库的实例必须使用不同的文件
+	OutboxOriginFile string
+	// WriteBehind 写请求先写本地日志 由后台批量写入数据库
+	WriteBehind           bool
+	WriteBehindDir        string
+	WriteBehindBatchSize  int
+	WriteBehindInterval   time.Duration
+	WriteBehindMaxPending int
+	// AccessCount 访问次数在内存中累积后批量写入数据库
+	AccessCountInterval   time.Duration
+	AccessCountMaxPending int
+	// HotStudents 热点学生的数量和访问次数衰减一半的时间 总访问次数达到 MinHits 后才使用统计出的热点
+	HotStudentsK        int
+	HotStudentsHalfLife time.Duration
+	HotStudentsMinHits  int
+	// SoftDeleteRetention 删除的学生可以恢复的时间 PurgeInterval 清理超过保留期的学生的间隔
+	SoftDeleteRetention time.Duration
+	PurgeInterval       time.Duration
+	HTTPAddr            string
+	// RESPAddr 默认只监听本机 监听其他地址时必须设置 RESPPassword
+	RESPAddr     string
+	RESPPassword string
+	RaftID       string
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		MysqlDSN:                       "root:1234@tcp(127.0.0.1:3306)/mdb?charset=utf8mb4&parseTime=True&loc=Local",
+		RedisAddr:                      "192.168.88.128:6379",
+		RedisPassword:                  "123456",
+		RedisDB:                        0,
+		DBDriver:                       DBDriverMySQL,
+		SQLitePath:                     "data/students.db",
+		AutoMigrate:                    true,
+		CacheBackend:                   CacheBackendRedis,
+		CacheScanBatchSize:             500,
+		CacheTTLJitter:                 0.1,
+		NegativeCacheTTL:               30 * time.Second,
+		StudentFilterExpectedItems:     1000000,
+		StudentFilterFalsePositiveRate: 0.01,
+		InvalidationChannel:            "student:invalidation",
+		InstanceID:                     defaultInstanceID(),
+		OutboxOriginFile:               "data/outbox-origin",
+		WriteBehindDir:                 "data/write-behind",
+		WriteBehindBatchSize:           100,
+		WriteBehindInterval:            200 * time.Millisecond,
+		WriteBehindMaxPending:          10000,
+		AccessCountInterval:            5 * time.Second,
+		AccessCountMaxPending:          100000,
+		HotStudentsK:                   10,
+		HotStudentsHalfLife:            10 * time.Minute,
+		HotStudentsMinHits:             100,
+		SoftDeleteRetention:            7 * 24 * time.Hour,
+		PurgeInterval:                  time.Hour,
+		HTTPAddr:                       ":8080",
+		RESPAddr:                       "127.0.0.1:6380",
+		RaftID:                         "127.0.0.1",
+	}
+}
+
+// DatabaseDSN 返回所选数据库的连接串 SQLite 的连接串就是数据库文件的路径
+func (c *Config) DatabaseDSN() string {
+	if c.DBDriver == DBDriverSQLite {
+		return c.SQLitePath
+	}
+	return c.MysqlDSN
+}
+
+// defaultInstanceID 使用主机名和进程号作为默认的实例标识
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// RegisterFlags 把配置项注册为命令行参数
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.DBDriver, "db-driver", c.DBDriver, "持久层使用的数据库：mysql 或 sqlite")
+	fs.StringVar(&c.MysqlDSN, "mysql-dsn", c.MysqlDSN, "MySQL 连接串")
+	fs.StringVar(&c.SQLitePath, "sqlite-path", c.SQLitePath, "SQLite 数据库文件的路径")
+	fs.BoolVar(&c.AutoMigrate, "auto-migrate", c.AutoMigrate, "启动时执行没有执行过的数据库迁移")
+	fs.StringVar(&c.RedisAddr, "redis-addr", c.RedisAddr, "Redis 地址")
+	fs.StringVar(&c.RedisPassword, "redis-password", c.RedisPassword, "Redis 密码")
+	fs.IntVar(&c.RedisDB, "redis-db", c.RedisDB, "Redis 库编号")
+	fs.StringVar(&c.CacheBackend, "cache", c.CacheBackend, "缓存层实现：redis 或 memory")
+	fs.IntVar(&c.CacheScanBatchSize, "cache-scan-batch", c.CacheScanBatchSize, "从 Redis 批量加载学生时每批的键数量")
+	fs.Float64Var(&c.CacheTTLJitter, "cache-ttl-jitter", c.CacheTTLJitter, "缓存键过期时间的随机抖动比例")
+	fs.DurationVar(&c.CacheSlidingTTL, "cache-sliding-ttl", c.CacheSlidingTTL, "读取缓存时把过期时间延长到多久之后 0表示不延长")
+	fs.Float64Var(&c.EarlyRefreshBeta, "early-refresh-beta", c.EarlyRefreshBeta, "内存中的学生快过期时提前刷新的激进程度 0表示不提前刷新")
+	fs.DurationVar(&c.NegativeCacheTTL, "negative-cache-ttl", c.NegativeCacheTTL, "不存在的学号在内存中缓存多久 0表示不缓存")
+	fs.BoolVar(&c.StudentFilter, "student-filter", c.StudentFilter, "使用布隆过滤器拒绝不存在的学号")
+	fs.UintVar(&c.StudentFilterExpectedItems, "student-filter-items", c.StudentFilterExpectedItems, "布隆过滤器预计的学生数量")
+	fs.Float64Var(&c.StudentFilterFalsePositiveRate, "student-filter-fp", c.StudentFilterFalsePositiveRate, "布隆过滤器可以接受的误判率")
+	fs.BoolVar(&c.Invalidation, "invalidation", c.Invalidation, "通过 Redis 发布订阅在多个实例之间广播学生变更")
+	fs.StringVar(&c.InvalidationChannel, "invalidation-channel", c.InvalidationChannel, "失效通知使用的 Redis 频道")
+	fs.StringVar(&c.InstanceID, "instance-id", c.InstanceID, "当前实例的标识")
+	fs.StringVar(&c.OutboxOriginFile, "outbox-origin-file", c.OutboxOriginFile, "保存发件箱实例标识的文件 第一次启动时生成随机标识")
+	fs.BoolVar(&c.WriteBehind, "write-behind", c.WriteBehind, "写请求先写本地日志 由后台批量写入数据库")
+	fs.StringVar(&c.WriteBehindDir, "write-behind-dir", c.WriteBehindDir, "异步写数据库的本地日志目录")
+	fs.IntVar(&c.WriteBehindBatchSize, "write-behind-batch", c.WriteBehindBatchSize, "每个数据库事务最多写入的变更数")
+	fs.DurationVar(&c.WriteBehindInterval, "write-behind-interval", c.WriteBehindInterval, "检查待写入变更的间隔")
+	fs.IntVar(&c.WriteBehindMaxPending, "write-behind-max-pending", c.WriteBehindMaxPending, "最多积压的变更数 超过后写请求等待")
+	fs.DurationVar(&c.AccessCountInterval, "access-count-interval", c.AccessCountInterval, "把累积的访问次数写入数据库的间隔")
+	fs.IntVar(&c.AccessCountMaxPending, "access-count-max-pending", c.AccessCountMaxPending, "内存中最多累积访问次数的学生数 超过后新学生的访问不计数")
+	fs.IntVar(&c.HotStudentsK, "hot-k", c.HotStudentsK, "统计的热点学生数 也是加载到缓存和内存的学生数")
+	fs.DurationVar(&c.HotStudentsHalfLife, "hot-half-life", c.HotStudentsHalfLife, "热点学生的访问次数衰减一半的时间")
+	fs.IntVar(&c.HotStudentsMinHits, "hot-min-hits", c.HotStudentsMinHits, "衰减后的总访问次数达到这个值后才用统计出的热点替换缓存 之前使用数据库中访问次数最多的学生")
+	fs.DurationVar(&c.SoftDeleteRetention, "soft-delete-retention", c.SoftDeleteRetention, "删除的学生在这段时间内可以恢复 之后被彻底删除")
+	fs.DurationVar(&c.PurgeInterval, "purge-interval", c.PurgeInterval, "彻底删除超过保留期的学生的间隔")
+	fs.StringVar(&c.HTTPAddr, "http-addr", c.HTTPAddr, "HTTP 服务监听地址")
+	fs.StringVar(&c.RESPAddr, "resp-addr", c.RESPAddr, "RESP 服务监听地址 为空时不启动")
+	fs.StringVar(&c.RESPPassword, "resp-password", c.RESPPassword, "RESP 服务的密码 不为空时客户端需要先 AUTH")
+	fs.StringVar(&c.RaftID, "raft-id", c.RaftID, "Raft 节点 ID")
+}
+
+// Parse 解析命令行参数并校验
+func Parse(name string, args []string) (*Config, error) {
+	c := Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate 校验配置
+func (c *Config) Validate() error {
+	if c.DBDriver != DBDriverMySQL && c.DBDriver != DBDriverSQLite {
+		return fmt.Errorf("不支持的数据库：%s", c.DBDriver)
+	}
+	if c.CacheBackend != CacheBackendRedis && c.CacheBackend != CacheBackendMemory {
+		return fmt.Errorf("不支持的缓存实现：%s", c.CacheBackend)
+	}
+	if c.CacheScanBatchSize <= 0 {
+		return fmt.Errorf("cache-scan-batch 必须是正整数")
+	}
+	if c.EarlyRefreshBeta < 0 {
+		return fmt.Errorf("early-refresh-beta 不能小于0")
+	}
+	if c.StudentFilterFalsePositiveRate <= 0 || c.StudentFilterFalsePositiveRate >= 1 {
+		return fmt.Errorf("student-filter-fp 必须在0到1之间")
+	}
+	if c.CacheTTLJitter < 0 || c.CacheTTLJitter > 1 {
+		return fmt.Errorf("cache-ttl-jitter 必须在0到1之间")
+	}
+	if c.WriteBehindBatchSize <= 0 || c.WriteBehindMaxPending <= 0 {
+		return fmt.Errorf("write-behind-batch 和 write-behind-max-pending 必须是正整数")
+	}
+	if c.WriteBehindInterval <= 0 {
+		return fmt.Errorf("write-behind-interval 必须大于0")
+	}
+	if c.AccessCountInterval <= 0 || c.AccessCountMaxPending <= 0 {
+		return fmt.Errorf("access-count-interval 和 access-count-max-pending 必须大于0")
+	}
+	if c.HotStudentsK <= 0 || c.HotStudentsHalfLife <= 0 {
+		return fmt.Errorf("hot-k 和 hot-half-life 必须大于0")
+	}
+	if c.HotStudentsMinHits < 0 {
+		return fmt.Errorf("hot-min-hits 不能小于0")
+	}
+	if c.RESPAddr != "" && c.RESPPassword == "" && !loopbackAddr(c.RESPAddr) {
+		return fmt.Errorf("resp-addr 监听的不是本机地址时必须设置 resp-password")
+	}
+	if c.SoftDeleteRetention <= 0 || c.PurgeInterval <= 0 {
+		return fmt.Errorf("soft-delete-retention 和 purge-interval 必须大于0")
+	}
+	return nil
+}
+
+// loopbackAddr 判断监听地址是不是只有本机可以访问 主机名为空表示监听所有地址
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
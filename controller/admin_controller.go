@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"memoryDataBase/response"
+	"memoryDataBase/service"
+	"net/http"
+	"strconv"
+)
+
+type AdminController struct {
+	adminService *service.AdminService
+}
+
+func NewAdminController(adminService *service.AdminService) *AdminController {
+	return &AdminController{
+		adminService: adminService,
+	}
+}
+
+// MemoryDBInfo 查看内存数据库的内存占用和命中率等统计信息
+func (ac *AdminController) MemoryDBInfo(c *gin.Context) {
+	c.JSON(http.StatusOK, response.Success(ac.adminService.MemoryDBInfo()))
+}
+
+// LoaderStats 查看读穿透加载的统计信息
+func (ac *AdminController) LoaderStats(c *gin.Context) {
+	c.JSON(http.StatusOK, response.Success(ac.adminService.LoaderStats()))
+}
+
+// WriteBehindStats 查看异步写数据库的积压和重试情况
+func (ac *AdminController) WriteBehindStats(c *gin.Context) {
+	stats := ac.adminService.WriteBehindStats()
+	if stats == nil {
+		c.JSON(http.StatusNotFound, response.Error("没有启用异步写数据库"))
+		return
+	}
+	c.JSON(http.StatusOK, response.Success(stats))
+}
+
+// HotStudents 查看当前的热点学生和衰减后的估计访问次数
+func (ac *AdminController) HotStudents(c *gin.Context) {
+	c.JSON(http.StatusOK, response.Success(ac.adminService.HotStudents()))
+}
+
+// AccessCounterStats 查看访问计数的积压和丢弃情况
+func (ac *AdminController) AccessCounterStats(c *gin.Context) {
+	c.JSON(http.StatusOK, response.Success(ac.adminService.AccessCounterStats()))
+}
+
+// StartReconcile 在后台对账 参数 repair dryRun rate 结果通过 GET /admin/reconcile 查看
+func (ac *AdminController) StartReconcile(c *gin.Context) {
+	repair, err := strconv.ParseBool(c.DefaultQuery("repair", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.Error("repair必须是true或false"))
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.Error("dryRun必须是true或false"))
+		return
+	}
+	rate, err := strconv.Atoi(c.DefaultQuery("rate", "500"))
+	if err != nil || rate < 0 {
+		c.JSON(http.StatusBadRequest, response.Error("rate必须是非负整数"))
+		return
+	}
+	options := service.ReconcileOptions{Repair: repair, DryRun: dryRun, Rate: rate}
+	if err = ac.adminService.StartReconcile(options); err != nil {
+		c.JSON(http.StatusConflict, response.Error(err.Error()))
+		return
+	}
+	c.JSON(http.StatusAccepted, response.Success(options))
+}
+
+// ReconcileStatus 查看最近一次对账的报告
+func (ac *AdminController) ReconcileStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, response.Success(ac.adminService.ReconcileStatus()))
+}
+
+// ScanKeys 按游标分批列出命名空间中的键 参数 namespace cursor match count
+func (ac *AdminController) ScanKeys(c *gin.Context) {
+	namespace := c.DefaultQuery("namespace", service.StudentNamespace)
+	cursor, err := strconv.ParseUint(c.DefaultQuery("cursor", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.Error("游标格式错误"))
+		return
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", "100"))
+	if err != nil || count <= 0 {
+		c.JSON(http.StatusBadRequest, response.Error("count必须是正整数"))
+		return
+	}
+	page, err := ac.adminService.ScanKeys(namespace, cursor, c.DefaultQuery("match", "*"), count)
+	if err != nil {
+		c.JSON(http.StatusNotFound, response.Error(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, response.Success(page))
+}
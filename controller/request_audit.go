@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"memoryDataBase/model"
+)
+
+const (
+	actorHeader     = "X-Actor"
+	requestIDHeader = "X-Request-ID"
+	// 和审计表中对应列的长度一致
+	maxActorLength     = 128
+	maxRequestIDLength = 64
+)
+
+// requestAudit 从请求头中读取发起变更的人和请求号 写入审计记录
+// 没有 X-Actor 时使用客户端地址 没有 X-Request-ID 时生成一个 请求号在响应头中返回
+func requestAudit(c *gin.Context) model.AuditContext {
+	actor := c.GetHeader(actorHeader)
+	if actor == "" {
+		actor = c.ClientIP()
+	}
+	requestID := c.GetHeader(requestIDHeader)
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	c.Header(requestIDHeader, requestID)
+	return model.AuditContext{
+		Actor:     truncate(actor, maxActorLength),
+		RequestID: truncate(requestID, maxRequestIDLength),
+	}
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+func truncate(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+	return value[:limit]
+}
@@ -1,14 +1,27 @@
 package controller
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"log"
 	"memoryDataBase/model"
 	"memoryDataBase/response"
 	"memoryDataBase/service"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// maxHistoryPageSize 查询变更记录时每页最多的记录数
+const maxHistoryPageSize = 100
+
+// maxListPageSize 列出学生时每页最多的学生数
+const maxListPageSize = 100
+
+// maxSearchResults 按姓名搜索时最多返回的结果数
+const maxSearchResults = 50
+
 type StudentController struct {
 	studentService *service.StudentService
 }
@@ -23,23 +36,39 @@ func (sc *StudentController) AddStudent(c *gin.Context) {
 	var student model.Student
 	if err := c.BindJSON(&student); err != nil {
 		c.JSON(http.StatusBadRequest, response.Error(err.Error()))
-	} else if err = sc.studentService.AddStudentInternal(&student); err != nil {
-		c.JSON(http.StatusBadRequest, response.Error(err.Error()))
+	} else if err = sc.studentService.AddStudentInternal(&student, requestAudit(c)); err != nil {
+		status := http.StatusBadRequest
+		if sc.studentService.DeletedConflictErr(err) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, response.Error(err.Error()))
 	} else {
 		log.Printf("添加学号为：%s的学生", student.ID)
+		c.Header("ETag", studentETag(student.Version))
 		c.JSON(http.StatusOK, response.SuccessWithoutData())
 	}
 }
 
 func (sc *StudentController) GetStudent(c *gin.Context) {
 	studentId := c.Param("id")
+	if asOf := c.Query("asOf"); asOf != "" {
+		sc.getStudentAsOf(c, studentId, asOf)
+		return
+	}
 	resp, err := sc.studentService.GetStudent(studentId)
 	if err != nil {
 		c.JSON(500, response.Error(err.Error()))
-	} else {
-		log.Printf("查询学号为：%s的学生", studentId)
-		c.JSON(http.StatusOK, response.Success(resp))
+		return
+	}
+	log.Printf("查询学号为：%s的学生", studentId)
+	etag := studentETag(resp.Version)
+	c.Header("ETag", etag)
+	// 客户端缓存的版本仍然是最新的 不需要返回学生
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		c.Status(http.StatusNotModified)
+		return
 	}
+	c.JSON(http.StatusOK, response.Success(resp))
 }
 
 func (sc *StudentController) UpdateStudent(c *gin.Context) {
@@ -48,22 +77,205 @@ func (sc *StudentController) UpdateStudent(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, response.Error(err.Error()))
 		return
 	}
-	err := sc.studentService.UpdateStudentInternal(&student)
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+	student.Version = version
+	err := sc.studentService.UpdateStudentInternal(&student, requestAudit(c))
 	if err != nil {
-		c.JSON(http.StatusNotFound, response.Error(err.Error()))
+		c.JSON(sc.writeErrorStatus(err), response.Error(err.Error()))
 	} else {
 		log.Printf("修改学生：%s", student.ID)
+		c.Header("ETag", studentETag(student.Version))
 		c.JSON(http.StatusOK, response.Success(nil))
 	}
 }
 
 func (sc *StudentController) DeleteStudent(c *gin.Context) {
 	studentId := c.Param("id")
-	err := sc.studentService.DeleteStudentInternal(studentId)
+	version, ok := requireIfMatch(c)
+	if !ok {
+		return
+	}
+	err := sc.studentService.DeleteStudentInternal(studentId, version, requestAudit(c))
 	if err != nil {
-		c.JSON(http.StatusNotFound, response.Error(err.Error()))
+		c.JSON(sc.writeErrorStatus(err), response.Error(err.Error()))
 	} else {
 		log.Printf("删除学号为：%s的学生", studentId)
 		c.JSON(http.StatusOK, response.Success(nil))
 	}
 }
+
+// getStudentAsOf 返回学生在 asOf 时的状态 asOf 使用 RFC3339 格式 例如 2026-06-01T00:00:00Z
+func (sc *StudentController) getStudentAsOf(c *gin.Context, studentId string, asOf string) {
+	at, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response.Error(fmt.Sprintf("asOf必须是RFC3339格式的时间：%s", asOf)))
+		return
+	}
+	resp, err := sc.studentService.GetStudentAsOf(studentId, at)
+	if err != nil {
+		if sc.studentService.StudentNotFoundErr(studentId, err) {
+			c.JSON(http.StatusNotFound, response.Error(err.Error()))
+		} else {
+			c.JSON(http.StatusInternalServerError, response.Error(err.Error()))
+		}
+		return
+	}
+	log.Printf("查询学号为：%s的学生在%s时的状态", studentId, asOf)
+	c.JSON(http.StatusOK, response.Success(resp))
+}
+
+// RestoreStudent 恢复保留期内被删除的学生 返回恢复后的学生
+func (sc *StudentController) RestoreStudent(c *gin.Context) {
+	studentId := c.Param("id")
+	err := sc.studentService.RestoreStudentInternal(studentId, requestAudit(c))
+	switch {
+	case err == nil:
+	case sc.studentService.RestoreExpiredErr(studentId, err):
+		c.JSON(http.StatusNotFound, response.Error(err.Error()))
+		return
+	case sc.studentService.RestorePendingErr(err), sc.studentService.StudentModifiedErr(err):
+		c.JSON(http.StatusConflict, response.Error(err.Error()))
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, response.Error(err.Error()))
+		return
+	}
+	log.Printf("恢复学号为：%s的学生", studentId)
+	resp, err := sc.studentService.GetStudent(studentId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.Error(err.Error()))
+		return
+	}
+	c.Header("ETag", studentETag(resp.Version))
+	c.JSON(http.StatusOK, response.Success(resp))
+}
+
+// ListStudents 按条件列出学生 用上一页返回的 cursor 翻页
+// 过滤参数 class gender name(姓名前缀) score(学科:最低分:最高分 可以有多个)
+// 排序参数 sort 为 id name avg 或者 score.学科 前面加 - 表示倒序
+func (sc *StudentController) ListStudents(c *gin.Context) {
+	query := model.StudentQuery{
+		Class:      c.Query("class"),
+		Gender:     c.Query("gender"),
+		NamePrefix: c.Query("name"),
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > maxListPageSize {
+		c.JSON(http.StatusBadRequest, response.Error(fmt.Sprintf("limit必须在1到%d之间", maxListPageSize)))
+		return
+	}
+	query.Limit = limit
+	for _, value := range c.QueryArray("score") {
+		scoreRange, err := service.ParseScoreRange(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, response.Error(err.Error()))
+			return
+		}
+		query.Scores = append(query.Scores, scoreRange)
+	}
+	if err = service.ParseStudentSort(c.Query("sort"), &query); err != nil {
+		c.JSON(http.StatusBadRequest, response.Error(err.Error()))
+		return
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if err = service.DecodeStudentCursor(cursor, &query); err != nil {
+			c.JSON(http.StatusBadRequest, response.Error(err.Error()))
+			return
+		}
+	}
+	page, err := sc.studentService.ListStudents(&query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.Error(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, response.Success(page))
+}
+
+// SearchStudents 按姓名模糊搜索学生 q 可以是姓名的一部分 有拼写错误的姓名 汉字姓名的全拼或首字母
+func (sc *StudentController) SearchStudents(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, response.Error("q不能为空"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > maxSearchResults {
+		c.JSON(http.StatusBadRequest, response.Error(fmt.Sprintf("limit必须在1到%d之间", maxSearchResults)))
+		return
+	}
+	log.Printf("按姓名搜索学生：%s", query)
+	c.JSON(http.StatusOK, response.Success(sc.studentService.SearchStudents(query, limit)))
+}
+
+// GetStudentHistory 按时间倒序分页查看学生的变更记录 参数 page 从1开始 size 最大100
+func (sc *StudentController) GetStudentHistory(c *gin.Context) {
+	studentId := c.Param("id")
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, response.Error("page必须是正整数"))
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "20"))
+	if err != nil || size < 1 || size > maxHistoryPageSize {
+		c.JSON(http.StatusBadRequest, response.Error(fmt.Sprintf("size必须在1到%d之间", maxHistoryPageSize)))
+		return
+	}
+	history, err := sc.studentService.GetStudentHistory(studentId, page, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response.Error(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, response.Success(history))
+}
+
+// writeErrorStatus 更新和删除失败时的状态码 版本号不匹配返回412 检查之后被其他请求修改返回409
+func (sc *StudentController) writeErrorStatus(err error) int {
+	switch {
+	case sc.studentService.VersionMismatchErr(err):
+		return http.StatusPreconditionFailed
+	case sc.studentService.StudentModifiedErr(err):
+		return http.StatusConflict
+	default:
+		return http.StatusNotFound
+	}
+}
+
+// studentETag 用学生的版本号作为强 ETag
+func studentETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// requireIfMatch 读取 If-Match 请求头中的版本号
+// 没有请求头或者无法解析时不可能和学生的版本匹配 返回412 请求不能用 * 跳过版本号检查
+func requireIfMatch(c *gin.Context) (int64, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		c.JSON(http.StatusPreconditionFailed, response.Error("修改学生时必须带上 If-Match 请求头"))
+		return 0, false
+	}
+	// If-Match 使用强比较 弱 ETag、多个 ETag 和 * 都不支持
+	if len(header) < 3 || header[0] != '"' || header[len(header)-1] != '"' {
+		c.JSON(http.StatusPreconditionFailed, response.Error(fmt.Sprintf("无法识别的 If-Match：%s", header)))
+		return 0, false
+	}
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusPreconditionFailed, response.Error(fmt.Sprintf("无法识别的 If-Match：%s", header)))
+		return 0, false
+	}
+	return version, true
+}
+
+// etagMatches 判断 If-None-Match 请求头中是否有和 etag 相同的值 按弱比较忽略 W/ 前缀
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
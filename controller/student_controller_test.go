@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		header  string
+		version int64
+		status  int
+	}{
+		{header: `"3"`, version: 3, status: http.StatusOK},
+		{header: ` "12" `, version: 12, status: http.StatusOK},
+		// 没有请求头和 * 都不能跳过版本号检查
+		{header: "", status: http.StatusPreconditionFailed},
+		{header: "*", status: http.StatusPreconditionFailed},
+		{header: `W/"3"`, status: http.StatusPreconditionFailed},
+		{header: `"3", "4"`, status: http.StatusPreconditionFailed},
+		{header: `"0"`, status: http.StatusPreconditionFailed},
+		{header: "3", status: http.StatusPreconditionFailed},
+	} {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodPut, "/student", nil)
+		if tc.header != "" {
+			c.Request.Header.Set("If-Match", tc.header)
+		}
+		version, ok := requireIfMatch(c)
+		if ok != (tc.status == http.StatusOK) || version != tc.version || recorder.Code != tc.status {
+			t.Errorf("If-Match：%q 返回 %d, %v，状态码 %d，期望 %d 和状态码 %d", tc.header, version, ok, recorder.Code, tc.version, tc.status)
+		}
+	}
+}
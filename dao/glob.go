@@ -0,0 +1,103 @@
+package dao
+
+// GlobMatch 判断字符串是否匹配 Redis 风格的通配符模式
+// 支持 * ? [abc] [^abc] [a-z] 以及用 \ 转义特殊字符
+// 只记录最后一个 * 的位置 匹配失败时让它多匹配一个字符后重试 时间复杂度为 O(len(pattern)*len(str))
+func GlobMatch(pattern, str string) bool {
+	p, s := 0, 0
+	// starP 是最后一个 * 之后的模式位置 starS 是这个 * 匹配到的字符串位置
+	starP, starS := -1, 0
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				// 合并连续的 *
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starS = p, s
+				continue
+			case '?':
+				p++
+				s++
+				continue
+			case '[':
+				end, matched := matchClass(pattern[p:], str[s])
+				if end < 0 {
+					// 没有闭合的 ] 按普通字符处理
+					if str[s] == '[' {
+						p++
+						s++
+						continue
+					}
+				} else if matched {
+					p += end + 1
+					s++
+					continue
+				}
+			case '\\':
+				escaped := p
+				if p+1 < len(pattern) {
+					escaped = p + 1
+				}
+				if pattern[escaped] == str[s] {
+					p = escaped + 1
+					s++
+					continue
+				}
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, s = starP, starS
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass 匹配以 [ 开头的字符集合 返回 ] 的下标和是否匹配 找不到 ] 时下标为-1
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := false
+	if i < len(pattern) && pattern[i] == '^' {
+		negate = true
+		i++
+	}
+	matched := false
+	for first := true; i < len(pattern); first = false {
+		if pattern[i] == ']' && !first {
+			return i, matched != negate
+		}
+		lo := pattern[i]
+		if lo == '\\' && i+1 < len(pattern) {
+			i++
+			lo = pattern[i]
+		}
+		hi := lo
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			i += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+	return -1, false
+}
@@ -0,0 +1,58 @@
+package dao
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, str string
+		want         bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"", "", true},
+		{"", "a", false},
+		{"a*", "abc", true},
+		{"*c", "abc", true},
+		{"*b*", "abc", true},
+		{"a*d", "abc", false},
+		{"a**c", "abc", true},
+		{"user:*:name", "user:1:name", true},
+		{"user:*:name", "user:1:age", false},
+		{"?", "a", true},
+		{"?", "", false},
+		{"a?c", "abc", true},
+		{"[abc]", "b", true},
+		{"[^abc]", "b", false},
+		{"[a-c]x", "bx", true},
+		{"[c-a]x", "bx", true},
+		{"*[0-9]", "key9", true},
+		{"*[0-9]", "key", false},
+		{"[abc", "[abc", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\`, `a\`, true},
+		{"*a*a*a*b", "aaaab", true},
+		{"*a*a*a*b", "aaaa", false},
+	} {
+		if got := GlobMatch(tc.pattern, tc.str); got != tc.want {
+			t.Errorf("GlobMatch(%q, %q) = %v，期望 %v", tc.pattern, tc.str, got, tc.want)
+		}
+	}
+}
+
+// TestGlobMatchNoBacktrackBlowup 多个 * 的模式不会因为回溯变成指数时间
+func TestGlobMatchNoBacktrackBlowup(t *testing.T) {
+	pattern := strings.Repeat("*a", 20) + "*b"
+	str := strings.Repeat("a", 5000)
+	start := time.Now()
+	if GlobMatch(pattern, str) {
+		t.Fatalf("不应该匹配")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("匹配用了%v", elapsed)
+	}
+}
@@ -2,84 +2,166 @@ package dao
 
 import (
 	"log"
-	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var Expiration = time.Hour
 
-// MemoryDBDao 内存数据库 DAO 结构体
+// TTLPolicy 键空间的过期策略
+type TTLPolicy struct {
+	// DefaultTTL SetDefault 使用的过期时间 0表示永不过期
+	DefaultTTL time.Duration
+	// SlidingTTL 访问未过期的键时把过期时间延长到多久之后 0表示不延长
+	SlidingTTL time.Duration
+	// MaxKeys 键空间最多保存的键数量 超出时淘汰最快过期的键 0表示不限制
+	MaxKeys int
+}
+
+// evictionSamples 淘汰键时随机采样的键数量
+const evictionSamples = 5
+
+// DefaultTTLPolicy 默认的过期策略 访问时把过期时间延长 Expiration
+func DefaultTTLPolicy() TTLPolicy {
+	return TTLPolicy{SlidingTTL: Expiration}
+}
+
+// MemoryDBDao 内存数据库 DAO 结构体 每个实例是一个独立的键空间
 type MemoryDBDao struct {
+	name    string
+	policy  TTLPolicy
 	dataMap map[string]interface{}
 	expires map[string]time.Time
 	rwLock  sync.RWMutex
+
+	// 以下统计信息除 lockWait 外都只在持有写锁时修改
+	sizes       map[string]int64
+	usedBytes   int64
+	entrySizes  sizeHistogram
+	hits        int64
+	misses      int64
+	expiredKeys int64
+	evictedKeys int64
+	lockWait    lockWaitHistogram
+
+	// scanIndex 用于游标遍历和定期删除
+	scanIndex scanIndex
 }
 
 // NewMemoryDBDao 创建一个新的内存数据库实例
 func NewMemoryDBDao() *MemoryDBDao {
+	return NewMemoryDBDaoWithPolicy(DefaultNamespace, DefaultTTLPolicy())
+}
+
+// NewMemoryDBDaoWithPolicy 创建一个使用指定名称和过期策略的内存数据库实例
+func NewMemoryDBDaoWithPolicy(name string, policy TTLPolicy) *MemoryDBDao {
 	mdb := &MemoryDBDao{
+		name:    name,
+		policy:  policy,
 		dataMap: make(map[string]interface{}),
 		expires: make(map[string]time.Time),
+		sizes:   make(map[string]int64),
 	}
 	return mdb
 }
 
-// Set 设置键值对并设置过期时间
+// Name 返回键空间的名称
+func (mdb *MemoryDBDao) Name() string {
+	return mdb.name
+}
+
+// Policy 返回键空间的过期策略
+func (mdb *MemoryDBDao) Policy() TTLPolicy {
+	mdb.rlock()
+	defer mdb.rwLock.RUnlock()
+	return mdb.policy
+}
+
+// SetPolicy 修改键空间的过期策略 只影响之后的写入和访问
+func (mdb *MemoryDBDao) SetPolicy(policy TTLPolicy) {
+	mdb.lock()
+	defer mdb.rwLock.Unlock()
+	mdb.policy = policy
+}
+
+// Set 设置键值对并设置过期时间 过期时间为0时键永不过期
 func (mdb *MemoryDBDao) Set(key string, value interface{}, expiration int64) {
 	nanoseconds := expiration * int64(time.Second)
-	duration := time.Duration(nanoseconds)
-	mdb.rwLock.Lock()
+	mdb.SetWithTTL(key, value, time.Duration(nanoseconds))
+}
+
+// SetDefault 设置键值对 使用键空间的默认过期时间
+func (mdb *MemoryDBDao) SetDefault(key string, value interface{}) {
+	mdb.SetWithTTL(key, value, mdb.Policy().DefaultTTL)
+}
+
+// SetWithTTL 设置键值对 ttl大于0时设置过期时间 否则键永不过期
+func (mdb *MemoryDBDao) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	mdb.lock()
 	defer mdb.rwLock.Unlock()
+	if _, exists := mdb.dataMap[key]; !exists && mdb.policy.MaxKeys > 0 {
+		for len(mdb.dataMap) >= mdb.policy.MaxKeys {
+			mdb.evictOne()
+		}
+	}
 	//如果过期时间大于0 就设置过期时间 如果过期时间为0说明这个键永不过期
-	if expiration > 0 {
-		mdb.expires[key] = time.Now().Add(duration)
-		mdb.dataMap[key] = value
+	if ttl > 0 {
+		mdb.expires[key] = time.Now().Add(ttl)
+		mdb.storeValue(key, value)
 		log.Printf("已添加键：%s 值：%v 过期时间：%v", key, value, mdb.expires[key])
 	} else {
-		mdb.dataMap[key] = value
+		delete(mdb.expires, key)
+		mdb.storeValue(key, value)
 		log.Printf("已添加键：%s 值：%v", key, value)
 	}
 }
 
 // Get 获取键对应的值
 func (mdb *MemoryDBDao) Get(key string) (interface{}, bool) {
-	mdb.rwLock.RLock()
-	defer mdb.rwLock.RUnlock()
+	// 读取时可能删除过期键或延长过期时间 所以要加写锁
+	mdb.lock()
+	defer mdb.rwLock.Unlock()
 	expire, exists := mdb.expires[key]
 	if exists {
 		if time.Now().After(expire) {
-			mdb.deleteKey(key)
+			mdb.expireKey(key)
 			log.Printf("键：%s在：%v时已经过期：", key, expire)
+			mdb.misses++
 			return nil, false
 		}
-		mdb.expires[key] = time.Now().Add(Expiration)
-		log.Printf("已延长键：%s过期时间至：%v", key, mdb.expires[key])
+		mdb.touch(key)
+		mdb.hits++
 		return mdb.dataMap[key], true
 	}
 	value, exists := mdb.dataMap[key]
+	if exists {
+		mdb.hits++
+	} else {
+		mdb.misses++
+	}
 	return value, exists
 }
 
 // Update 更新键对应的值
 func (mdb *MemoryDBDao) Update(key string, value interface{}) bool {
-	mdb.rwLock.Lock()
+	mdb.lock()
 	defer mdb.rwLock.Unlock()
 	expire, exists := mdb.expires[key]
 	if exists {
 		if time.Now().After(expire) {
-			mdb.deleteKey(key)
+			mdb.expireKey(key)
 			log.Printf("键：%s在：%v时已经过期：", key, expire)
 			return false
 		}
-		mdb.expires[key] = time.Now().Add(Expiration)
-		log.Printf("已延长键：%s过期时间至：%v", key, mdb.expires[key])
-		mdb.dataMap[key] = value
+		mdb.touch(key)
+		mdb.storeValue(key, value)
 		log.Printf("修改键：%s的值为：%v", key, mdb.dataMap[key])
 		return true
 	}
 	if _, exists = mdb.dataMap[key]; exists {
-		mdb.dataMap[key] = value
+		mdb.storeValue(key, value)
 		log.Printf("修改键：%s的值为：%v", key, mdb.dataMap[key])
 		return true
 	}
@@ -89,45 +171,231 @@ func (mdb *MemoryDBDao) Update(key string, value interface{}) bool {
 
 // Delete 删除指定键
 func (mdb *MemoryDBDao) Delete(key string) {
-	mdb.rwLock.Lock()
+	mdb.lock()
 	defer mdb.rwLock.Unlock()
 	mdb.deleteKey(key)
 	log.Printf("删除键: %s", key)
 }
 
+// Flush 清空键空间中的所有键
+func (mdb *MemoryDBDao) Flush() {
+	mdb.lock()
+	defer mdb.rwLock.Unlock()
+	mdb.dataMap = make(map[string]interface{})
+	mdb.expires = make(map[string]time.Time)
+	mdb.sizes = make(map[string]int64)
+	mdb.scanIndex = scanIndex{}
+	mdb.usedBytes = 0
+	mdb.entrySizes = sizeHistogram{}
+	log.Printf("清空键空间：%s", mdb.name)
+}
+
 // Count 获取数据库中键值对的数量
 func (mdb *MemoryDBDao) Count() int {
-	mdb.rwLock.RLock()
+	mdb.rlock()
 	defer mdb.rwLock.RUnlock()
 	return len(mdb.dataMap)
 }
 
+// Exists 判断键是否存在 不会延长过期时间
+func (mdb *MemoryDBDao) Exists(key string) bool {
+	mdb.lock()
+	defer mdb.rwLock.Unlock()
+	return mdb.liveKey(key)
+}
+
+// Peek 读取键对应的值 不计入命中率也不会延长过期时间
+func (mdb *MemoryDBDao) Peek(key string) (interface{}, bool) {
+	mdb.lock()
+	defer mdb.rwLock.Unlock()
+	if !mdb.liveKey(key) {
+		return nil, false
+	}
+	return mdb.dataMap[key], true
+}
+
+// TTL 获取键的剩余存活时间 键不存在时返回false 键永不过期时返回的时间小于0
+func (mdb *MemoryDBDao) TTL(key string) (time.Duration, bool) {
+	mdb.lock()
+	defer mdb.rwLock.Unlock()
+	if !mdb.liveKey(key) {
+		return 0, false
+	}
+	expire, exists := mdb.expires[key]
+	if !exists {
+		return -1, true
+	}
+	return time.Until(expire), true
+}
+
+// Expire 为已存在的键设置过期时间 ttl小于等于0时直接删除该键
+func (mdb *MemoryDBDao) Expire(key string, ttl time.Duration) bool {
+	mdb.lock()
+	defer mdb.rwLock.Unlock()
+	if !mdb.liveKey(key) {
+		return false
+	}
+	if ttl <= 0 {
+		mdb.deleteKey(key)
+		log.Printf("键：%s的过期时间小于等于0 已删除", key)
+		return true
+	}
+	mdb.expires[key] = time.Now().Add(ttl)
+	log.Printf("设置键：%s的过期时间为：%v", key, mdb.expires[key])
+	return true
+}
+
+// Persist 移除键的过期时间 使其永不过期
+func (mdb *MemoryDBDao) Persist(key string) bool {
+	mdb.lock()
+	defer mdb.rwLock.Unlock()
+	if !mdb.liveKey(key) {
+		return false
+	}
+	if _, exists := mdb.expires[key]; !exists {
+		return false
+	}
+	delete(mdb.expires, key)
+	log.Printf("移除键：%s的过期时间", key)
+	return true
+}
+
+// Keys 返回所有匹配通配符模式的未过期键 结果按字典序排列
+func (mdb *MemoryDBDao) Keys(pattern string) []string {
+	mdb.rlock()
+	defer mdb.rwLock.RUnlock()
+	now := time.Now()
+	keys := make([]string, 0)
+	for key := range mdb.dataMap {
+		if expire, exists := mdb.expires[key]; exists && now.After(expire) {
+			continue
+		}
+		if GlobMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// liveKey 判断键是否存在且未过期 已过期的键会被顺带删除 调用方需持有写锁
+func (mdb *MemoryDBDao) liveKey(key string) bool {
+	if _, exists := mdb.dataMap[key]; !exists {
+		return false
+	}
+	if expire, exists := mdb.expires[key]; exists && time.Now().After(expire) {
+		mdb.expireKey(key)
+		log.Printf("键：%s在：%v时已经过期：", key, expire)
+		return false
+	}
+	return true
+}
+
+// touch 按照滑动过期策略延长键的过期时间 调用方需持有写锁
+func (mdb *MemoryDBDao) touch(key string) {
+	if mdb.policy.SlidingTTL <= 0 {
+		return
+	}
+	mdb.expires[key] = time.Now().Add(mdb.policy.SlidingTTL)
+	log.Printf("已延长键：%s过期时间至：%v", key, mdb.expires[key])
+}
+
+// storeValue 写入值并更新内存占用统计 调用方需持有写锁
+func (mdb *MemoryDBDao) storeValue(key string, value interface{}) {
+	if old, exists := mdb.sizes[key]; exists {
+		mdb.usedBytes -= old
+		mdb.entrySizes.remove(old)
+	} else {
+		mdb.scanIndex.add(key)
+	}
+	size := approxEntrySize(key, value)
+	mdb.sizes[key] = size
+	mdb.usedBytes += size
+	mdb.entrySizes.add(size)
+	mdb.dataMap[key] = value
+}
+
 // deleteKey 删除数据和过期时间
 func (mdb *MemoryDBDao) deleteKey(key string) {
+	if size, exists := mdb.sizes[key]; exists {
+		mdb.usedBytes -= size
+		mdb.entrySizes.remove(size)
+		delete(mdb.sizes, key)
+		mdb.scanIndex.remove(key)
+	}
 	delete(mdb.dataMap, key)
 	delete(mdb.expires, key)
 }
 
-// PeriodicDelete 定期删除过期键
-func (mdb *MemoryDBDao) PeriodicDelete() {
-	mdb.rwLock.Lock()
-	keys := make([]string, 0, len(mdb.expires))
-	for key := range mdb.expires {
-		keys = append(keys, key)
-	}
-	// 随机选择一定数量的键进行检查
-	if len(keys) > 0 {
-		sampleSize := 10 // 每次检查 10 个键
-		if len(keys) < sampleSize {
-			sampleSize = len(keys)
+// expireKey 删除已过期的键并计数 调用方需持有写锁
+func (mdb *MemoryDBDao) expireKey(key string) {
+	mdb.deleteKey(key)
+	mdb.expiredKeys++
+}
+
+// evictOne 键数量达到上限时淘汰一个键 随机采样几个键 优先淘汰已过期或最快过期的键 调用方需持有写锁
+func (mdb *MemoryDBDao) evictOne() {
+	victim := ""
+	var victimExpire time.Time
+	sampled := 0
+	for key := range mdb.dataMap {
+		expire, hasExpire := mdb.expires[key]
+		if hasExpire && time.Now().After(expire) {
+			mdb.expireKey(key)
+			return
+		}
+		if victim == "" || (hasExpire && (victimExpire.IsZero() || expire.Before(victimExpire))) {
+			victim = key
+			victimExpire = expire
 		}
-		rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
-		for _, key := range keys[:sampleSize] {
-			if expire, exists := mdb.expires[key]; exists && time.Now().After(expire) {
-				mdb.deleteKey(key)
-				log.Printf("定期删除过期键：%s", key)
-			}
+		sampled++
+		if sampled >= evictionSamples {
+			break
 		}
 	}
-	mdb.rwLock.Unlock()
+	if victim == "" {
+		return
+	}
+	mdb.deleteKey(victim)
+	mdb.evictedKeys++
+	log.Printf("键空间：%s的键数量达到上限 淘汰键：%s", mdb.name, victim)
+}
+
+// lock 获取写锁并记录等待时间
+func (mdb *MemoryDBDao) lock() {
+	start := time.Now()
+	mdb.rwLock.Lock()
+	mdb.lockWait.observe(time.Since(start))
+}
+
+// rlock 获取读锁并记录等待时间
+func (mdb *MemoryDBDao) rlock() {
+	start := time.Now()
+	mdb.rwLock.RLock()
+	mdb.lockWait.observe(time.Since(start))
+}
+
+// Stats 返回键空间的统计信息
+func (mdb *MemoryDBDao) Stats() MemoryDBStats {
+	mdb.rlock()
+	defer mdb.rwLock.RUnlock()
+	stats := MemoryDBStats{
+		Namespace:        mdb.name,
+		Keys:             len(mdb.dataMap),
+		Expires:          len(mdb.expires),
+		UsedBytes:        mdb.usedBytes + int64(len(mdb.expires))*expireEntrySize,
+		Hits:             mdb.hits,
+		Misses:           mdb.misses,
+		ExpiredKeys:      mdb.expiredKeys,
+		EvictedKeys:      mdb.evictedKeys,
+		LockAcquisitions: atomic.LoadInt64(&mdb.lockWait.count),
+		LockWaitTotalNs:  atomic.LoadInt64(&mdb.lockWait.total),
+		LockWait:         mdb.lockWait.buckets(),
+		EntrySizes:       mdb.entrySizes.buckets(),
+		BucketKeys:       bucketKeyHistogram(&mdb.scanIndex),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
 }
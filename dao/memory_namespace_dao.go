@@ -0,0 +1,136 @@
+package dao
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// DefaultNamespace 没有指定命名空间时使用的键空间名称
+const DefaultNamespace = "default"
+
+// MemoryNamespaceDao 管理多个相互隔离的内存键空间 类似 Redis 的多个逻辑库
+// 不同的实体类型或租户使用不同的命名空间 键不会互相冲突 可以单独清空和设置过期策略
+type MemoryNamespaceDao struct {
+	namespaces    map[string]*MemoryDBDao
+	defaultPolicy TTLPolicy
+	rwLock        sync.RWMutex
+}
+
+// NewMemoryNamespaceDao 创建命名空间管理器 并创建默认命名空间
+func NewMemoryNamespaceDao() *MemoryNamespaceDao {
+	m := &MemoryNamespaceDao{
+		namespaces:    make(map[string]*MemoryDBDao),
+		defaultPolicy: DefaultTTLPolicy(),
+	}
+	m.namespaces[DefaultNamespace] = NewMemoryDBDaoWithPolicy(DefaultNamespace, m.defaultPolicy)
+	return m
+}
+
+// CreateNamespace 创建使用指定过期策略的命名空间 命名空间已存在时只更新它的过期策略
+func (m *MemoryNamespaceDao) CreateNamespace(name string, policy TTLPolicy) *MemoryDBDao {
+	m.rwLock.Lock()
+	defer m.rwLock.Unlock()
+	if ns, exists := m.namespaces[name]; exists {
+		ns.SetPolicy(policy)
+		return ns
+	}
+	ns := NewMemoryDBDaoWithPolicy(name, policy)
+	m.namespaces[name] = ns
+	log.Printf("创建命名空间：%s 过期策略：%+v", name, policy)
+	return ns
+}
+
+// Namespace 获取指定名称的命名空间 不存在时使用默认过期策略创建
+func (m *MemoryNamespaceDao) Namespace(name string) *MemoryDBDao {
+	if ns, exists := m.Lookup(name); exists {
+		return ns
+	}
+	m.rwLock.Lock()
+	defer m.rwLock.Unlock()
+	if ns, exists := m.namespaces[name]; exists {
+		return ns
+	}
+	ns := NewMemoryDBDaoWithPolicy(name, m.defaultPolicy)
+	m.namespaces[name] = ns
+	log.Printf("创建命名空间：%s", name)
+	return ns
+}
+
+// Lookup 查找已存在的命名空间
+func (m *MemoryNamespaceDao) Lookup(name string) (*MemoryDBDao, bool) {
+	m.rwLock.RLock()
+	defer m.rwLock.RUnlock()
+	ns, exists := m.namespaces[name]
+	return ns, exists
+}
+
+// Names 返回所有命名空间的名称 按字典序排列
+func (m *MemoryNamespaceDao) Names() []string {
+	m.rwLock.RLock()
+	defer m.rwLock.RUnlock()
+	names := make([]string, 0, len(m.namespaces))
+	for name := range m.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropNamespace 删除命名空间及其中的所有键 默认命名空间不能删除
+func (m *MemoryNamespaceDao) DropNamespace(name string) bool {
+	if name == DefaultNamespace {
+		return false
+	}
+	m.rwLock.Lock()
+	defer m.rwLock.Unlock()
+	if _, exists := m.namespaces[name]; !exists {
+		return false
+	}
+	delete(m.namespaces, name)
+	log.Printf("删除命名空间：%s", name)
+	return true
+}
+
+// FlushAll 清空所有命名空间中的键
+func (m *MemoryNamespaceDao) FlushAll() {
+	for _, ns := range m.all() {
+		ns.Flush()
+	}
+}
+
+// PeriodicDelete 对每个命名空间执行一次定期删除
+func (m *MemoryNamespaceDao) PeriodicDelete() {
+	for _, ns := range m.all() {
+		ns.PeriodicDelete()
+	}
+}
+
+// all 返回所有命名空间的快照 避免在持有管理器锁时操作命名空间
+func (m *MemoryNamespaceDao) all() []*MemoryDBDao {
+	m.rwLock.RLock()
+	defer m.rwLock.RUnlock()
+	namespaces := make([]*MemoryDBDao, 0, len(m.namespaces))
+	for _, ns := range m.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
+}
+
+// Stats 返回所有命名空间的统计信息 命名空间按名称排序
+func (m *MemoryNamespaceDao) Stats() MemoryDBInfo {
+	info := MemoryDBInfo{Namespaces: make([]MemoryDBStats, 0)}
+	for _, name := range m.Names() {
+		ns, exists := m.Lookup(name)
+		if !exists {
+			continue
+		}
+		stats := ns.Stats()
+		info.Namespaces = append(info.Namespaces, stats)
+		info.TotalKeys += stats.Keys
+		info.TotalUsedBytes += stats.UsedBytes
+		info.TotalHits += stats.Hits
+		info.TotalMisses += stats.Misses
+	}
+	return info
+}
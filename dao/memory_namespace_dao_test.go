@@ -0,0 +1,116 @@
+package dao
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestNamespaceIsolation 不同命名空间中的同名键互不影响 可以单独清空和删除
+func TestNamespaceIsolation(t *testing.T) {
+	namespaces := NewMemoryNamespaceDao()
+	students := namespaces.Namespace("students")
+	courses := namespaces.Namespace("courses")
+	students.Set("1", "张三", 0)
+	courses.Set("1", "数学", 0)
+
+	if value, ok := students.Get("1"); !ok || value != "张三" {
+		t.Fatalf("students 中的键 = %v, %v，期望张三", value, ok)
+	}
+	if value, ok := courses.Get("1"); !ok || value != "数学" {
+		t.Fatalf("courses 中的键 = %v, %v，期望数学", value, ok)
+	}
+	if namespaces.Namespace(DefaultNamespace).Exists("1") {
+		t.Fatalf("默认命名空间中出现了其他命名空间的键")
+	}
+
+	courses.Flush()
+	if courses.Exists("1") || !students.Exists("1") {
+		t.Fatalf("清空 courses 后 students 的键被影响了")
+	}
+	if !namespaces.DropNamespace("students") || namespaces.DropNamespace(DefaultNamespace) {
+		t.Fatalf("只有默认命名空间不能删除")
+	}
+	if namespaces.Namespace("students").Exists("1") {
+		t.Fatalf("删除后重新创建的命名空间中还有之前的键")
+	}
+	if info := namespaces.Stats(); info.TotalKeys != 0 {
+		t.Fatalf("所有命名空间的键数 = %d，期望0", info.TotalKeys)
+	}
+}
+
+// TestDefaultTTL Set 的过期时间为0时永不过期 SetDefault 使用命名空间的默认过期时间
+func TestDefaultTTL(t *testing.T) {
+	namespaces := NewMemoryNamespaceDao()
+	short := namespaces.CreateNamespace("short", TTLPolicy{DefaultTTL: 20 * time.Millisecond})
+	short.Set("forever", 1, 0)
+	short.SetDefault("default", 1)
+	short.Set("explicit", 1, 3600)
+	if ttl, ok := short.TTL("forever"); !ok || ttl >= 0 {
+		t.Fatalf("过期时间为0的键的 TTL = %v, %v，期望永不过期", ttl, ok)
+	}
+	if ttl, ok := short.TTL("default"); !ok || ttl <= 0 || ttl > 20*time.Millisecond {
+		t.Fatalf("使用默认过期时间的键的 TTL = %v, %v", ttl, ok)
+	}
+	other := namespaces.Namespace("other")
+	other.SetDefault("default", 1)
+	if ttl, ok := other.TTL("default"); !ok || ttl >= 0 {
+		t.Fatalf("没有默认过期时间的命名空间中的键的 TTL = %v, %v，期望永不过期", ttl, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if short.Exists("default") {
+		t.Fatalf("使用默认过期时间的键没有过期")
+	}
+	if !short.Exists("forever") || !short.Exists("explicit") || !other.Exists("default") {
+		t.Fatalf("没有到期的键被删除了")
+	}
+	if stats := short.Stats(); stats.ExpiredKeys != 1 {
+		t.Fatalf("过期的键数 = %d，期望1", stats.ExpiredKeys)
+	}
+}
+
+// TestEviction 键数量达到上限时优先淘汰已过期的键 其次是采样的键中最快过期的键
+func TestEviction(t *testing.T) {
+	namespaces := NewMemoryNamespaceDao()
+	// 键的数量不超过采样数 每次淘汰都会检查所有的键
+	small := namespaces.CreateNamespace("small", TTLPolicy{MaxKeys: evictionSamples})
+	small.Set("never", 1, 0)
+	small.Set("late", 1, 3600)
+	small.SetWithTTL("soon", 1, time.Minute)
+	small.Set("later", 1, 7200)
+	small.SetWithTTL("expired", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	small.Set("new1", 1, 0)
+	if small.Count() != evictionSamples || small.Exists("expired") {
+		t.Fatalf("第一次淘汰后的键 = %v，期望淘汰已过期的键", small.Keys("*"))
+	}
+	small.Set("new2", 1, 0)
+	if small.Exists("soon") || !small.Exists("late") || !small.Exists("never") {
+		t.Fatalf("第二次淘汰后的键 = %v，期望淘汰最快过期的键", small.Keys("*"))
+	}
+	// 覆盖已有的键不会淘汰
+	small.Set("new2", 2, 0)
+	stats := small.Stats()
+	if stats.Keys != evictionSamples || stats.ExpiredKeys != 1 || stats.EvictedKeys != 1 {
+		t.Fatalf("统计 = keys:%d expired:%d evicted:%d，期望 %d 1 1", stats.Keys, stats.ExpiredKeys, stats.EvictedKeys, evictionSamples)
+	}
+
+	// 键的数量远大于采样数时 键数不会超过上限
+	large := namespaces.CreateNamespace("large", TTLPolicy{MaxKeys: 100})
+	for i := 0; i < 1000; i++ {
+		large.Set(fmt.Sprintf("k%d", i), i, int64(1+i%10))
+	}
+	if stats = large.Stats(); stats.Keys != 100 || stats.EvictedKeys != 900 {
+		t.Fatalf("统计 = keys:%d evicted:%d，期望 100 900", stats.Keys, stats.EvictedKeys)
+	}
+	// 没有上限的命名空间不淘汰
+	unlimited := namespaces.Namespace("unlimited")
+	for i := 0; i < 1000; i++ {
+		unlimited.Set(fmt.Sprintf("k%d", i), i, 0)
+	}
+	if unlimited.Count() != 1000 {
+		t.Fatalf("没有上限的命名空间的键数 = %d，期望1000", unlimited.Count())
+	}
+}
@@ -0,0 +1,87 @@
+package dao
+
+import (
+	"hash/fnv"
+	"log"
+	"time"
+)
+
+// scanBucketCount 游标遍历使用的桶数量 必须是2的幂
+// 桶的数量固定不变 键始终落在同一个桶中 所以整个遍历期间一直存在的键至少会被返回一次
+const scanBucketCount = 1024
+
+// scanIndex 按键的哈希值把键分到固定数量的桶中 用于增量遍历
+type scanIndex [scanBucketCount]map[string]struct{}
+
+func scanBucketOf(key string) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return uint64(h.Sum32()) & (scanBucketCount - 1)
+}
+
+func (idx *scanIndex) add(key string) {
+	bucket := scanBucketOf(key)
+	if idx[bucket] == nil {
+		idx[bucket] = make(map[string]struct{})
+	}
+	idx[bucket][key] = struct{}{}
+}
+
+func (idx *scanIndex) remove(key string) {
+	bucket := scanBucketOf(key)
+	if idx[bucket] != nil {
+		delete(idx[bucket], key)
+	}
+}
+
+// Scan 从游标位置开始增量遍历键空间 返回匹配模式的键和下一次遍历的游标 游标为0表示遍历结束
+// count 只是每次遍历的键数量的参考值 每次调用只在遍历期间短暂持有读锁 遍历过程中可以并发修改键空间
+// 遍历开始到结束一直存在的键至少会被返回一次 遍历期间新增或删除的键可能返回也可能不返回
+func (mdb *MemoryDBDao) Scan(cursor uint64, match string, count int) ([]string, uint64) {
+	if count <= 0 {
+		count = 10
+	}
+	if match == "" {
+		match = "*"
+	}
+	mdb.rlock()
+	defer mdb.rwLock.RUnlock()
+	now := time.Now()
+	keys := make([]string, 0, count)
+	examined := 0
+	// 限制一次调用访问的空桶数量 避免稀疏的键空间一次遍历太多桶
+	maxBuckets := count * 10
+	bucket := cursor & (scanBucketCount - 1)
+	for visited := 0; bucket < scanBucketCount && examined < count && visited < maxBuckets; visited++ {
+		for key := range mdb.scanIndex[bucket] {
+			examined++
+			if expire, exists := mdb.expires[key]; exists && now.After(expire) {
+				continue
+			}
+			if GlobMatch(match, key) {
+				keys = append(keys, key)
+			}
+		}
+		bucket++
+	}
+	if bucket >= scanBucketCount {
+		return keys, 0
+	}
+	return keys, bucket
+}
+
+// PeriodicDelete 定期删除过期键 每次检查所有的桶 没有被读取的过期键最多保留一个周期
+// 每个桶单独加锁 检查两个桶之间释放锁 不会长时间阻塞读写
+func (mdb *MemoryDBDao) PeriodicDelete() {
+	for bucket := 0; bucket < scanBucketCount; bucket++ {
+		mdb.lock()
+		now := time.Now()
+		for key := range mdb.scanIndex[bucket] {
+			if expire, exists := mdb.expires[key]; exists && now.After(expire) {
+				mdb.expireKey(key)
+				log.Printf("定期删除过期键：%s", key)
+			}
+		}
+		mdb.rwLock.Unlock()
+	}
+}
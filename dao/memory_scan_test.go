@@ -0,0 +1,90 @@
+package dao
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestScanCompleteDuringWrites 遍历期间并发增删其他键 遍历开始前就存在的键每个都只返回一次
+func TestScanCompleteDuringWrites(t *testing.T) {
+	mdb := NewMemoryDBDaoWithPolicy("scan", TTLPolicy{})
+	const stable = 5000
+	for i := 0; i < stable; i++ {
+		mdb.Set(fmt.Sprintf("stable:%d", i), i, 0)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("churn:%d", i)
+			mdb.Set(key, i, 0)
+			if i%2 == 0 {
+				mdb.Delete(key)
+			}
+		}
+	}()
+
+	seen := make(map[string]int)
+	cursor, calls := uint64(0), 0
+	for {
+		var keys []string
+		keys, cursor = mdb.Scan(cursor, "stable:*", 10)
+		calls++
+		for _, key := range keys {
+			seen[key]++
+		}
+		if cursor == 0 {
+			break
+		}
+		if calls > scanBucketCount {
+			t.Fatalf("遍历调用了%d次还没有结束", calls)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if calls < 2 {
+		t.Fatalf("遍历只调用了%d次 没有分批", calls)
+	}
+	for i := 0; i < stable; i++ {
+		key := fmt.Sprintf("stable:%d", i)
+		if seen[key] != 1 {
+			t.Fatalf("键：%s返回了%d次，期望1次", key, seen[key])
+		}
+	}
+	if len(seen) != stable {
+		t.Fatalf("返回了%d个键，期望 %d 个 模式没有过滤其他键", len(seen), stable)
+	}
+}
+
+// TestPeriodicDeleteCoversEveryBucket 一次定期删除检查所有的桶 删除所有的过期键
+func TestPeriodicDeleteCoversEveryBucket(t *testing.T) {
+	mdb := NewMemoryDBDaoWithPolicy("expire", TTLPolicy{})
+	const expiring = 5000
+	for i := 0; i < expiring; i++ {
+		mdb.SetWithTTL(fmt.Sprintf("k%d", i), i, time.Millisecond)
+	}
+	mdb.Set("forever", 1, 0)
+	for bucket := range mdb.scanIndex {
+		if len(mdb.scanIndex[bucket]) == 0 {
+			t.Fatalf("桶%d中没有键 无法检查每个桶", bucket)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	mdb.PeriodicDelete()
+	stats := mdb.Stats()
+	if stats.Keys != 1 || stats.ExpiredKeys != expiring || !mdb.Exists("forever") {
+		t.Fatalf("定期删除后 keys=%d expired=%d，期望只剩下永不过期的键", stats.Keys, stats.ExpiredKeys)
+	}
+}
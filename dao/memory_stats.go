@@ -0,0 +1,198 @@
+package dao
+
+import (
+	"encoding/json"
+	"memoryDataBase/model"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// 估算内存占用时使用的固定开销 只用于容量规划 不追求精确
+const (
+	mapEntryOverhead  = 48 // map 中每个条目的桶和哈希开销
+	stringHeaderSize  = int64(unsafe.Sizeof(""))
+	interfaceSize     = int64(unsafe.Sizeof(interface{}(nil)))
+	expireEntrySize   = int64(unsafe.Sizeof(time.Time{})) + mapEntryOverhead
+	studentStructSize = int64(unsafe.Sizeof(model.Student{}))
+)
+
+// HistogramBucket 直方图的一个桶 Le 是桶的上界 Count 是落在该桶中的数量
+type HistogramBucket struct {
+	Le    string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+// MemoryDBStats 单个键空间的统计信息
+type MemoryDBStats struct {
+	Namespace        string            `json:"namespace"`
+	Keys             int               `json:"keys"`
+	Expires          int               `json:"expires"`
+	UsedBytes        int64             `json:"used_bytes"`
+	Hits             int64             `json:"hits"`
+	Misses           int64             `json:"misses"`
+	HitRate          float64           `json:"hit_rate"`
+	ExpiredKeys      int64             `json:"expired_keys"`
+	EvictedKeys      int64             `json:"evicted_keys"`
+	LockAcquisitions int64             `json:"lock_acquisitions"`
+	LockWaitTotalNs  int64             `json:"lock_wait_total_ns"`
+	LockWait         []HistogramBucket `json:"lock_wait_histogram"`
+	EntrySizes       []HistogramBucket `json:"entry_size_histogram"`
+	// BucketKeys 按桶中的键数量统计桶的数量 桶是游标遍历和定期删除使用的桶
+	BucketKeys []HistogramBucket `json:"bucket_keys_histogram"`
+}
+
+// MemoryDBInfo 所有命名空间的统计信息汇总
+type MemoryDBInfo struct {
+	Namespaces     []MemoryDBStats `json:"namespaces"`
+	TotalKeys      int             `json:"total_keys"`
+	TotalUsedBytes int64           `json:"total_used_bytes"`
+	TotalHits      int64           `json:"total_hits"`
+	TotalMisses    int64           `json:"total_misses"`
+}
+
+// lockWaitBounds 等锁耗时直方图的桶上界
+var lockWaitBounds = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// entrySizeBounds 条目大小直方图的桶上界 单位字节
+var entrySizeBounds = []int64{64, 256, 1024, 4 * 1024, 16 * 1024, 64 * 1024}
+
+// bucketKeyBounds 每个桶中键数量直方图的桶上界
+var bucketKeyBounds = []int{0, 1, 2, 4, 8, 16, 32, 64}
+
+// lockWaitHistogram 记录获取锁的等待时间 读锁可以并发获取 所以使用原子操作
+type lockWaitHistogram struct {
+	counts [7]int64
+	total  int64
+	count  int64
+}
+
+func (h *lockWaitHistogram) observe(wait time.Duration) {
+	i := 0
+	for i < len(lockWaitBounds) && wait > lockWaitBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.total, int64(wait))
+	atomic.AddInt64(&h.count, 1)
+}
+
+func (h *lockWaitHistogram) buckets() []HistogramBucket {
+	buckets := make([]HistogramBucket, 0, len(h.counts))
+	for i := range h.counts {
+		le := "+Inf"
+		if i < len(lockWaitBounds) {
+			le = lockWaitBounds[i].String()
+		}
+		buckets = append(buckets, HistogramBucket{Le: le, Count: atomic.LoadInt64(&h.counts[i])})
+	}
+	return buckets
+}
+
+// sizeHistogram 按条目大小统计键的数量 只在持有写锁时修改
+type sizeHistogram [7]int64
+
+func sizeBucket(size int64) int {
+	i := 0
+	for i < len(entrySizeBounds) && size > entrySizeBounds[i] {
+		i++
+	}
+	return i
+}
+
+func (h *sizeHistogram) add(size int64) {
+	h[sizeBucket(size)]++
+}
+
+func (h *sizeHistogram) remove(size int64) {
+	h[sizeBucket(size)]--
+}
+
+func (h *sizeHistogram) buckets() []HistogramBucket {
+	buckets := make([]HistogramBucket, 0, len(h))
+	for i, count := range h {
+		le := "+Inf"
+		if i < len(entrySizeBounds) {
+			le = formatBytes(entrySizeBounds[i])
+		}
+		buckets = append(buckets, HistogramBucket{Le: le, Count: count})
+	}
+	return buckets
+}
+
+// bucketKeyHistogram 统计每个桶中的键数量 键在桶之间分布不均时游标遍历每次返回的键数也不均匀 需要持有读锁
+func bucketKeyHistogram(idx *scanIndex) []HistogramBucket {
+	counts := make([]int64, len(bucketKeyBounds)+1)
+	for _, keys := range idx {
+		i := 0
+		for i < len(bucketKeyBounds) && len(keys) > bucketKeyBounds[i] {
+			i++
+		}
+		counts[i]++
+	}
+	buckets := make([]HistogramBucket, 0, len(counts))
+	for i, count := range counts {
+		le := "+Inf"
+		if i < len(bucketKeyBounds) {
+			le = strconv.Itoa(bucketKeyBounds[i])
+		}
+		buckets = append(buckets, HistogramBucket{Le: le, Count: count})
+	}
+	return buckets
+}
+
+func formatBytes(n int64) string {
+	if n >= 1024 && n%1024 == 0 {
+		return strconv.FormatInt(n/1024, 10) + "KB"
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
+
+// approxEntrySize 估算一个条目占用的内存 包括键 值以及 map 的开销
+func approxEntrySize(key string, value interface{}) int64 {
+	return int64(len(key)) + stringHeaderSize + interfaceSize + mapEntryOverhead + approxValueSize(value)
+}
+
+// approxValueSize 估算值占用的内存 学生按字段计算 成绩 map 的每个条目单独计入
+func approxValueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(cap(v)) + int64(unsafe.Sizeof(v))
+	case *model.Student:
+		if v == nil {
+			return 0
+		}
+		size := studentStructSize + int64(len(v.ID)+len(v.Name)+len(v.Gender)+len(v.Class))
+		for subject := range v.Grades {
+			size += int64(len(subject)) + stringHeaderSize + 8 + mapEntryOverhead
+		}
+		return size
+	case bool, int8, uint8:
+		return 1
+	case int16, uint16:
+		return 2
+	case int32, uint32, float32:
+		return 4
+	case int, int64, uint, uint64, float64, uintptr:
+		return 8
+	default:
+		// 其他类型按 json 编码后的长度估算
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0
+		}
+		return int64(len(data))
+	}
+}
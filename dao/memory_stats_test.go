@@ -0,0 +1,59 @@
+package dao
+
+import (
+	"fmt"
+	"testing"
+)
+
+// bucketKeyCount 返回直方图中上界为 le 的桶的数量
+func bucketKeyCount(t *testing.T, buckets []HistogramBucket, le string) int64 {
+	t.Helper()
+	for _, bucket := range buckets {
+		if bucket.Le == le {
+			return bucket.Count
+		}
+	}
+	t.Fatalf("直方图 %+v 中没有上界为 %s 的桶", buckets, le)
+	return 0
+}
+
+// TestStatsBucketKeys 每个命名空间单独统计桶中的键数量 所有桶都计入直方图
+func TestStatsBucketKeys(t *testing.T) {
+	namespaces := NewMemoryNamespaceDao()
+	empty := namespaces.Namespace("empty").Stats()
+	if got := bucketKeyCount(t, empty.BucketKeys, "0"); got != scanBucketCount {
+		t.Fatalf("空命名空间中没有键的桶 = %d，期望 %d", got, scanBucketCount)
+	}
+
+	one := namespaces.Namespace("one")
+	one.Set("s1", "张三", 0)
+	stats := one.Stats()
+	if bucketKeyCount(t, stats.BucketKeys, "1") != 1 || bucketKeyCount(t, stats.BucketKeys, "0") != scanBucketCount-1 {
+		t.Fatalf("一个键的直方图 = %+v，期望一个桶中有1个键", stats.BucketKeys)
+	}
+
+	many := namespaces.Namespace("many")
+	const keys = scanBucketCount * 4
+	for i := 0; i < keys; i++ {
+		many.Set(fmt.Sprintf("s%d", i), i, 0)
+	}
+	var total int64
+	for _, bucket := range many.Stats().BucketKeys {
+		total += bucket.Count
+	}
+	if total != scanBucketCount {
+		t.Fatalf("直方图中的桶数 = %d，期望 %d", total, scanBucketCount)
+	}
+	if got := bucketKeyCount(t, many.Stats().BucketKeys, "0"); got > scanBucketCount/10 {
+		t.Fatalf("%d个键时有%d个桶是空的 键没有分散到各个桶", keys, got)
+	}
+
+	// 删除键后直方图随之变化 其他命名空间不受影响
+	one.Delete("s1")
+	if got := bucketKeyCount(t, one.Stats().BucketKeys, "0"); got != scanBucketCount {
+		t.Fatalf("删除后没有键的桶 = %d，期望 %d", got, scanBucketCount)
+	}
+	if got := bucketKeyCount(t, namespaces.Namespace("empty").Stats().BucketKeys, "0"); got != scanBucketCount {
+		t.Fatalf("其他命名空间的直方图被改变了")
+	}
+}
@@ -0,0 +1,46 @@
+package dao
+
+import (
+	"memoryDataBase/model"
+	"strings"
+	"time"
+)
+
+// AddAuditEntries 用一条语句写入一次变更的所有审计记录
+func (d *StudentMysqlDao) AddAuditEntries(entries []*model.StudentAudit) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(entries))
+	args := make([]interface{}, 0, len(entries)*9)
+	for i, entry := range entries {
+		placeholders[i] = "(?,?,?,?,?,?,?,?,?)"
+		args = append(args, entry.StudentId, entry.Action, entry.Field, entry.OldValue, entry.NewValue,
+			entry.Version, entry.Actor, entry.RequestId, entry.CreatedAt)
+	}
+	sqlStmt := "insert into student_audit (student_id, action, field, old_value, new_value, version, actor, request_id, created_at) values " +
+		strings.Join(placeholders, ",")
+	return d.DB.Exec(sqlStmt, args...).Error
+}
+
+// GetStudentAudits 按时间倒序返回学生的审计记录
+func (d *StudentMysqlDao) GetStudentAudits(studentId string, offset, limit int) ([]*model.StudentAudit, error) {
+	var entries []*model.StudentAudit
+	err := d.DB.Raw("select * from student_audit where student_id = ? order by id desc limit ? offset ?",
+		studentId, limit, offset).Scan(&entries).Error
+	return entries, err
+}
+
+func (d *StudentMysqlDao) CountStudentAudits(studentId string) (int64, error) {
+	var count int64
+	err := d.DB.Raw("select count(*) from student_audit where student_id = ?", studentId).Scan(&count).Error
+	return count, err
+}
+
+// GetStudentAuditsAfter 按写入顺序倒序返回学生在 after 之后的审计记录
+func (d *StudentMysqlDao) GetStudentAuditsAfter(studentId string, after time.Time) ([]*model.StudentAudit, error) {
+	var entries []*model.StudentAudit
+	err := d.DB.Raw("select * from student_audit where student_id = ? and created_at > ? order by id desc",
+		studentId, after).Scan(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,27 @@
+package dao
+
+import (
+	"memoryDataBase/model"
+)
+
+// StudentCache 学生缓存层 可以由 Redis 或进程内的实现提供
+type StudentCache interface {
+	AddStudent(student *model.Student) error
+	GetStudent(id string) (*model.Student, error)
+	DeleteStudent(id string) error
+	// ReLoadCacheData 用给定的学生替换缓存中的所有学生
+	ReLoadCacheData(students []*model.Student) error
+	GetAllStudents() ([]*model.Student, error)
+	// GetStudents 批量获取学生 不在缓存中的学生不出现在结果中
+	GetStudents(ids []string) (map[string]*model.Student, error)
+	// ScanStudentIDs 分批遍历缓存中的学号 每批调用一次 fn 不会一次性读取所有学生
+	ScanStudentIDs(fn func(ids []string) error) error
+	// Shared 缓存是否由多个实例共享 共享的缓存由发起变更的实例更新 其他实例收到失效通知时不需要淘汰
+	Shared() bool
+}
+
+// 确保两种缓存实现都满足 StudentCache 接口
+var (
+	_ StudentCache = (*StudentCacheDao)(nil)
+	_ StudentCache = (*StudentLocalCacheDao)(nil)
+)
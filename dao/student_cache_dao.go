@@ -7,61 +7,156 @@ import (
 	"fmt"
 	"github.com/redis/go-redis/v9"
 	"log"
+	"math/rand"
 	"memoryDataBase/model"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// 学生缓存的键分代保存 student:{代号}:{学号}
+// student:generation 指向当前正在使用的代 重新加载缓存时先写入新的一代再切换指针 不会出现缓存为空的窗口
 const studentCachePrefix = "student:"
 
+// StudentCacheOptions 学生缓存的配置 Redis 缓存和进程内缓存共用
+type StudentCacheOptions struct {
+	// ScanBatchSize 批量加载缓存时每次 SCAN 的键数量 同时也是每个管道中 HGETALL 的数量
+	ScanBatchSize int
+	// TTLJitter Redis 键的过期时间会随机延长这个比例以内的时间 避免同一批写入的键同时过期
+	// 学生是否过期以 expire_at 字段为准 所以延长的这段时间内读到的也是未命中
+	TTLJitter float64
+	// SlidingTTL 读取到未过期的学生时把它的过期时间延长到多久之后 0表示不延长
+	SlidingTTL time.Duration
+}
+
+// DefaultStudentCacheOptions 返回学生缓存的默认配置
+func DefaultStudentCacheOptions() StudentCacheOptions {
+	return StudentCacheOptions{ScanBatchSize: 500, TTLJitter: 0.1}
+}
+
+// StudentCacheDao 基于 Redis 的学生缓存
 type StudentCacheDao struct {
-	client redis.Client
+	client  *redis.Client
+	options StudentCacheOptions
 }
 
-func NewStudentCacheDao(client *redis.Client) *StudentCacheDao {
+func NewStudentCacheDao(client *redis.Client, options StudentCacheOptions) *StudentCacheDao {
 	return &StudentCacheDao{
-		client: *client,
+		client:  client,
+		options: options,
 	}
 }
 
-func (d StudentCacheDao) AddStudent(student *model.Student) error {
-	ctx := context.Background()
-
-	key := studentCachePrefix + student.ID
-
+// studentFields 把学生转换为缓存哈希的字段 依次为字段名和值
+// 学生设置了过期时间时会带上 expire_at 字段 并返回 Redis 键应该设置的过期毫秒数 否则返回0
+func (o StudentCacheOptions) studentFields(student *model.Student) ([]interface{}, int64, error) {
 	gradeJSON, err := json.Marshal(student.Grades)
 	if err != nil {
 		log.Println("将成绩序列化为json时出错")
-		return err
+		return nil, 0, err
+	}
+	fields := []interface{}{
+		"id", student.ID,
+		"name", student.Name,
+		"gender", student.Gender,
+		"class", student.Class,
+		"grade", gradeJSON,
+		"expiration", student.Expiration,
+		"version", student.Version,
 	}
+	if student.Expiration <= 0 {
+		return append(fields, "expire_at", 0), 0, nil
+	}
+	ttl := time.Duration(student.Expiration) * time.Second
+	expireAt := time.Now().Add(ttl).UnixMilli()
+	return append(fields, "expire_at", expireAt), o.jitteredTTL(ttl).Milliseconds(), nil
+}
+
+// jitteredTTL 给过期时间加上随机抖动
+func (o StudentCacheOptions) jitteredTTL(ttl time.Duration) time.Duration {
+	if o.TTLJitter <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int63n(int64(float64(ttl)*o.TTLJitter)+1))
+}
 
-	fields := make(map[string]interface{})
-	fields["id"] = student.ID
-	fields["name"] = student.Name
-	fields["gender"] = student.Gender
-	fields["class"] = student.Class
-	fields["grade"] = gradeJSON
-	fields["expiration"] = student.Expiration
+// Shared Redis 缓存由所有实例共享
+func (d *StudentCacheDao) Shared() bool {
+	return true
+}
+
+func (d *StudentCacheDao) AddStudent(student *model.Student) error {
+	ctx := context.Background()
+
+	fields, pttl, err := d.options.studentFields(student)
+	if err != nil {
+		return err
+	}
 
-	err = d.client.HSet(ctx, key, fields).Err()
+	// 正在重新加载缓存时会同时写入当前代和新的一代 保证切换后不会丢失这次写入
+	args := append([]interface{}{studentCachePrefix, student.ID, pttl}, fields...)
+	err = addStudentScript.Run(ctx, d.client, generationKeys(), args...).Err()
 	return err
 }
 
-func (d StudentCacheDao) GetStudent(id string) (*model.Student, error) {
+func (d *StudentCacheDao) GetStudent(id string) (*model.Student, error) {
 	ctx := context.Background()
-	key := studentCachePrefix + id
 
 	// 从缓存中获取学生的所有字段信息
-	result, err := d.client.HGetAll(ctx, key).Result()
+	reply, err := getStudentScript.Run(ctx, d.client, generationKeys(), studentCachePrefix, id).StringSlice()
 	if err != nil {
 		return nil, err
 	}
 
 	// 如果结果为空，说明学生信息不存在
-	if len(result) == 0 {
+	if len(reply) == 0 {
+		errMsg := fmt.Sprintf("在缓存中查找不到学号为：%s的学生", id)
+		return nil, errors.New(errMsg)
+	}
+	result := make(map[string]string, len(reply)/2)
+	for i := 0; i+1 < len(reply); i += 2 {
+		result[reply[i]] = reply[i+1]
+	}
+	// 墓碑和升级前写入的学生没有版本号 按未命中处理 从数据库重新加载
+	if result["version"] == "" {
 		errMsg := fmt.Sprintf("在缓存中查找不到学号为：%s的学生", id)
 		return nil, errors.New(errMsg)
 	}
 
+	student, err := parseStudentHash(result)
+	if err != nil {
+		return nil, err
+	}
+	// Redis 键的过期时间带有抖动 逻辑上已经过期的学生按未命中处理
+	expireAt, expired := hashExpiration(result)
+	if expired {
+		errMsg := fmt.Sprintf("在缓存中查找不到学号为：%s的学生", id)
+		return nil, errors.New(errMsg)
+	}
+	if expireAt > 0 && d.options.SlidingTTL > 0 {
+		d.touchStudent(ctx, id)
+	}
+	return student, nil
+}
+
+// hashExpiration 返回学生哈希中的 expire_at 以及学生是否已经逻辑过期
+func hashExpiration(result map[string]string) (int64, bool) {
+	expireAt, _ := strconv.ParseInt(result["expire_at"], 10, 64)
+	return expireAt, expireAt > 0 && time.Now().UnixMilli() >= expireAt
+}
+
+// touchStudent 按照滑动过期策略延长学生的过期时间
+func (d *StudentCacheDao) touchStudent(ctx context.Context, id string) {
+	expireAt := time.Now().Add(d.options.SlidingTTL).UnixMilli()
+	pttl := d.options.jitteredTTL(d.options.SlidingTTL).Milliseconds()
+	err := touchStudentScript.Run(ctx, d.client, generationKeys(), studentCachePrefix, id, expireAt, pttl).Err()
+	if err != nil {
+		log.Printf("延长学生：%s的缓存过期时间时失败：%v", id, err)
+	}
+}
+
+// parseStudentHash 把缓存中学生哈希的各个字段转换为学生对象
+func parseStudentHash(result map[string]string) (*model.Student, error) {
 	// 创建一个新的 Student 对象
 	student := &model.Student{}
 
@@ -70,12 +165,27 @@ func (d StudentCacheDao) GetStudent(id string) (*model.Student, error) {
 	student.Name = result["name"]
 	student.Gender = result["gender"]
 	student.Class = result["class"]
-	student.Expiration, err = strconv.ParseInt(result["expiration"], 10, 64)
+	if expiration := result["expiration"]; expiration != "" {
+		var err error
+		student.Expiration, err = strconv.ParseInt(expiration, 10, 64)
+		if err != nil {
+			log.Printf("解析学生：%s的过期时间时出错：%v", student.ID, err)
+			return nil, err
+		}
+	}
+	if version := result["version"]; version != "" {
+		var err error
+		student.Version, err = strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			log.Printf("解析学生：%s的版本号时出错：%v", student.ID, err)
+			return nil, err
+		}
+	}
 
 	// 反序列化成绩信息
 	gradeJSON := []byte(result["grade"])
 	grades := make(map[string]float64)
-	err = json.Unmarshal(gradeJSON, &grades)
+	err := json.Unmarshal(gradeJSON, &grades)
 	if err != nil {
 		log.Println("将成绩从json反序列化时出错")
 		return nil, err
@@ -85,13 +195,11 @@ func (d StudentCacheDao) GetStudent(id string) (*model.Student, error) {
 	return student, nil
 }
 
-func (d StudentCacheDao) DeleteStudent(id string) error {
+func (d *StudentCacheDao) DeleteStudent(id string) error {
 	ctx := context.Background()
-	// 构建缓存键
-	key := studentCachePrefix + id
 
-	// 使用 Del 命令删除缓存数据
-	err := d.client.Del(ctx, key).Err()
+	// 从当前代中删除缓存数据 正在重新加载时在新的一代中留下墓碑
+	err := deleteStudentScript.Run(ctx, d.client, generationKeys(), studentCachePrefix, id, reloadLockTTL.Milliseconds()).Err()
 	if err != nil {
 		log.Printf("删除学生缓存信息时出错: %v\n", err)
 		return err
@@ -99,41 +207,191 @@ func (d StudentCacheDao) DeleteStudent(id string) error {
 	return nil
 }
 
-func (d StudentCacheDao) ReLoadCacheData(students []*model.Student) error {
+// ReLoadCacheData 用给定的学生替换缓存中的数据 不会清空整个 Redis 库
+// 先把学生写入新的一代 再原子地把代指针切换过去 旧的一代在后台分批删除
+// 多个节点同时重新加载时只有拿到锁的节点会执行
+func (d *StudentCacheDao) ReLoadCacheData(students []*model.Student) error {
 	ctx := context.Background()
-	// 删除所有 Redis 记录
-	err := d.client.FlushDB(ctx).Err()
+
+	next, err := d.client.Incr(ctx, studentGenerationSeqKey).Result()
 	if err != nil {
-		return fmt.Errorf("删除所有缓存记录时出错: %w", err)
+		return fmt.Errorf("生成新的缓存代号时出错：%w", err)
 	}
-	for _, student := range students {
-		err = d.AddStudent(student)
-		if err != nil {
-			return fmt.Errorf("加载学生时出错：%w", err)
-		}
+	// 代号由 INCR 生成 每次加载都不同 直接作为锁的令牌 释放锁时只删除自己的锁
+	acquired, err := d.client.SetNX(ctx, studentGenerationLockKey, next, reloadLockTTL).Result()
+	if err != nil {
+		return fmt.Errorf("获取重新加载缓存的锁时出错：%w", err)
+	}
+	if !acquired {
+		log.Printf("其他节点正在重新加载缓存，跳过这次加载")
+		return nil
 	}
+	// 从这里开始的写入会同时写到新的一代
+	if err = d.client.Set(ctx, studentGenerationNextKey, next, reloadLockTTL).Err(); err != nil {
+		d.releaseReload(ctx, next)
+		return fmt.Errorf("设置新的缓存代号时出错：%w", err)
+	}
+
+	if err = d.fillGeneration(ctx, next, students); err != nil {
+		d.releaseReload(ctx, next)
+		go d.deleteGeneration(next)
+		return fmt.Errorf("加载学生时出错：%w", err)
+	}
+
+	// 原子地切换代指针 加载时间超过锁的过期时间时锁可能已经被其他节点拿到 这时放弃这一代
+	keys := []string{studentGenerationKey, studentGenerationNextKey, studentGenerationLockKey}
+	// 被替换的代号由切换脚本返回 加锁之前读到的代号可能已经被其他节点的加载替换
+	previous, err := switchGenerationScript.Run(ctx, d.client, keys, next).Int64()
+	if err != nil {
+		d.releaseReload(ctx, next)
+		go d.deleteGeneration(next)
+		return fmt.Errorf("切换缓存代号时出错：%w", err)
+	}
+	if previous < 0 {
+		go d.deleteGeneration(next)
+		return fmt.Errorf("重新加载缓存的锁已过期，放弃第%d代", next)
+	}
+	log.Printf("缓存已从第%d代切换到第%d代", previous, next)
+
+	go func() {
+		d.deleteGeneration(previous)
+		d.deleteLegacyKeys()
+	}()
 	return nil
 }
 
-func (d StudentCacheDao) GetAllStudents() ([]*model.Student, error) {
+// GetAllStudents 获取缓存中的所有学生 使用 SCAN 分批遍历键 每批键通过管道一次性执行 HGETALL
+// 不会像 KEYS 一样阻塞 Redis 解析失败或已被删除的键会被跳过
+func (d *StudentCacheDao) GetAllStudents() ([]*model.Student, error) {
 	ctx := context.Background()
-	var students []*model.Student
+	students := make([]*model.Student, 0)
 
-	keys, err := d.client.Keys(ctx, "student:*").Result()
+	generation, err := d.currentGeneration(ctx)
 	if err != nil {
-		log.Printf("获取所有学生的键时失败: %v", err)
+		log.Printf("获取当前缓存代号时失败: %v", err)
 		return nil, err
 	}
 
-	for _, key := range keys {
-		studentId, err := d.client.HGet(ctx, key, "id").Result()
+	var cursor uint64
+	for {
+		keys, next, err := d.client.Scan(ctx, cursor, generationPrefix(generation)+"*", d.options.scanBatchSize()).Result()
+		if err != nil {
+			log.Printf("遍历学生缓存的键时失败: %v", err)
+			return nil, err
+		}
+		batch, err := d.getStudentsByKeys(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		students = append(students, batch...)
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	return students, nil
+}
+
+// GetStudents 通过管道批量获取当前代中的学生
+func (d *StudentCacheDao) GetStudents(ids []string) (map[string]*model.Student, error) {
+	ctx := context.Background()
+	generation, err := d.currentGeneration(ctx)
+	if err != nil {
+		log.Printf("获取当前缓存代号时失败: %v", err)
+		return nil, err
+	}
+	prefix := generationPrefix(generation)
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = prefix + id
+	}
+	students, err := d.getStudentsByKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*model.Student, len(students))
+	for _, student := range students {
+		result[student.ID] = student
+	}
+	return result, nil
+}
+
+// ScanStudentIDs 用 SCAN 遍历当前代中的键 只返回学号 墓碑的键也会被遍历到 需要时用 GetStudents 确认
+func (d *StudentCacheDao) ScanStudentIDs(fn func(ids []string) error) error {
+	ctx := context.Background()
+	generation, err := d.currentGeneration(ctx)
+	if err != nil {
+		log.Printf("获取当前缓存代号时失败: %v", err)
+		return err
+	}
+	prefix := generationPrefix(generation)
+	var cursor uint64
+	for {
+		keys, next, err := d.client.Scan(ctx, cursor, prefix+"*", d.options.scanBatchSize()).Result()
+		if err != nil {
+			log.Printf("遍历学生缓存的键时失败: %v", err)
+			return err
+		}
+		if len(keys) > 0 {
+			ids := make([]string, len(keys))
+			for i, key := range keys {
+				ids[i] = strings.TrimPrefix(key, prefix)
+			}
+			if err = fn(ids); err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// getStudentsByKeys 通过管道批量获取多个学生的哈希
+// SCAN 返回的键数可能超过 COUNT 每个管道最多 ScanBatchSize 条命令 避免一次写入过多命令
+func (d *StudentCacheDao) getStudentsByKeys(ctx context.Context, keys []string) ([]*model.Student, error) {
+	batchSize := int(d.options.scanBatchSize())
+	students := make([]*model.Student, 0, len(keys))
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch, err := d.getStudentsBatch(ctx, keys[start:end])
 		if err != nil {
-			log.Printf("通过键获得学生id时失败：%v", err)
 			return nil, err
 		}
-		student, err := d.GetStudent(studentId)
+		students = append(students, batch...)
+	}
+	return students, nil
+}
+
+// getStudentsBatch 通过一个管道获取一批学生的哈希
+func (d *StudentCacheDao) getStudentsBatch(ctx context.Context, keys []string) ([]*model.Student, error) {
+	pipe := d.client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, pipe.HGetAll(ctx, key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("通过管道批量获取学生缓存时失败：%v", err)
+		return nil, err
+	}
+	students := make([]*model.Student, 0, len(keys))
+	for i, cmd := range cmds {
+		result := cmd.Val()
+		// 遍历到键之后键可能已经被删除 墓碑和升级前写入的学生没有版本号 都按未命中处理
+		if result["version"] == "" {
+			continue
+		}
+		if _, expired := hashExpiration(result); expired {
+			continue
+		}
+		student, err := parseStudentHash(result)
 		if err != nil {
-			log.Printf("通过学生id：%s获得学生时失败：%v", studentId, err)
+			log.Printf("解析缓存键：%s中的学生时失败：%v", keys[i], err)
+			continue
 		}
 		students = append(students, student)
 	}
@@ -0,0 +1,231 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"memoryDataBase/model"
+)
+
+// newTestCacheDao 使用进程内的 miniredis 代替 Redis 测试结束时关闭
+func newTestCacheDao(tb testing.TB) (*StudentCacheDao, *miniredis.Miniredis) {
+	tb.Helper()
+	server := miniredis.RunT(tb)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	tb.Cleanup(func() { client.Close() })
+	return NewStudentCacheDao(client, DefaultStudentCacheOptions()), server
+}
+
+// seedStudents 直接在 miniredis 中写入第0代的学生哈希
+func seedStudents(tb testing.TB, server *miniredis.Miniredis, count int) {
+	tb.Helper()
+	grades, _ := json.Marshal(map[string]float64{"math": 90, "art": 80})
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("s%06d", i)
+		server.HSet(generationPrefix(0)+id,
+			"id", id, "name", "name"+id, "gender", "m", "class", "c1", "grade", string(grades),
+			"expiration", "0", "version", "1", "expire_at", "0")
+	}
+}
+
+// BenchmarkGetAllStudents 用 SCAN 和管道 HGETALL 读取10万个学生
+func BenchmarkGetAllStudents(b *testing.B) {
+	const count = 100000
+	cacheDao, server := newTestCacheDao(b)
+	seedStudents(b, server, count)
+	ctx := context.Background()
+	if keys, _ := cacheDao.client.DBSize(ctx).Result(); keys != count {
+		b.Fatalf("写入的键数 = %d，期望 %d", keys, count)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		students, err := cacheDao.GetAllStudents()
+		if err != nil {
+			b.Fatalf("读取所有学生失败：%v", err)
+		}
+		if len(students) != count {
+			b.Fatalf("读取到的学生数 = %d，期望 %d", len(students), count)
+		}
+	}
+}
+
+func testStudent(id, name string) *model.Student {
+	return &model.Student{ID: id, Name: name, Gender: "m", Class: "c1", Grades: map[string]float64{"math": 90}, Version: 1}
+}
+
+// TestDeleteDuringReload 加载期间删除的学生不会被加载时读到的旧数据写回
+func TestDeleteDuringReload(t *testing.T) {
+	cacheDao, server := newTestCacheDao(t)
+	ctx := context.Background()
+	if err := cacheDao.AddStudent(testStudent("s1", "张三")); err != nil {
+		t.Fatalf("写入学生失败：%v", err)
+	}
+	// 模拟其他节点正在加载第1代
+	server.Set(studentGenerationLockKey, "1")
+	server.Set(studentGenerationNextKey, "1")
+
+	if err := cacheDao.DeleteStudent("s1"); err != nil {
+		t.Fatalf("删除学生失败：%v", err)
+	}
+	if err := cacheDao.fillGeneration(ctx, 1, []*model.Student{testStudent("s1", "张三")}); err != nil {
+		t.Fatalf("加载学生失败：%v", err)
+	}
+	keys := []string{studentGenerationKey, studentGenerationNextKey, studentGenerationLockKey}
+	if previous, err := switchGenerationScript.Run(ctx, cacheDao.client, keys, 1).Int(); err != nil || previous != 0 {
+		t.Fatalf("切换代指针 = %d, %v，期望从第0代切换", previous, err)
+	}
+
+	if _, err := cacheDao.GetStudent("s1"); err == nil {
+		t.Fatalf("已删除的学生在切换后仍然能读到")
+	}
+	students, err := cacheDao.GetAllStudents()
+	if err != nil || len(students) != 0 {
+		t.Fatalf("GetAllStudents = %v, %v，期望为空", students, err)
+	}
+
+	// 墓碑上可以重新写入学生
+	if err = cacheDao.AddStudent(testStudent("s1", "李四")); err != nil {
+		t.Fatalf("重新写入学生失败：%v", err)
+	}
+	student, err := cacheDao.GetStudent("s1")
+	if err != nil || student.Name != "李四" {
+		t.Fatalf("GetStudent = %v, %v，期望李四", student, err)
+	}
+	if server.HGet(generationPrefix(1)+"s1", "deleted") != "" {
+		t.Fatalf("重新写入后墓碑字段没有被清除")
+	}
+}
+
+// TestReloadLockToken 释放锁时不会删除其他节点持有的锁
+func TestReloadLockToken(t *testing.T) {
+	cacheDao, server := newTestCacheDao(t)
+	ctx := context.Background()
+	server.Set(studentGenerationLockKey, "7")
+	server.Set(studentGenerationNextKey, "7")
+
+	cacheDao.releaseReload(ctx, 3)
+	if lock, _ := server.Get(studentGenerationLockKey); lock != "7" {
+		t.Fatalf("其他节点的锁被删除了：%q", lock)
+	}
+	keys := []string{studentGenerationKey, studentGenerationNextKey, studentGenerationLockKey}
+	if previous, _ := switchGenerationScript.Run(ctx, cacheDao.client, keys, 3).Int(); previous != -1 {
+		t.Fatalf("没有持有锁时切换了代指针")
+	}
+
+	cacheDao.releaseReload(ctx, 7)
+	if server.Exists(studentGenerationLockKey) || server.Exists(studentGenerationNextKey) {
+		t.Fatalf("释放自己的锁后锁仍然存在")
+	}
+}
+
+// TestReLoadCacheData 重新加载后只保留新加载的学生
+func TestReLoadCacheData(t *testing.T) {
+	cacheDao, _ := newTestCacheDao(t)
+	if err := cacheDao.AddStudent(testStudent("s1", "张三")); err != nil {
+		t.Fatalf("写入学生失败：%v", err)
+	}
+	if err := cacheDao.ReLoadCacheData([]*model.Student{testStudent("s2", "李四")}); err != nil {
+		t.Fatalf("重新加载缓存失败：%v", err)
+	}
+	students, err := cacheDao.GetAllStudents()
+	if err != nil || len(students) != 1 || students[0].ID != "s2" {
+		t.Fatalf("GetAllStudents = %v, %v，期望只有 s2", students, err)
+	}
+}
+
+// TestReloadDeletesReplacedGeneration 加锁之前其他节点切换了代指针时 删除的是切换脚本返回的那一代
+func TestReloadDeletesReplacedGeneration(t *testing.T) {
+	cacheDao, server := newTestCacheDao(t)
+	// 模拟其他节点已经切换到第5代
+	server.Set(studentGenerationKey, "5")
+	server.Set(studentGenerationSeqKey, "5")
+	server.HSet(generationPrefix(5)+"s1", "id", "s1")
+	server.HSet(generationPrefix(0)+"s0", "id", "s0")
+
+	if err := cacheDao.ReLoadCacheData([]*model.Student{testStudent("s2", "李四")}); err != nil {
+		t.Fatalf("重新加载缓存失败：%v", err)
+	}
+	if generation, _ := server.Get(studentGenerationKey); generation != "6" {
+		t.Fatalf("代指针 = %q，期望 6", generation)
+	}
+	waitForKeys(t, "删除第5代", func() bool { return !server.Exists(generationPrefix(5) + "s1") })
+	if !server.Exists(generationPrefix(0) + "s0") {
+		t.Fatalf("删除了没有被这次加载替换的第0代")
+	}
+}
+
+// TestReloadDeletesLegacyKeys 第一次切换代指针后删除旧格式的 student:<学号> 键 只执行一次
+func TestReloadDeletesLegacyKeys(t *testing.T) {
+	cacheDao, server := newTestCacheDao(t)
+	server.HSet(studentCachePrefix+"s1", "id", "s1", "name", "张三")
+	server.HSet(studentCachePrefix+"s2", "id", "s2", "name", "李四")
+	server.Set(studentCachePrefix+"other", "不是学生")
+
+	if err := cacheDao.ReLoadCacheData([]*model.Student{testStudent("s3", "王五")}); err != nil {
+		t.Fatalf("重新加载缓存失败：%v", err)
+	}
+	waitForKeys(t, "写入清理标记", func() bool { return server.Exists(studentLegacyCleanedKey) })
+	if server.Exists(studentCachePrefix+"s1") || server.Exists(studentCachePrefix+"s2") {
+		t.Fatalf("旧格式的学生键没有被删除")
+	}
+	if !server.Exists(studentCachePrefix+"other") || !server.Exists(studentGenerationKey) {
+		t.Fatalf("删除了不是学生哈希的键")
+	}
+	if student, err := cacheDao.GetStudent("s3"); err != nil || student.Name != "王五" {
+		t.Fatalf("GetStudent = %v, %v，期望王五", student, err)
+	}
+
+	// 标记存在时不再遍历 之后出现的同名键不会被删除
+	server.HSet(studentCachePrefix+"s4", "id", "s4")
+	cacheDao.deleteLegacyKeys()
+	if !server.Exists(studentCachePrefix + "s4") {
+		t.Fatalf("清理完成后再次删除了旧格式的键")
+	}
+}
+
+// waitForKeys 等待后台删除完成
+func waitForKeys(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestGetStudentsAndScanIDs 批量读取跳过墓碑和不存在的学生 分批遍历到所有学号
+func TestGetStudentsAndScanIDs(t *testing.T) {
+	cacheDao, server := newTestCacheDao(t)
+	cacheDao.options.ScanBatchSize = 3
+	seedStudents(t, server, 10)
+	server.HSet(generationPrefix(0)+"tombstone", "deleted", "1")
+
+	students, err := cacheDao.GetStudents([]string{"s000001", "s000009", "tombstone", "missing"})
+	if err != nil {
+		t.Fatalf("批量读取学生失败：%v", err)
+	}
+	if len(students) != 2 || students["s000001"] == nil || students["s000009"] == nil {
+		t.Fatalf("批量读取到的学生 = %v，期望s000001和s000009", students)
+	}
+
+	seen := make(map[string]bool)
+	err = cacheDao.ScanStudentIDs(func(ids []string) error {
+		for _, id := range ids {
+			seen[id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("遍历学号失败：%v", err)
+	}
+	if len(seen) != 11 || !seen["s000000"] || !seen["tombstone"] {
+		t.Fatalf("遍历到的学号 = %v，期望10个学生和墓碑", seen)
+	}
+}
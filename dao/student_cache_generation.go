@@ -0,0 +1,263 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"memoryDataBase/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 代指针相关的键 学号不会出现在这些位置 所以不会和学生的键冲突
+const (
+	studentGenerationKey     = studentCachePrefix + "generation"
+	studentGenerationNextKey = studentCachePrefix + "generation:next"
+	studentGenerationSeqKey  = studentCachePrefix + "generation:seq"
+	studentGenerationLockKey = studentCachePrefix + "generation:lock"
+	// studentLegacyCleanedKey 存在时说明切换到代指针之前写入的 student:<学号> 键已经删除
+	studentLegacyCleanedKey = studentCachePrefix + "generation:legacy-cleaned"
+)
+
+// reloadLockTTL 重新加载缓存的锁的过期时间 防止节点崩溃后锁一直不释放
+var reloadLockTTL = 5 * time.Minute
+
+// addStudentScript 写入当前代 如果正在重新加载 同时写入新的一代 过期毫秒数为0时键永不过期
+// KEYS: 当前代指针 新一代指针 ARGV: 键前缀 学号 过期毫秒数 字段名和值...
+var addStudentScript = redis.NewScript(`
+local gens = {redis.call('GET', KEYS[1]) or '0'}
+local nxt = redis.call('GET', KEYS[2])
+if nxt and nxt ~= gens[1] then
+	table.insert(gens, nxt)
+end
+local pttl = tonumber(ARGV[3])
+for _, gen in ipairs(gens) do
+	local key = ARGV[1] .. gen .. ':' .. ARGV[2]
+	redis.call('HDEL', key, 'deleted')
+	redis.call('HSET', key, unpack(ARGV, 4))
+	if pttl > 0 then
+		redis.call('PEXPIRE', key, pttl)
+	else
+		redis.call('PERSIST', key)
+	end
+end
+return #gens
+`)
+
+// getStudentScript 读取当前代中的学生 KEYS: 当前代指针 新一代指针 ARGV: 键前缀 学号
+var getStudentScript = redis.NewScript(`
+local gen = redis.call('GET', KEYS[1]) or '0'
+return redis.call('HGETALL', ARGV[1] .. gen .. ':' .. ARGV[2])
+`)
+
+// touchStudentScript 延长当前代中学生的过期时间 KEYS: 当前代指针 ARGV: 键前缀 学号 新的 expire_at 过期毫秒数
+var touchStudentScript = redis.NewScript(`
+local key = ARGV[1] .. (redis.call('GET', KEYS[1]) or '0') .. ':' .. ARGV[2]
+if redis.call('EXISTS', key) == 0 then
+	return 0
+end
+redis.call('HSET', key, 'expire_at', ARGV[3])
+redis.call('PEXPIRE', key, ARGV[4])
+return 1
+`)
+
+// deleteStudentScript 从当前代中删除学生 正在重新加载时在新的一代中写入墓碑
+// 墓碑是只有 deleted 字段的哈希 防止加载时用删除前读到的数据把学生写回去 墓碑没有版本号 读取时按未命中处理
+// KEYS: 当前代指针 新一代指针 ARGV: 键前缀 学号 墓碑的过期毫秒数
+var deleteStudentScript = redis.NewScript(`
+local deleted = redis.call('DEL', ARGV[1] .. (redis.call('GET', KEYS[1]) or '0') .. ':' .. ARGV[2])
+local nxt = redis.call('GET', KEYS[2])
+if nxt then
+	local key = ARGV[1] .. nxt .. ':' .. ARGV[2]
+	deleted = deleted + redis.call('DEL', key)
+	redis.call('HSET', key, 'deleted', '1')
+	redis.call('PEXPIRE', key, ARGV[3])
+end
+return deleted
+`)
+
+// fillStudentScript 重新加载时向新的一代写入学生 如果这个学生在加载期间已经被写入或删除过 说明那次操作更新 不再覆盖
+// 加载期间的删除会留下墓碑 所以键存在就跳过
+// KEYS: 学生的键 ARGV: 过期毫秒数 字段名和值...
+var fillStudentScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+if tonumber(ARGV[1]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// switchGenerationScript 持有锁时把代指针切换到新的一代 锁的值是这次加载的代号
+// 返回切换前的代号 和切换在同一个脚本中读取 所以就是被替换掉的那一代
+// KEYS: 当前代指针 新一代指针 锁 ARGV: 新的代号 返回-1表示锁已经不属于这次加载
+var switchGenerationScript = redis.NewScript(`
+if redis.call('GET', KEYS[3]) ~= ARGV[1] then
+	return -1
+end
+local old = tonumber(redis.call('GET', KEYS[1]) or '0')
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('DEL', KEYS[2], KEYS[3])
+return old
+`)
+
+// releaseReloadScript 放弃重新加载 只删除属于这次加载的锁和新一代指针 锁过期后可能已经被其他节点拿到
+// KEYS: 新一代指针 锁 ARGV: 新的代号
+var releaseReloadScript = redis.NewScript(`
+if redis.call('GET', KEYS[2]) ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+return 1
+`)
+
+func generationKeys() []string {
+	return []string{studentGenerationKey, studentGenerationNextKey}
+}
+
+func generationPrefix(generation int64) string {
+	return studentCachePrefix + strconv.FormatInt(generation, 10) + ":"
+}
+
+func (o StudentCacheOptions) scanBatchSize() int64 {
+	if o.ScanBatchSize <= 0 {
+		return 500
+	}
+	return int64(o.ScanBatchSize)
+}
+
+// releaseReload 放弃这次重新加载
+func (d *StudentCacheDao) releaseReload(ctx context.Context, generation int64) {
+	keys := []string{studentGenerationNextKey, studentGenerationLockKey}
+	if err := releaseReloadScript.Run(ctx, d.client, keys, generation).Err(); err != nil {
+		log.Printf("释放重新加载缓存的锁时失败：%v", err)
+	}
+}
+
+// currentGeneration 获取当前代号 没有代指针时是第0代
+func (d *StudentCacheDao) currentGeneration(ctx context.Context) (int64, error) {
+	generation, err := d.client.Get(ctx, studentGenerationKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return generation, err
+}
+
+// fillGeneration 通过管道分批把学生写入指定的一代
+func (d *StudentCacheDao) fillGeneration(ctx context.Context, generation int64, students []*model.Student) error {
+	prefix := generationPrefix(generation)
+	batchSize := int(d.options.scanBatchSize())
+	// 管道中只能使用 EVALSHA 所以先加载脚本
+	if err := fillStudentScript.Load(ctx, d.client).Err(); err != nil {
+		return err
+	}
+	for start := 0; start < len(students); start += batchSize {
+		end := start + batchSize
+		if end > len(students) {
+			end = len(students)
+		}
+		pipe := d.client.Pipeline()
+		for _, student := range students[start:end] {
+			if student == nil {
+				continue
+			}
+			fields, pttl, err := d.options.studentFields(student)
+			if err != nil {
+				return err
+			}
+			args := append([]interface{}{pttl}, fields...)
+			fillStudentScript.EvalSha(ctx, pipe, []string{prefix + student.ID}, args...)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteGeneration 在后台分批删除某一代的所有键 使用 UNLINK 避免阻塞 Redis
+func (d *StudentCacheDao) deleteGeneration(generation int64) {
+	ctx := context.Background()
+	var cursor uint64
+	deleted := 0
+	for {
+		keys, next, err := d.client.Scan(ctx, cursor, generationPrefix(generation)+"*", d.options.scanBatchSize()).Result()
+		if err != nil {
+			log.Printf("删除第%d代缓存时遍历键失败：%v", generation, err)
+			return
+		}
+		if len(keys) > 0 {
+			if err = d.client.Unlink(ctx, keys...).Err(); err != nil {
+				log.Printf("删除第%d代缓存时失败：%v", generation, err)
+				return
+			}
+			deleted += len(keys)
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	log.Printf("已删除第%d代缓存的%d个键", generation, deleted)
+}
+
+// deleteLegacyKeys 删除切换到代指针之前写入的 student:<学号> 哈希 只需要执行一次 完成后写入标记
+// 代中的键是 student:<代号>:<学号> 代指针相关的键都不是哈希 所以只删除前缀后没有冒号的哈希
+func (d *StudentCacheDao) deleteLegacyKeys() {
+	ctx := context.Background()
+	if cleaned, err := d.client.Exists(ctx, studentLegacyCleanedKey).Result(); err != nil || cleaned == 1 {
+		return
+	}
+	var cursor uint64
+	deleted := 0
+	for {
+		keys, next, err := d.client.Scan(ctx, cursor, studentCachePrefix+"*", d.options.scanBatchSize()).Result()
+		if err != nil {
+			log.Printf("删除旧格式的缓存键时遍历失败：%v", err)
+			return
+		}
+		candidates := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if !strings.Contains(strings.TrimPrefix(key, studentCachePrefix), ":") {
+				candidates = append(candidates, key)
+			}
+		}
+		if len(candidates) > 0 {
+			pipe := d.client.Pipeline()
+			types := make([]*redis.StatusCmd, len(candidates))
+			for i, key := range candidates {
+				types[i] = pipe.Type(ctx, key)
+			}
+			if _, err = pipe.Exec(ctx); err != nil {
+				log.Printf("删除旧格式的缓存键时读取类型失败：%v", err)
+				return
+			}
+			legacy := make([]string, 0, len(candidates))
+			for i, key := range candidates {
+				if types[i].Val() == "hash" {
+					legacy = append(legacy, key)
+				}
+			}
+			if len(legacy) > 0 {
+				if err = d.client.Unlink(ctx, legacy...).Err(); err != nil {
+					log.Printf("删除旧格式的缓存键时失败：%v", err)
+					return
+				}
+				deleted += len(legacy)
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	if err := d.client.Set(ctx, studentLegacyCleanedKey, 1, 0).Err(); err != nil {
+		log.Printf("写入旧格式缓存键的清理标记时失败：%v", err)
+		return
+	}
+	log.Printf("已删除%d个旧格式的缓存键", deleted)
+}
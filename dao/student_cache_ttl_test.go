@@ -0,0 +1,114 @@
+package dao
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// setCacheTTL 修改缓存过期时间的配置
+func setCacheTTL(cacheDao *StudentCacheDao, jitter float64, sliding time.Duration) {
+	cacheDao.options.TTLJitter, cacheDao.options.SlidingTTL = jitter, sliding
+}
+
+// TestCacheTTLJitter Redis 键的过期时间在学生的过期时间和加上抖动比例之间 同一批写入的键不会同时过期
+func TestCacheTTLJitter(t *testing.T) {
+	cacheDao, server := newTestCacheDao(t)
+	setCacheTTL(cacheDao, 0.1, 0)
+	const expiration = 100
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 50; i++ {
+		student := testStudent(fmt.Sprintf("s%d", i), "张三")
+		student.Expiration = expiration
+		if err := cacheDao.AddStudent(student); err != nil {
+			t.Fatalf("写入学生失败：%v", err)
+		}
+		ttl := server.TTL(generationPrefix(0) + student.ID)
+		if ttl < expiration*time.Second || ttl > expiration*time.Second*11/10 {
+			t.Fatalf("学生：%s的 TTL = %v，期望在 %ds 到 %ds 之间", student.ID, ttl, expiration, expiration*11/10)
+		}
+		distinct[ttl] = true
+	}
+	if len(distinct) < 2 {
+		t.Fatalf("50个学生的 TTL 都相同：%v", distinct)
+	}
+
+	// 没有过期时间的学生不设置 TTL 之前设置的 TTL 被清除
+	student := testStudent("s0", "张三")
+	if err := cacheDao.AddStudent(student); err != nil {
+		t.Fatalf("写入学生失败：%v", err)
+	}
+	if ttl := server.TTL(generationPrefix(0) + "s0"); ttl != 0 {
+		t.Fatalf("没有过期时间的学生的 TTL = %v，期望永不过期", ttl)
+	}
+
+	// 没有抖动时过期时间就是学生的过期时间
+	cacheDao.options.TTLJitter = 0
+	for i := 0; i < 10; i++ {
+		if ttl := cacheDao.options.jitteredTTL(time.Minute); ttl != time.Minute {
+			t.Fatalf("没有抖动时 jitteredTTL = %v，期望 1m", ttl)
+		}
+	}
+}
+
+// TestCacheSlidingTTL 开启滑动过期时读取会延长过期时间 逻辑上已经过期的学生按未命中处理
+func TestCacheSlidingTTL(t *testing.T) {
+	cacheDao, server := newTestCacheDao(t)
+	setCacheTTL(cacheDao, 0, time.Hour)
+	student := testStudent("s1", "张三")
+	student.Expiration = 10
+	if err := cacheDao.AddStudent(student); err != nil {
+		t.Fatalf("写入学生失败：%v", err)
+	}
+	key := generationPrefix(0) + "s1"
+	if ttl := server.TTL(key); ttl != 10*time.Second {
+		t.Fatalf("写入后的 TTL = %v，期望 10s", ttl)
+	}
+	server.FastForward(5 * time.Second)
+	if _, err := cacheDao.GetStudent("s1"); err != nil {
+		t.Fatalf("读取学生失败：%v", err)
+	}
+	if ttl := server.TTL(key); ttl != time.Hour {
+		t.Fatalf("读取后的 TTL = %v，期望延长到 1h", ttl)
+	}
+	expireAt, _ := strconv.ParseInt(server.HGet(key, "expire_at"), 10, 64)
+	if remaining := time.Until(time.UnixMilli(expireAt)); remaining < 59*time.Minute {
+		t.Fatalf("读取后的 expire_at 还剩 %v，期望延长到约 1h 之后", remaining)
+	}
+
+	// 没有过期时间的学生读取时不设置 TTL
+	if err := cacheDao.AddStudent(testStudent("s2", "李四")); err != nil {
+		t.Fatalf("写入学生失败：%v", err)
+	}
+	if _, err := cacheDao.GetStudent("s2"); err != nil {
+		t.Fatalf("读取学生失败：%v", err)
+	}
+	if ttl := server.TTL(generationPrefix(0) + "s2"); ttl != 0 {
+		t.Fatalf("没有过期时间的学生读取后的 TTL = %v，期望永不过期", ttl)
+	}
+
+	// 键因为抖动还没有过期 但是 expire_at 已经过去 按未命中处理 也不再延长
+	server.HSet(key, "expire_at", strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10))
+	server.SetTTL(key, time.Minute)
+	if _, err := cacheDao.GetStudent("s1"); err == nil {
+		t.Fatalf("逻辑上已经过期的学生仍然能读到")
+	}
+	if ttl := server.TTL(key); ttl != time.Minute {
+		t.Fatalf("读取逻辑上已经过期的学生后 TTL = %v，期望不变", ttl)
+	}
+
+	// 关闭滑动过期后读取不改变 TTL
+	cacheDao.options.SlidingTTL = 0
+	student.Expiration = 10
+	if err := cacheDao.AddStudent(student); err != nil {
+		t.Fatalf("写入学生失败：%v", err)
+	}
+	server.FastForward(5 * time.Second)
+	if _, err := cacheDao.GetStudent("s1"); err != nil {
+		t.Fatalf("读取学生失败：%v", err)
+	}
+	if ttl := server.TTL(key); ttl != 5*time.Second {
+		t.Fatalf("没有滑动过期时读取后的 TTL = %v，期望 5s", ttl)
+	}
+}
@@ -0,0 +1,54 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"memoryDataBase/model"
+	"time"
+)
+
+// GetDeletedStudent 返回被软删除 还没有彻底删除的学生
+func (d *StudentMysqlDao) GetDeletedStudent(id string) (*model.StudentDB, error) {
+	var studentDB model.StudentDB
+	result := d.DB.Raw("select * from student where id = ? and deleted_at is not null", id).Scan(&studentDB)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New(fmt.Sprintf("数据库不存在被删除的学生：%s", id))
+	}
+	return &studentDB, nil
+}
+
+// RestoreStudent 恢复在 deletedAfter 之后删除的学生 版本号加一 之前的 ETag 不再有效
+func (d *StudentMysqlDao) RestoreStudent(id string, deletedAfter time.Time) error {
+	result := d.DB.Exec("update student set deleted_at = null, version = version + 1 where id = ? and deleted_at is not null and deleted_at >= ?",
+		id, deletedAfter)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return studentModified(id)
+	}
+	return nil
+}
+
+// GetStudentsDeletedBefore 返回在 before 之前删除的最多 limit 个学生的学号
+func (d *StudentMysqlDao) GetStudentsDeletedBefore(before time.Time, limit int) ([]string, error) {
+	var ids []string
+	err := d.DB.Raw("select id from student where deleted_at is not null and deleted_at < ? order by deleted_at limit ?",
+		before, limit).Scan(&ids).Error
+	return ids, err
+}
+
+// PurgeStudent 彻底删除被软删除的学生和成绩 访问次数 学生没有被删除时什么也不做
+func (d *StudentMysqlDao) PurgeStudent(id string) error {
+	deleted := "select id from student where id = ? and deleted_at is not null"
+	if err := d.DB.Exec("delete from grade where student_id in ("+deleted+")", id).Error; err != nil {
+		return err
+	}
+	if err := d.DB.Exec("delete from student_count where student_id in ("+deleted+")", id).Error; err != nil {
+		return err
+	}
+	return d.DB.Exec("delete from student where id = ? and deleted_at is not null", id).Error
+}
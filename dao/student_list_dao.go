@@ -0,0 +1,96 @@
+package dao
+
+import (
+	"memoryDataBase/model"
+	"strings"
+)
+
+// escapeLike 转义 like 中的通配符 配合 escape '!' 使用
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// ListStudents 按条件和排序返回一页没有删除的学生 最多 query.Limit 个
+// 使用上一页最后一个学生的排序值和学号定位下一页 翻页的代价和页码无关
+func (d *StudentMysqlDao) ListStudents(query *model.StudentQuery) ([]model.StudentListRow, error) {
+	var joins []string
+	var joinArgs []interface{}
+	var conditions = []string{"s.deleted_at is null"}
+	var args []interface{}
+
+	if query.Class != "" {
+		conditions = append(conditions, "s.class = ?")
+		args = append(args, query.Class)
+	}
+	if query.Gender != "" {
+		conditions = append(conditions, "s.gender = ?")
+		args = append(args, query.Gender)
+	}
+	if query.NamePrefix != "" {
+		conditions = append(conditions, "s.name like ? escape '!'")
+		args = append(args, escapeLike(query.NamePrefix)+"%")
+	}
+	for _, score := range query.Scores {
+		condition := "exists (select 1 from grade g where g.student_id = s.id and g.subject = ?"
+		scoreArgs := []interface{}{score.Subject}
+		if score.Min != nil {
+			condition += " and g.score >= ?"
+			scoreArgs = append(scoreArgs, *score.Min)
+		}
+		if score.Max != nil {
+			condition += " and g.score <= ?"
+			scoreArgs = append(scoreArgs, *score.Max)
+		}
+		conditions = append(conditions, condition+")")
+		args = append(args, scoreArgs...)
+	}
+
+	// 排序字段 按分数排序时只列出有这门成绩的学生 平均分在写入成绩时更新 没有成绩的学生平均分为0
+	var sortColumn, sortScore string
+	switch query.SortBy {
+	case model.SortByName:
+		sortColumn = "s.name"
+	case model.SortByScore:
+		joins = append(joins, "join grade gs on gs.student_id = s.id and gs.subject = ?")
+		joinArgs = append(joinArgs, query.SortSubject)
+		sortColumn = "gs.score"
+		sortScore = "gs.score"
+	case model.SortByAvg:
+		sortColumn = "s.avg_score"
+		sortScore = sortColumn
+	}
+
+	compare, order := ">", "asc"
+	if query.Desc {
+		compare, order = "<", "desc"
+	}
+	if after := query.After; after != nil {
+		switch {
+		case sortColumn == "":
+			conditions = append(conditions, "s.id "+compare+" ?")
+			args = append(args, after.ID)
+		case sortScore != "":
+			conditions = append(conditions, "("+sortColumn+" "+compare+" ? or ("+sortColumn+" = ? and s.id "+compare+" ?))")
+			args = append(args, *after.Score, *after.Score, after.ID)
+		default:
+			conditions = append(conditions, "("+sortColumn+" "+compare+" ? or ("+sortColumn+" = ? and s.id "+compare+" ?))")
+			args = append(args, after.Name, after.Name, after.ID)
+		}
+	}
+
+	columns := "s.*"
+	if sortScore != "" {
+		columns += ", " + sortScore + " as sort_score"
+	}
+	orderBy := "s.id " + order
+	if sortColumn != "" {
+		orderBy = sortColumn + " " + order + ", " + orderBy
+	}
+	sqlStmt := "select " + columns + " from student s " + strings.Join(joins, " ") +
+		" where " + strings.Join(conditions, " and ") + " order by " + orderBy + " limit ?"
+	args = append(append(joinArgs, args...), query.Limit)
+
+	var rows []model.StudentListRow
+	err := d.DB.Raw(sqlStmt, args...).Scan(&rows).Error
+	return rows, err
+}
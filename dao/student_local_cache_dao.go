@@ -0,0 +1,147 @@
+package dao
+
+import (
+	"errors"
+	"fmt"
+	"memoryDataBase/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StudentLocalCacheDao 进程内的学生缓存 不需要 Redis 服务器 适合单机运行和测试
+// 存取时都会复制学生对象 避免和内存数据库中的对象互相影响 过期语义和 Redis 实现一致
+type StudentLocalCacheDao struct {
+	students map[string]*localCacheEntry
+	rwLock   sync.RWMutex
+	// options 进程内缓存只使用其中的 ScanBatchSize 和 SlidingTTL
+	options StudentCacheOptions
+}
+
+// localCacheEntry 进程内缓存的条目 expireAt 为零值表示永不过期
+type localCacheEntry struct {
+	student  *model.Student
+	expireAt time.Time
+}
+
+func (e *localCacheEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+func NewStudentLocalCacheDao(options StudentCacheOptions) *StudentLocalCacheDao {
+	return &StudentLocalCacheDao{
+		students: make(map[string]*localCacheEntry),
+		options:  options,
+	}
+}
+
+func newLocalCacheEntry(student *model.Student) *localCacheEntry {
+	entry := &localCacheEntry{student: student.Copy()}
+	if student.Expiration > 0 {
+		entry.expireAt = time.Now().Add(time.Duration(student.Expiration) * time.Second)
+	}
+	return entry
+}
+
+func (d *StudentLocalCacheDao) AddStudent(student *model.Student) error {
+	d.rwLock.Lock()
+	defer d.rwLock.Unlock()
+	d.students[student.ID] = newLocalCacheEntry(student)
+	return nil
+}
+
+func (d *StudentLocalCacheDao) GetStudent(id string) (*model.Student, error) {
+	d.rwLock.Lock()
+	defer d.rwLock.Unlock()
+	now := time.Now()
+	entry, exists := d.students[id]
+	if exists && entry.expired(now) {
+		delete(d.students, id)
+		exists = false
+	}
+	if !exists {
+		errMsg := fmt.Sprintf("在缓存中查找不到学号为：%s的学生", id)
+		return nil, errors.New(errMsg)
+	}
+	if !entry.expireAt.IsZero() && d.options.SlidingTTL > 0 {
+		entry.expireAt = now.Add(d.options.SlidingTTL)
+	}
+	return entry.student.Copy(), nil
+}
+
+// Shared 进程内缓存只属于当前实例
+func (d *StudentLocalCacheDao) Shared() bool {
+	return false
+}
+
+func (d *StudentLocalCacheDao) DeleteStudent(id string) error {
+	d.rwLock.Lock()
+	defer d.rwLock.Unlock()
+	delete(d.students, id)
+	return nil
+}
+
+// ReLoadCacheData 先构建新的数据再整体替换 替换前后缓存都不为空
+func (d *StudentLocalCacheDao) ReLoadCacheData(students []*model.Student) error {
+	reloaded := make(map[string]*localCacheEntry, len(students))
+	for _, student := range students {
+		if student == nil {
+			continue
+		}
+		reloaded[student.ID] = newLocalCacheEntry(student)
+	}
+	d.rwLock.Lock()
+	d.students = reloaded
+	d.rwLock.Unlock()
+	return nil
+}
+
+func (d *StudentLocalCacheDao) GetStudents(ids []string) (map[string]*model.Student, error) {
+	d.rwLock.RLock()
+	defer d.rwLock.RUnlock()
+	now := time.Now()
+	students := make(map[string]*model.Student, len(ids))
+	for _, id := range ids {
+		if entry, exists := d.students[id]; exists && !entry.expired(now) {
+			students[id] = entry.student.Copy()
+		}
+	}
+	return students, nil
+}
+
+// ScanStudentIDs 按学号顺序每 ScanBatchSize 个学号调用一次 fn 调用 fn 时不持有锁
+func (d *StudentLocalCacheDao) ScanStudentIDs(fn func(ids []string) error) error {
+	d.rwLock.RLock()
+	ids := make([]string, 0, len(d.students))
+	for id := range d.students {
+		ids = append(ids, id)
+	}
+	d.rwLock.RUnlock()
+	sort.Strings(ids)
+	batchSize := int(d.options.scanBatchSize())
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if err := fn(ids[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *StudentLocalCacheDao) GetAllStudents() ([]*model.Student, error) {
+	d.rwLock.RLock()
+	defer d.rwLock.RUnlock()
+	now := time.Now()
+	students := make([]*model.Student, 0, len(d.students))
+	for _, entry := range d.students {
+		if entry.expired(now) {
+			continue
+		}
+		students = append(students, entry.student.Copy())
+	}
+	sort.Slice(students, func(i, j int) bool { return students[i].ID < students[j].ID })
+	return students, nil
+}
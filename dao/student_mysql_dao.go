@@ -5,21 +5,48 @@ import (
 	"fmt"
 	"gorm.io/gorm"
 	"memoryDataBase/model"
+	"sort"
+	"strings"
+	"time"
 )
 
+// StudentMysqlDao 学生的持久层 同时支持 MySQL 和 SQLite 方言不同的语句根据 dialect 选择
 type StudentMysqlDao struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	dialect string
 }
 
 func NewStudentMysqlDao(db *gorm.DB) *StudentMysqlDao {
 	return &StudentMysqlDao{
-		DB: db,
+		DB:      db,
+		dialect: db.Dialector.Name(),
 	}
 }
 
+// InTx 在一个事务中执行 fn 传给 fn 的 repo 使用这个事务的连接
+func (d *StudentMysqlDao) InTx(fn func(repo StudentRepository) error) error {
+	return d.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(&StudentMysqlDao{DB: tx, dialect: d.dialect})
+	})
+}
+
 func (d *StudentMysqlDao) GetStudent(id string) (*model.StudentDB, error) {
+	return d.getStudent("select * from student where id = ? and deleted_at is null", id)
+}
+
+// GetStudentForUpdate 在事务中读取学生并锁住这一行 事务结束前其他事务不能修改这个学生
+// SQLite 的事务以 _txlock=immediate 开始时已经拿到整个数据库的写锁 不需要也不支持 for update
+func (d *StudentMysqlDao) GetStudentForUpdate(id string) (*model.StudentDB, error) {
+	sqlStmt := "select * from student where id = ? and deleted_at is null"
+	if d.dialect != "sqlite" {
+		sqlStmt += " for update"
+	}
+	return d.getStudent(sqlStmt, id)
+}
+
+func (d *StudentMysqlDao) getStudent(sqlStmt string, id string) (*model.StudentDB, error) {
 	var studentDB model.StudentDB
-	result := d.DB.Raw("select * from student where id = ?", id).Scan(&studentDB)
+	result := d.DB.Raw(sqlStmt, id).Scan(&studentDB)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -29,16 +56,45 @@ func (d *StudentMysqlDao) GetStudent(id string) (*model.StudentDB, error) {
 	return &studentDB, nil
 }
 
-func (d *StudentMysqlDao) AddStudentToMysql(tx *gorm.DB, student *model.Student) error {
-	err := tx.Exec("insert into student (id,name,gender,class,expiration) values (?,?,?,?,?)",
-		student.ID, student.Name, student.Gender, student.Class, student.Expiration).Error
+func (d *StudentMysqlDao) AddStudentToMysql(student *model.Student) error {
+	err := d.DB.Exec("insert into student (id,name,gender,class,expiration,version) values (?,?,?,?,?,?)",
+		student.ID, student.Name, student.Gender, student.Class, student.Expiration, student.Version).Error
 	return err
 }
 
-func (d *StudentMysqlDao) AddGradeToMysql(tx *gorm.DB, subject string, score float64, id string) error {
-	err := tx.Exec("insert into grade (subject, score, student_id) VALUES (?,?,?)",
-		subject, score, id).Error
-	return err
+// UpsertGrades 用一条语句写入学生的多门成绩 已有的学科更新分数 依赖 (student_id, subject) 唯一约束
+func (d *StudentMysqlDao) UpsertGrades(studentId string, grades map[string]float64) error {
+	if len(grades) == 0 {
+		return nil
+	}
+	subjects := make([]string, 0, len(grades))
+	for subject := range grades {
+		subjects = append(subjects, subject)
+	}
+	// 按学科排序 并发写同一个学生时加锁顺序一致 避免死锁
+	sort.Strings(subjects)
+	placeholders := make([]string, len(subjects))
+	args := make([]interface{}, 0, len(subjects)*3)
+	for i, subject := range subjects {
+		placeholders[i] = "(?,?,?)"
+		args = append(args, subject, grades[subject], studentId)
+	}
+	sqlStmt := "insert into grade (subject, score, student_id) values " + strings.Join(placeholders, ",")
+	if d.dialect == "sqlite" {
+		sqlStmt += " on conflict (student_id, subject) do update set score = excluded.score"
+	} else {
+		sqlStmt += " on duplicate key update score = values(score)"
+	}
+	if err := d.DB.Exec(sqlStmt, args...).Error; err != nil {
+		return err
+	}
+	return d.refreshAvgScore(studentId)
+}
+
+// refreshAvgScore 重新计算学生的平均分 按平均分排序时使用 需要和写入成绩在同一个事务中调用
+func (d *StudentMysqlDao) refreshAvgScore(studentId string) error {
+	return d.DB.Exec("update student set avg_score = (select coalesce(avg(g.score), 0) from grade g where g.student_id = ?) where id = ?",
+		studentId, studentId).Error
 }
 
 func (d *StudentMysqlDao) GetGrade(studentId string) ([]model.Grade, error) {
@@ -47,54 +103,106 @@ func (d *StudentMysqlDao) GetGrade(studentId string) ([]model.Grade, error) {
 	return grades, err
 }
 
-func (d *StudentMysqlDao) UpdateStudent(tx *gorm.DB, student *model.Student) error {
+// UpdateStudent 更新学生并把版本号设置为 student.Version
+// currentVersion 大于0时只有数据库中的版本号等于它才会更新 否则返回学生已经被修改的错误
+func (d *StudentMysqlDao) UpdateStudent(student *model.Student, currentVersion int64) error {
 	sqlStmt := `
         UPDATE student
         SET
-            name = IF(COALESCE(?, '') != '', ?, name),
-            gender = IF(COALESCE(?, '') != '', ?, gender),
-            class = IF(COALESCE(?, '') != '', ?, class)
-        WHERE id = ?
+            name = CASE WHEN COALESCE(?, '') != '' THEN ? ELSE name END,
+            gender = CASE WHEN COALESCE(?, '') != '' THEN ? ELSE gender END,
+            class = CASE WHEN COALESCE(?, '') != '' THEN ? ELSE class END,
+            version = ?
+        WHERE id = ? AND deleted_at IS NULL
     `
-
-	err := tx.Exec(sqlStmt,
+	args := []interface{}{
 		student.Name, student.Name,
 		student.Gender, student.Gender,
 		student.Class, student.Class,
-		student.ID).Error
-	return err
-}
-
-func (d *StudentMysqlDao) UpdateGrade(tx *gorm.DB, subject string, score float64, studentId string) error {
-	err := tx.Exec("update grade set score=? where id=? and subject=?", score, studentId, subject).Error
-	return err
+		student.Version,
+		student.ID,
+	}
+	if currentVersion <= 0 {
+		return d.DB.Exec(sqlStmt, args...).Error
+	}
+	result := d.DB.Exec(sqlStmt+" AND version = ?", append(args, currentVersion)...)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return studentModified(student.ID)
+	}
+	return nil
 }
 
-func (d *StudentMysqlDao) DeleteStudent(tx *gorm.DB, id string) error {
-	err := tx.Exec("delete from student where id = ?", id).Error
-	return err
+// DeleteStudent 把学生标记为在 deletedAt 删除 成绩保留到学生被彻底删除
+// currentVersion 大于0时只有数据库中的版本号等于它才会删除
+func (d *StudentMysqlDao) DeleteStudent(id string, deletedAt time.Time, currentVersion int64) error {
+	sqlStmt := "update student set deleted_at = ? where id = ? and deleted_at is null"
+	if currentVersion <= 0 {
+		return d.DB.Exec(sqlStmt, deletedAt, id).Error
+	}
+	result := d.DB.Exec(sqlStmt+" and version = ?", deletedAt, id, currentVersion)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return studentModified(id)
+	}
+	return nil
 }
 
-func (d *StudentMysqlDao) DeleteScore(tx *gorm.DB, id string) error {
-	err := tx.Exec("delete from grade where student_id = ?", id).Error
-	return err
+// studentModified 读取学生之后 写入之前学生被其他请求修改或删除了
+func studentModified(id string) error {
+	return fmt.Errorf("学生：%s已经被其他请求修改", id)
 }
 
-func (d *StudentMysqlDao) GetGradeBySubject(id string, subject string) (*model.Grade, error) {
-	var grade *model.Grade
-	err := d.DB.Raw("select * from grade where subject = ? and student_id = ?", subject, id).Scan(&grade).Error
-	return grade, err
+func (d *StudentMysqlDao) DeleteScore(id string) error {
+	if err := d.DB.Exec("delete from grade where student_id = ?", id).Error; err != nil {
+		return err
+	}
+	return d.refreshAvgScore(id)
 }
 
 func (d *StudentMysqlDao) GetAllStudents() ([]model.StudentDB, error) {
 	var studentDBs []model.StudentDB
-	err := d.DB.Raw("select * from student").Scan(&studentDBs).Error
+	err := d.DB.Raw("select * from student where deleted_at is null").Scan(&studentDBs).Error
 	if err != nil {
 		return nil, err
 	}
 	return studentDBs, nil
 }
 
+func (d *StudentMysqlDao) GetAllStudentIDs() ([]string, error) {
+	var ids []string
+	err := d.DB.Raw("select id from student where deleted_at is null").Scan(&ids).Error
+	return ids, err
+}
+
+func (d *StudentMysqlDao) GetExistingStudentIDs(ids []string) ([]string, error) {
+	var existing []string
+	if len(ids) == 0 {
+		return existing, nil
+	}
+	err := d.DB.Raw("select id from student where id in ? and deleted_at is null", ids).Scan(&existing).Error
+	return existing, err
+}
+
+func (d *StudentMysqlDao) GetStudentsAfter(afterId string, limit int) ([]model.StudentDB, error) {
+	var studentDBs []model.StudentDB
+	err := d.DB.Raw("select * from student where id > ? and deleted_at is null order by id limit ?", afterId, limit).Scan(&studentDBs).Error
+	return studentDBs, err
+}
+
+func (d *StudentMysqlDao) GetGradesByStudentIDs(ids []string) ([]model.Grade, error) {
+	var grades []model.Grade
+	if len(ids) == 0 {
+		return grades, nil
+	}
+	err := d.DB.Raw("select * from grade where student_id in ?", ids).Scan(&grades).Error
+	return grades, err
+}
+
 func (d *StudentMysqlDao) GetStudentCount(id string) (*model.StudentCount, error) {
 	var count model.StudentCount
 	result := d.DB.Raw("select * from student_count where student_id = ?", id).Scan(&count)
@@ -107,14 +215,32 @@ func (d *StudentMysqlDao) GetStudentCount(id string) (*model.StudentCount, error
 	return &count, nil
 }
 
-func (d *StudentMysqlDao) AddStudentCount(id string) error {
-	err := d.DB.Exec("insert into student_count (student_id) values (?)", id).Error
-	return err
-}
-
-func (d *StudentMysqlDao) UpdateStudentCount(record *model.StudentCount) error {
-	err := d.DB.Exec("update student_count set count=? where student_id = ?", record.Count, record.StudentId).Error
-	return err
+// IncrementStudentCounts 用一条语句给多个学生的访问次数加上增量 没有记录时插入
+func (d *StudentMysqlDao) IncrementStudentCounts(deltas map[string]int64) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(deltas))
+	for id := range deltas {
+		ids = append(ids, id)
+	}
+	// 按学号排序 多个批次并发写入时加锁顺序一致 避免死锁
+	sort.Strings(ids)
+	rows := make([]string, len(ids))
+	args := make([]interface{}, 0, len(ids)*2)
+	for i, id := range ids {
+		rows[i] = "select ? as student_id, ? as delta"
+		args = append(args, id, deltas[id])
+	}
+	// 只给没有被删除的学生计数 被彻底删除的学生不会违反外键约束 被软删除的学生的访问次数保持删除时的值
+	sqlStmt := "insert into student_count (student_id, count) select d.student_id, d.delta from (" +
+		strings.Join(rows, " union all ") + ") d join student s on s.id = d.student_id where s.deleted_at is null"
+	if d.dialect == "sqlite" {
+		sqlStmt += " on conflict (student_id) do update set count = student_count.count + excluded.count"
+	} else {
+		sqlStmt += " on duplicate key update count = student_count.count + values(count)"
+	}
+	return d.DB.Exec(sqlStmt, args...).Error
 }
 
 func (d *StudentMysqlDao) DeleteStudentCount(id string) error {
@@ -122,8 +248,11 @@ func (d *StudentMysqlDao) DeleteStudentCount(id string) error {
 	return err
 }
 
-func (d *StudentMysqlDao) GetHotStudentCounts() ([]*model.StudentCount, error) {
+func (d *StudentMysqlDao) GetHotStudentCounts(limit int) ([]*model.StudentCount, error) {
 	var counts []*model.StudentCount
-	err := d.DB.Raw("select * from student_count order by count desc limit 10").Scan(&counts).Error
+	err := d.DB.Raw(`
+        select c.* from student_count c join student s on s.id = c.student_id
+        where s.deleted_at is null order by c.count desc limit ?
+    `, limit).Scan(&counts).Error
 	return counts, err
 }
@@ -0,0 +1,38 @@
+package dao
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// TestGetStudentForUpdate MySQL 读取时锁住学生 SQLite 的事务开始时已经拿到写锁 不加 for update
+func TestGetStudentForUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		dialector gorm.Dialector
+		forUpdate bool
+	}{
+		// 不连接数据库 只生成语句
+		{"mysql", mysql.New(mysql.Config{DSN: "root@tcp(127.0.0.1:1)/mdb", SkipInitializeWithVersion: true}), true},
+		{"sqlite", sqlite.Open(":memory:"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := gorm.Open(tc.dialector, &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+			if err != nil {
+				t.Fatalf("打开数据库失败：%v", err)
+			}
+			var sqlStmt string
+			db.Callback().Row().After("gorm:row").Register("test:capture", func(db *gorm.DB) {
+				sqlStmt = db.Statement.SQL.String()
+			})
+			NewStudentMysqlDao(db).GetStudentForUpdate("s1")
+			if got := strings.HasSuffix(sqlStmt, " for update"); got != tc.forUpdate {
+				t.Fatalf("%s 的语句 = %q，期望 for update：%v", tc.name, sqlStmt, tc.forUpdate)
+			}
+		})
+	}
+}
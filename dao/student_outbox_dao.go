@@ -0,0 +1,39 @@
+package dao
+
+import (
+	"memoryDataBase/model"
+	"time"
+)
+
+// AddOutboxEvent 写入一条变更 写入后 event.ID 是变更的编号
+func (d *StudentMysqlDao) AddOutboxEvent(event *model.OutboxEvent) error {
+	err := d.DB.Table("student_outbox").Select("StudentId", "Op", "Payload", "Origin").Create(event).Error
+	return err
+}
+
+// GetFirstOutboxEvent 返回 origin 写入的学生最早没有应用的变更 没有时返回nil
+func (d *StudentMysqlDao) GetFirstOutboxEvent(origin string, studentId string) (*model.OutboxEvent, error) {
+	var events []*model.OutboxEvent
+	err := d.DB.Raw("select * from student_outbox where origin = ? and student_id = ? order by id limit 1", origin, studentId).Scan(&events).Error
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	return events[0], nil
+}
+
+func (d *StudentMysqlDao) GetPendingOutboxEvents(origin string, afterId uint64, limit int) ([]*model.OutboxEvent, error) {
+	var events []*model.OutboxEvent
+	err := d.DB.Raw("select * from student_outbox where origin = ? and id > ? order by id limit ?", origin, afterId, limit).Scan(&events).Error
+	return events, err
+}
+
+func (d *StudentMysqlDao) DeleteOutboxEvent(id uint64) error {
+	err := d.DB.Exec("delete from student_outbox where id = ?", id).Error
+	return err
+}
+
+func (d *StudentMysqlDao) MarkOutboxEventFailed(id uint64, reason string, nextAttemptAt time.Time) error {
+	err := d.DB.Exec("update student_outbox set attempts = attempts + 1, last_error = ?, next_attempt_at = ? where id = ?",
+		reason, nextAttemptAt, id).Error
+	return err
+}
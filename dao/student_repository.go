@@ -0,0 +1,69 @@
+package dao
+
+import (
+	"memoryDataBase/model"
+	"time"
+)
+
+// StudentRepository 学生持久化层 需要一起提交的读写在 InTx 中执行
+type StudentRepository interface {
+	// InTx 在一个事务中执行 fn fn 中通过 repo 的读写都属于这个事务 fn 返回错误或 panic 时回滚 否则提交
+	InTx(fn func(repo StudentRepository) error) error
+
+	GetStudent(id string) (*model.StudentDB, error)
+	// GetStudentForUpdate 在 InTx 中读取学生并锁住 读取后到提交前学生不会被其他事务修改
+	GetStudentForUpdate(id string) (*model.StudentDB, error)
+	GetAllStudents() ([]model.StudentDB, error)
+	GetAllStudentIDs() ([]string, error)
+	// GetExistingStudentIDs 返回 ids 中在数据库中存在且没有被删除的学号
+	GetExistingStudentIDs(ids []string) ([]string, error)
+	// GetStudentsAfter 按学号顺序返回学号大于 afterId 的最多 limit 个学生 用于分批遍历所有学生
+	GetStudentsAfter(afterId string, limit int) ([]model.StudentDB, error)
+	// ListStudents 按条件和排序返回一页学生 用上一页最后一个学生的位置翻页
+	ListStudents(query *model.StudentQuery) ([]model.StudentListRow, error)
+	AddStudentToMysql(student *model.Student) error
+	UpdateStudent(student *model.Student, currentVersion int64) error
+	DeleteStudent(id string, deletedAt time.Time, currentVersion int64) error
+
+	// 软删除的学生 超过保留期后彻底删除
+	GetDeletedStudent(id string) (*model.StudentDB, error)
+	RestoreStudent(id string, deletedAfter time.Time) error
+	GetStudentsDeletedBefore(before time.Time, limit int) ([]string, error)
+	PurgeStudent(id string) error
+
+	GetGrade(studentId string) ([]model.Grade, error)
+	GetGradesByStudentIDs(ids []string) ([]model.Grade, error)
+	// UpsertGrades 一条语句写入学生的多门成绩 已存在的学科更新分数
+	UpsertGrades(studentId string, grades map[string]float64) error
+	DeleteScore(id string) error
+
+	GetStudentCount(id string) (*model.StudentCount, error)
+	// IncrementStudentCounts 一条语句给多个学生的访问次数加上增量
+	IncrementStudentCounts(deltas map[string]int64) error
+	DeleteStudentCount(id string) error
+	GetHotStudentCounts(limit int) ([]*model.StudentCount, error)
+
+	// 发件箱 和学生数据在同一个事务中写入 提交后由中继应用到缓存和内存
+	AddOutboxEvent(event *model.OutboxEvent) error
+	// GetPendingOutboxEvents 按写入顺序返回 origin 写入的编号大于 afterId 的最多 limit 条变更
+	GetPendingOutboxEvents(origin string, afterId uint64, limit int) ([]*model.OutboxEvent, error)
+	// GetFirstOutboxEvent 返回 origin 写入的学生最早没有应用的变更 没有时返回nil
+	GetFirstOutboxEvent(origin string, studentId string) (*model.OutboxEvent, error)
+	DeleteOutboxEvent(id uint64) error
+	// MarkOutboxEventFailed 记录一次失败的尝试 变更在 nextAttemptAt 之后才重试
+	MarkOutboxEventFailed(id uint64, reason string, nextAttemptAt time.Time) error
+
+	// 异步写数据库时每个学生已经写入的日志序号 和学生数据在同一个事务中写入 重新写入时跳过已经写入的变更
+	GetWriteBehindSeq(journalId string, studentId string) (uint64, error)
+	SetWriteBehindSeq(journalId string, studentId string, seq uint64) error
+	DeleteWriteBehindSeqs(journalId string, ackedSeq uint64) error
+
+	// 审计记录 和学生数据在同一个事务中写入 只追加不修改
+	AddAuditEntries(entries []*model.StudentAudit) error
+	GetStudentAudits(studentId string, offset, limit int) ([]*model.StudentAudit, error)
+	CountStudentAudits(studentId string) (int64, error)
+	GetStudentAuditsAfter(studentId string, after time.Time) ([]*model.StudentAudit, error)
+}
+
+// 确保 MySQL 实现满足 StudentRepository 接口
+var _ StudentRepository = (*StudentMysqlDao)(nil)
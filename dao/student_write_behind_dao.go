@@ -0,0 +1,28 @@
+package dao
+
+// GetWriteBehindSeq 返回日志中已经写入数据库的学生的最大序号 没有记录时返回0
+func (d *StudentMysqlDao) GetWriteBehindSeq(journalId string, studentId string) (uint64, error) {
+	var seqs []uint64
+	err := d.DB.Raw("select seq from student_write_behind where journal_id = ? and student_id = ?", journalId, studentId).
+		Scan(&seqs).Error
+	if err != nil || len(seqs) == 0 {
+		return 0, err
+	}
+	return seqs[0], nil
+}
+
+// SetWriteBehindSeq 记录学生已经写入到日志中的序号 需要和学生数据在同一个事务中写入
+func (d *StudentMysqlDao) SetWriteBehindSeq(journalId string, studentId string, seq uint64) error {
+	sqlStmt := "insert into student_write_behind (journal_id, student_id, seq) values (?,?,?)"
+	if d.dialect == "sqlite" {
+		sqlStmt += " on conflict (journal_id, student_id) do update set seq = excluded.seq"
+	} else {
+		sqlStmt += " on duplicate key update seq = values(seq)"
+	}
+	return d.DB.Exec(sqlStmt, journalId, studentId, seq).Error
+}
+
+// DeleteWriteBehindSeqs 删除已经确认的序号 这些变更不会再被重新写入
+func (d *StudentMysqlDao) DeleteWriteBehindSeqs(journalId string, ackedSeq uint64) error {
+	return d.DB.Exec("delete from student_write_behind where journal_id = ? and seq <= ?", journalId, ackedSeq).Error
+}
@@ -1,17 +1,45 @@
 package database
 
 import (
+	"fmt"
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"os"
+	"path/filepath"
+)
+
+// 持久层支持的数据库
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
 )
 
 var DB *gorm.DB
 
-func InitDB(dsn string) error {
+// InitDB 连接数据库 driver 为 sqlite 时 dsn 是数据库文件的路径
+func InitDB(driver string, dsn string) error {
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverMySQL:
+		dialector = mysql.Open(dsn)
+	case DriverSQLite:
+		if err := os.MkdirAll(filepath.Dir(dsn), 0o755); err != nil {
+			return fmt.Errorf("创建 SQLite 数据库目录失败：%w", err)
+		}
+		dialector = sqlite.Open(sqliteDSN(dsn))
+	default:
+		return fmt.Errorf("不支持的数据库：%s", driver)
+	}
 	var err error
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	DB, err = gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// sqliteDSN 打开外键约束和 WAL 日志 写事务开始时就加锁 避免两个事务都读完再升级写锁时死锁
+func sqliteDSN(path string) string {
+	return path + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate"
+}
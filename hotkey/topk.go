@@ -0,0 +1,236 @@
+package hotkey
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// sketchDepth Count-Min Sketch 的行数 每行使用不同的哈希位置
+	sketchDepth = 4
+	// minSketchWidth 每行最少的计数器数量 实际宽度随 K 增大
+	minSketchWidth = 2048
+	// rescaleThreshold 增量权重超过这个值时把所有计数折算到当前时刻 避免浮点数溢出
+	rescaleThreshold = 1e12
+)
+
+// Entry 一个热点键和衰减到当前时刻的估计访问次数
+type Entry struct {
+	Key   string  `json:"key"`
+	Count float64 `json:"count"`
+}
+
+// TopK 按时间衰减的热点键统计 Count-Min Sketch 估计每个键的访问次数 最小堆保留估计值最大的 K 个键
+// 每经过一个半衰期 之前的访问次数减半 很久以前的热点会逐渐被新的热点替换
+// 衰减不逐个修改计数器 而是让新的访问带上随时间指数增长的权重 读取时再统一折算
+type TopK struct {
+	mu       sync.Mutex
+	k        int
+	halfLife time.Duration
+	counters [][]float64
+	// total 所有访问的权重之和 和计数器一样按 epoch 折算
+	total float64
+	// epoch 权重为1的时刻
+	epoch   time.Time
+	entries entryHeap
+	index   map[string]*entry
+	now     func() time.Time
+}
+
+// New 创建保留 k 个热点键 访问次数每 halfLife 减半的统计
+func New(k int, halfLife time.Duration) *TopK {
+	if k <= 0 {
+		k = 1
+	}
+	if halfLife <= 0 {
+		halfLife = time.Hour
+	}
+	width := minSketchWidth
+	if k*64 > width {
+		width = k * 64
+	}
+	counters := make([][]float64, sketchDepth)
+	for i := range counters {
+		counters[i] = make([]float64, width)
+	}
+	return &TopK{
+		k:        k,
+		halfLife: halfLife,
+		counters: counters,
+		epoch:    time.Now(),
+		index:    make(map[string]*entry),
+		now:      time.Now,
+	}
+}
+
+// Add 记录一次访问
+func (t *TopK) Add(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	weight := t.weight(now)
+	if weight > rescaleThreshold {
+		t.rescale(now, weight)
+		weight = 1
+	}
+	estimate := t.increment(key, weight)
+	t.total += weight
+
+	if e, exists := t.index[key]; exists {
+		e.count = estimate
+		heap.Fix(&t.entries, e.pos)
+		return
+	}
+	if len(t.entries) < t.k {
+		e := &entry{key: key, count: estimate}
+		heap.Push(&t.entries, e)
+		t.index[key] = e
+		return
+	}
+	// 估计值超过堆中最小的键时替换它
+	if smallest := t.entries[0]; estimate > smallest.count {
+		delete(t.index, smallest.key)
+		smallest.key = key
+		smallest.count = estimate
+		t.index[key] = smallest
+		heap.Fix(&t.entries, 0)
+	}
+}
+
+// Remove 把键从热点中去掉 例如键已经被删除 同时从计数器中减去它的估计值
+// 否则键重新出现后第一次访问就会带着之前的计数回到热点中
+func (t *TopK) Remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = math.Max(0, t.total-t.decrement(key))
+	if e, exists := t.index[key]; exists {
+		heap.Remove(&t.entries, e.pos)
+		delete(t.index, key)
+	}
+}
+
+// Top 返回当前的热点键 按衰减后的访问次数从大到小排序
+func (t *TopK) Top() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	weight := t.weight(t.now())
+	result := make([]Entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		result = append(result, Entry{Key: e.key, Count: e.count / weight})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// Total 返回衰减到当前时刻的总访问次数 次数太少时统计出的热点不可靠
+func (t *TopK) Total() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total / t.weight(t.now())
+}
+
+// Keys 返回当前的热点键 按访问次数从大到小排序
+func (t *TopK) Keys() []string {
+	top := t.Top()
+	keys := make([]string, len(top))
+	for i, e := range top {
+		keys[i] = e.Key
+	}
+	return keys
+}
+
+// weight 某一时刻的一次访问相对 epoch 的权重
+func (t *TopK) weight(now time.Time) float64 {
+	return math.Exp2(float64(now.Sub(t.epoch)) / float64(t.halfLife))
+}
+
+// rescale 把所有计数折算到 now 之后的权重从1开始 很久没有访问时 weight 可能是无穷大 计数都变成0
+func (t *TopK) rescale(now time.Time, weight float64) {
+	for _, row := range t.counters {
+		for i := range row {
+			row[i] /= weight
+		}
+	}
+	for _, e := range t.entries {
+		e.count /= weight
+	}
+	t.total /= weight
+	t.epoch = now
+}
+
+// increment 给键在每一行对应的计数器加上权重 返回所有行中最小的计数作为估计值
+func (t *TopK) increment(key string, weight float64) float64 {
+	positions := t.positions(key)
+	estimate := math.Inf(1)
+	for i, row := range t.counters {
+		row[positions[i]] += weight
+		estimate = math.Min(estimate, row[positions[i]])
+	}
+	return estimate
+}
+
+// decrement 从键在每一行对应的计数器中减去键的估计值 返回减去的值
+// 估计值是所有行中最小的计数 减去后每个计数器都不会小于0 共用计数器的其他键最多被少算这一行多出的部分
+func (t *TopK) decrement(key string) float64 {
+	positions := t.positions(key)
+	estimate := math.Inf(1)
+	for i, row := range t.counters {
+		estimate = math.Min(estimate, row[positions[i]])
+	}
+	for i, row := range t.counters {
+		row[positions[i]] -= estimate
+	}
+	return estimate
+}
+
+// positions 键在每一行中对应的计数器位置
+func (t *TopK) positions(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	var positions [sketchDepth]uint64
+	for i, row := range t.counters {
+		positions[i] = (h1 + uint64(i)*h2) % uint64(len(row))
+	}
+	return positions
+}
+
+type entry struct {
+	key   string
+	count float64
+	pos   int
+}
+
+// entryHeap 按估计值排序的最小堆 堆顶是 K 个热点中访问最少的
+type entryHeap []*entry
+
+func (h entryHeap) Len() int           { return len(h) }
+func (h entryHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.pos = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,53 @@
+package hotkey
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestTopK 创建时钟由测试控制的统计
+func newTestTopK(k int, halfLife time.Duration) (*TopK, *time.Time) {
+	t := New(k, halfLife)
+	now := t.epoch
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func TestTopKDecay(t *testing.T) {
+	topK, now := newTestTopK(2, time.Minute)
+	for i := 0; i < 8; i++ {
+		topK.Add("a")
+	}
+	for i := 0; i < 4; i++ {
+		topK.Add("b")
+	}
+	*now = now.Add(time.Minute)
+	topK.Add("c")
+	if total := topK.Total(); math.Abs(total-7) > 1e-9 {
+		t.Fatalf("一个半衰期后的总访问次数 = %v，期望 7", total)
+	}
+	top := topK.Top()
+	if len(top) != 2 || top[0].Key != "a" || math.Abs(top[0].Count-4) > 1e-9 || top[1].Key != "b" {
+		t.Fatalf("热点 = %+v，期望衰减到4次的a和b", top)
+	}
+}
+
+// TestTopKRemove 去掉的键的计数也被减掉 重新出现时从0开始计数
+func TestTopKRemove(t *testing.T) {
+	topK, _ := newTestTopK(3, time.Hour)
+	for i := 0; i < 10; i++ {
+		topK.Add("a")
+	}
+	topK.Add("b")
+	topK.Add("c")
+	topK.Remove("a")
+	if total := topK.Total(); math.Abs(total-2) > 1e-9 {
+		t.Fatalf("去掉a后的总访问次数 = %v，期望 2", total)
+	}
+	topK.Add("a")
+	top := topK.Top()
+	if len(top) != 3 || math.Abs(top[0].Count-1) > 1e-9 {
+		t.Fatalf("去掉a后重新访问一次的热点 = %+v，期望每个键都只有1次", top)
+	}
+}
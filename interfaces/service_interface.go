@@ -6,9 +6,16 @@ import (
 
 // StudentServiceInterface 定义学生服务接口 解决fsm依赖service service依赖fsm导致的循环导入问题。。。
 type StudentServiceInterface interface {
-	AddStudentInternal(student *model.Student) error
-	UpdateStudentInternal(student *model.Student) error
-	DeleteStudentInternal(id string) error
+	// 写操作的 audit 是发起变更的人和请求 和变更一起写入审计记录
+	AddStudentInternal(student *model.Student, audit model.AuditContext) error
+	// UpdateStudentInternal 和 DeleteStudentInternal 只有学生当前的版本号等于请求中的版本号才会修改
+	UpdateStudentInternal(student *model.Student, audit model.AuditContext) error
+	DeleteStudentInternal(id string, version int64, audit model.AuditContext) error
+	// RestoreStudentInternal 恢复保留期内被删除的学生
+	RestoreStudentInternal(id string, audit model.AuditContext) error
 	ReloadCacheDataInternal()
 	PeriodicDeleteInternal()
+	// SnapshotState 和 RestoreState 用于 Raft 快照的保存和恢复
+	SnapshotState() ([]byte, error)
+	RestoreState(data []byte) error
 }
@@ -2,40 +2,98 @@ package main
 
 import (
 	"log"
+	"memoryDataBase/bus"
 	"memoryDataBase/cache"
+	"memoryDataBase/config"
 	"memoryDataBase/controller"
 	"memoryDataBase/dao"
 	"memoryDataBase/database"
+	"memoryDataBase/migrations"
+	"memoryDataBase/resp"
 	"memoryDataBase/routers"
 	"memoryDataBase/service"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reconcile":
+			os.Exit(runReconcile(os.Args[0]+" reconcile", os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[0]+" migrate", os.Args[2:]))
+		}
+	}
+
+	cfg, err := config.Parse(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatalf("解析启动参数失败：%v", err)
+	}
+
 	// 初始化数据库和缓存
-	dsn := "root:1234@tcp(127.0.0.1:3306)/mdb?charset=utf8mb4&parseTime=True&loc=Local"
-	err := database.InitDB(dsn)
+	err = database.InitDB(cfg.DBDriver, cfg.DatabaseDSN())
 	if err != nil {
 		log.Fatalf("Failed to initialize mysqlDataBase: %v", err)
 	}
-	cache.InitRedis("192.168.88.128:6379", "123456", 0)
+	if cfg.AutoMigrate {
+		migrator, err := migrations.New(database.DB)
+		if err != nil {
+			log.Fatalf("读取数据库迁移失败：%v", err)
+		}
+		if _, err = migrator.Up(); err != nil {
+			log.Fatalf("执行数据库迁移失败：%v", err)
+		}
+	}
 
 	// 初始化 DAO
-	studentCacheDao := dao.NewStudentCacheDao(cache.RedisClient)
+	// 发件箱按实例区分 标识保存在本地文件中 实例重启后继续处理自己没有应用的变更
+	outboxOrigin, err := service.LoadOutboxOrigin(cfg.OutboxOriginFile)
+	if err != nil {
+		log.Fatalf("读取发件箱标识失败：%v", err)
+	}
+	if cfg.CacheBackend == config.CacheBackendRedis || cfg.Invalidation {
+		cache.InitRedis(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+	}
+	var studentCacheDao dao.StudentCache
+	if cfg.CacheBackend == config.CacheBackendRedis {
+		studentCacheDao = dao.NewStudentCacheDao(cache.RedisClient, studentCacheOptions(cfg))
+	} else {
+		log.Printf("使用进程内缓存代替 Redis")
+		studentCacheDao = dao.NewStudentLocalCacheDao(studentCacheOptions(cfg))
+	}
 	studentMysqlDao := dao.NewStudentMysqlDao(database.DB)
-	memoryDBDao := dao.NewMemoryDBDao()
+	memoryDB := dao.NewMemoryNamespaceDao()
 
 	// 初始化服务
 	studentCacheService := service.NewStudentCacheService(studentCacheDao)
 	studentMysqlService := service.NewStudentMysqlService(studentMysqlDao)
-	studentMdbService := service.NewStudentMdbService(memoryDBDao)
-	studentService, err := service.NewStudentService(studentMdbService, studentMysqlService, studentCacheService, "127.0.0.1")
+	studentMdbService := service.NewStudentMdbService(memoryDB, cfg.NegativeCacheTTL)
+	studentService, err := service.NewStudentService(studentMdbService, studentMysqlService, studentCacheService,
+		cfg.RaftID, studentServiceOptions(cfg, outboxOrigin))
 	if err != nil {
 		log.Fatalf("初始化学生服务层失败：%v", err)
 	}
+	if cfg.Invalidation {
+		invalidationBus := bus.NewRedisBus(cache.RedisClient, cfg.InvalidationChannel, cfg.InstanceID)
+		if err = studentService.EnableInvalidation(invalidationBus); err != nil {
+			log.Fatalf("启用失效通知失败：%v", err)
+		}
+	}
+	adminService := service.NewAdminService(memoryDB, studentService)
 
 	// 初始化控制器
 	studentController := controller.NewStudentController(studentService)
+	adminController := controller.NewAdminController(adminService)
+
+	if err = studentService.BuildStudentFilter(); err != nil {
+		log.Printf("构建学号布隆过滤器失败：%v", err)
+	}
+	if err = studentService.BuildNameIndex(); err != nil {
+		log.Printf("构建姓名索引失败：%v", err)
+	}
 
 	//启动时加载缓存数据到内存
 	if err = studentService.LoadCacheToMemory(); err != nil {
@@ -53,6 +111,66 @@ func main() {
 		studentService.PeriodicDelete(time.Hour)
 	}()
 
+	go func() {
+		studentService.PurgeDeletedStudents(cfg.PurgeInterval)
+	}()
+
+	// 以 Redis 协议对外暴露内存数据库 方便使用 redis-cli 查看和操作
+	if cfg.RESPAddr != "" {
+		respServer := resp.NewServer(memoryDB, service.StudentNamespace, resp.Options{
+			Password:            cfg.RESPPassword,
+			ProtectedNamespaces: []string{service.StudentNamespace, service.MissingStudentNamespace},
+		})
+		go func() {
+			if err := respServer.ListenAndServe(cfg.RESPAddr); err != nil {
+				log.Printf("RESP 服务器退出：%v", err)
+			}
+		}()
+	}
+
+	// 退出前停止后台写数据库 尽量把队列中的变更写完
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		if err := studentService.Close(); err != nil {
+			log.Printf("关闭学生服务失败：%v", err)
+		}
+		os.Exit(0)
+	}()
+
 	r := routers.SetUpStudentRouter(studentController)
-	r.Run(":8080")
+	routers.SetUpAdminRouter(r, adminController)
+	r.Run(cfg.HTTPAddr)
+}
+
+// studentCacheOptions 根据命令行参数生成学生缓存的配置
+func studentCacheOptions(cfg *config.Config) dao.StudentCacheOptions {
+	return dao.StudentCacheOptions{
+		ScanBatchSize: cfg.CacheScanBatchSize,
+		TTLJitter:     cfg.CacheTTLJitter,
+		SlidingTTL:    cfg.CacheSlidingTTL,
+	}
+}
+
+// studentServiceOptions 根据命令行参数生成学生服务的配置 没有对应参数的配置使用默认值
+func studentServiceOptions(cfg *config.Config, outboxOrigin string) service.StudentServiceOptions {
+	options := service.DefaultStudentServiceOptions()
+	options.Outbox.Origin = outboxOrigin
+	options.WriteBehind.Enabled = cfg.WriteBehind
+	options.WriteBehind.Dir = cfg.WriteBehindDir
+	options.WriteBehind.BatchSize = cfg.WriteBehindBatchSize
+	options.WriteBehind.FlushInterval = cfg.WriteBehindInterval
+	options.WriteBehind.MaxPending = cfg.WriteBehindMaxPending
+	options.AccessCount.FlushInterval = cfg.AccessCountInterval
+	options.AccessCount.MaxPending = cfg.AccessCountMaxPending
+	options.HotStudents.K = cfg.HotStudentsK
+	options.HotStudents.HalfLife = cfg.HotStudentsHalfLife
+	options.HotStudents.MinHits = cfg.HotStudentsMinHits
+	options.Filter.Enabled = cfg.StudentFilter
+	options.Filter.ExpectedItems = cfg.StudentFilterExpectedItems
+	options.Filter.FalsePositiveRate = cfg.StudentFilterFalsePositiveRate
+	options.SoftDelete.Retention = cfg.SoftDeleteRetention
+	options.EarlyRefreshBeta = cfg.EarlyRefreshBeta
+	return options
 }
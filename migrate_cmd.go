@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"memoryDataBase/config"
+	"memoryDataBase/database"
+	"memoryDataBase/migrations"
+	"os"
+	"text/tabwriter"
+)
+
+// runMigrate 管理数据库迁移 用法：migrate [参数] up|down|status
+func runMigrate(name string, args []string) int {
+	cfg := config.Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&cfg.DBDriver, "db-driver", cfg.DBDriver, "持久层使用的数据库：mysql 或 sqlite")
+	fs.StringVar(&cfg.MysqlDSN, "mysql-dsn", cfg.MysqlDSN, "MySQL 连接串")
+	fs.StringVar(&cfg.SQLitePath, "sqlite-path", cfg.SQLitePath, "SQLite 数据库文件的路径")
+	steps := fs.Int("steps", 1, "down 时回滚的迁移个数")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法：%s [参数] up|down|status\n", name)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Printf("解析启动参数失败：%v", err)
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	if err := database.InitDB(cfg.DBDriver, cfg.DatabaseDSN()); err != nil {
+		log.Printf("连接数据库失败：%v", err)
+		return 1
+	}
+	migrator, err := migrations.New(database.DB)
+	if err != nil {
+		log.Printf("读取数据库迁移失败：%v", err)
+		return 1
+	}
+
+	switch fs.Arg(0) {
+	case "up":
+		done, err := migrator.Up()
+		if err != nil {
+			log.Printf("%v", err)
+			return 1
+		}
+		fmt.Printf("执行了%d个迁移\n", len(done))
+	case "down":
+		if *steps <= 0 {
+			log.Printf("steps 必须是正整数")
+			return 2
+		}
+		done, err := migrator.Down(*steps)
+		if err != nil {
+			log.Printf("%v", err)
+			return 1
+		}
+		fmt.Printf("回滚了%d个迁移\n", len(done))
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Printf("%v", err)
+			return 1
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "未执行"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(writer, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		writer.Flush()
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS student (
+    id VARCHAR(64) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    gender VARCHAR(16) NOT NULL,
+    class VARCHAR(64) NOT NULL,
+    expiration BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS grade (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    subject VARCHAR(64) NOT NULL,
+    score DOUBLE NOT NULL,
+    student_id VARCHAR(64) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_grade_student_subject (student_id, subject),
+    CONSTRAINT fk_grade_student FOREIGN KEY (student_id) REFERENCES student (id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
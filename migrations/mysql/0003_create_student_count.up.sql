@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS student_count (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    student_id VARCHAR(64) NOT NULL,
+    count INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_student_count_student (student_id),
+    KEY idx_student_count_count (count),
+    CONSTRAINT fk_student_count_student FOREIGN KEY (student_id) REFERENCES student (id) ON DELETE CASCADE
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
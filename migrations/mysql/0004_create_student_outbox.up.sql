@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS student_outbox (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    student_id VARCHAR(64) NOT NULL,
+    op VARCHAR(16) NOT NULL,
+    payload TEXT,
+    origin VARCHAR(128) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error VARCHAR(512) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY idx_student_outbox_origin (origin, id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
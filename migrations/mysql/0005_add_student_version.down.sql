@@ -0,0 +1 @@
+ALTER TABLE student DROP COLUMN version;
@@ -0,0 +1,2 @@
+-- 乐观并发控制 每次修改学生时版本号加一
+ALTER TABLE student ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,15 @@
+-- 只追加的审计表 每行是一次变更中一个字段的旧值和新值 学生删除后仍然保留
+CREATE TABLE IF NOT EXISTS student_audit (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    student_id VARCHAR(64) NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    field VARCHAR(128) NOT NULL,
+    old_value TEXT NULL,
+    new_value TEXT NULL,
+    version BIGINT NOT NULL,
+    actor VARCHAR(128) NOT NULL DEFAULT '',
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (id),
+    KEY idx_student_audit_student (student_id, id)
+) ENGINE = InnoDB DEFAULT CHARSET = utf8mb4;
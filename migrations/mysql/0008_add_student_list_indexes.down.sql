@@ -0,0 +1,3 @@
+DROP INDEX idx_grade_subject_score ON grade;
+DROP INDEX idx_student_name ON student;
+DROP INDEX idx_student_class_name ON student;
@@ -0,0 +1 @@
+ALTER TABLE student_outbox DROP COLUMN next_attempt_at;
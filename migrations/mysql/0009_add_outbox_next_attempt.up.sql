@@ -0,0 +1,2 @@
+-- 发件箱中应用失败的变更在这个时间之后才重试 每次失败后退避时间加倍
+ALTER TABLE student_outbox ADD COLUMN next_attempt_at DATETIME(6) NULL;
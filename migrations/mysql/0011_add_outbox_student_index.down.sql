@@ -0,0 +1 @@
+DROP INDEX idx_student_outbox_student ON student_outbox;
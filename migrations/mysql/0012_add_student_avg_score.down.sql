@@ -0,0 +1,2 @@
+DROP INDEX idx_student_avg_score ON student;
+ALTER TABLE student DROP COLUMN avg_score;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS student (
+    id TEXT NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL,
+    gender TEXT NOT NULL,
+    class TEXT NOT NULL,
+    expiration INTEGER NOT NULL DEFAULT 0
+);
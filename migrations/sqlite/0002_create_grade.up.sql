@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS grade (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subject TEXT NOT NULL,
+    score REAL NOT NULL,
+    student_id TEXT NOT NULL REFERENCES student (id) ON DELETE CASCADE,
+    UNIQUE (student_id, subject)
+);
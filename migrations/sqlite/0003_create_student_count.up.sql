@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS student_count (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    student_id TEXT NOT NULL UNIQUE REFERENCES student (id) ON DELETE CASCADE,
+    count INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_student_count_count ON student_count (count);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS student_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    student_id TEXT NOT NULL,
+    op TEXT NOT NULL,
+    payload TEXT,
+    origin TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_student_outbox_origin ON student_outbox (origin, id);
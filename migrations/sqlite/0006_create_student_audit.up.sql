@@ -0,0 +1,14 @@
+-- 只追加的审计表 每行是一次变更中一个字段的旧值和新值 学生删除后仍然保留
+CREATE TABLE IF NOT EXISTS student_audit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    student_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    field TEXT NOT NULL,
+    old_value TEXT NULL,
+    new_value TEXT NULL,
+    version INTEGER NOT NULL,
+    actor TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_student_audit_student ON student_audit (student_id, id);
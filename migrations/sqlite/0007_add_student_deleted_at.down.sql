@@ -0,0 +1,3 @@
+DELETE FROM student WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_student_deleted_at;
+ALTER TABLE student DROP COLUMN deleted_at;
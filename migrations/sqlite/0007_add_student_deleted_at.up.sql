@@ -0,0 +1,3 @@
+-- 软删除 删除的学生保留到超过保留期后由清理任务彻底删除
+ALTER TABLE student ADD COLUMN deleted_at DATETIME NULL;
+CREATE INDEX IF NOT EXISTS idx_student_deleted_at ON student (deleted_at);
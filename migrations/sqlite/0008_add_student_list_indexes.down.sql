@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_grade_subject_score;
+DROP INDEX IF EXISTS idx_student_name;
+DROP INDEX IF EXISTS idx_student_class_name;
@@ -0,0 +1,4 @@
+-- 列出学生时按班级和姓名过滤排序 按学科分数过滤排序
+CREATE INDEX IF NOT EXISTS idx_student_class_name ON student (class, name);
+CREATE INDEX IF NOT EXISTS idx_student_name ON student (name);
+CREATE INDEX IF NOT EXISTS idx_grade_subject_score ON grade (subject, score, student_id);
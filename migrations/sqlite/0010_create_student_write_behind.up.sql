@@ -0,0 +1,9 @@
+-- 异步写数据库时每个学生已经写入的最大日志序号 和学生数据在同一个事务中更新
+-- 提交后确认日志前崩溃时 重新写入的变更按序号跳过 不会重复添加学生或写入审计记录
+CREATE TABLE IF NOT EXISTS student_write_behind (
+    journal_id TEXT NOT NULL,
+    student_id TEXT NOT NULL,
+    seq INTEGER NOT NULL,
+    PRIMARY KEY (journal_id, student_id)
+);
+CREATE INDEX IF NOT EXISTS idx_student_write_behind_seq ON student_write_behind (journal_id, seq);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_student_outbox_student;
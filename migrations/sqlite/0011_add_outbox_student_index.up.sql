@@ -0,0 +1,2 @@
+-- 请求提交后只应用自己写入的变更 需要按实例和学号找到最早没有应用的变更
+CREATE INDEX IF NOT EXISTS idx_student_outbox_student ON student_outbox (origin, student_id, id);
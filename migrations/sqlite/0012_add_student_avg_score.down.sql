@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_student_avg_score;
+ALTER TABLE student DROP COLUMN avg_score;
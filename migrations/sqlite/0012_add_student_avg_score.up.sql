@@ -0,0 +1,4 @@
+-- 按平均分排序时不再每页聚合整张成绩表 写入成绩时同时更新平均分 没有成绩的学生平均分为0
+ALTER TABLE student ADD COLUMN avg_score REAL NOT NULL DEFAULT 0;
+UPDATE student SET avg_score = (SELECT COALESCE(AVG(g.score), 0) FROM grade g WHERE g.student_id = student.id);
+CREATE INDEX IF NOT EXISTS idx_student_avg_score ON student (avg_score, id);
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Student struct {
 	ID         string             `json:"id" validate:"required"`
 	Name       string             `json:"name" validate:"required"`
@@ -7,13 +9,31 @@ type Student struct {
 	Class      string             `json:"class" validate:"required"`
 	Grades     map[string]float64 `json:"grades"`
 	Expiration int64              `json:"expiration"`
+	// Version 每次修改加一 更新和删除时用来检查学生在读取之后有没有被其他请求修改
+	Version int64 `json:"version"`
+}
+
+// Copy 深拷贝学生 包括成绩 map 避免保存的学生被调用方修改
+func (s *Student) Copy() *Student {
+	copied := *s
+	if s.Grades != nil {
+		copied.Grades = make(map[string]float64, len(s.Grades))
+		for subject, score := range s.Grades {
+			copied.Grades[subject] = score
+		}
+	}
+	return &copied
 }
 
 type StudentDB struct {
-	ID     string `json:"id" validate:"required" gorm:"primaryKey"`
-	Name   string `json:"name" validate:"required"`
-	Gender string `json:"gender" validate:"required"`
-	Class  string `json:"class" validate:"required"`
+	ID         string `json:"id" validate:"required" gorm:"primaryKey"`
+	Name       string `json:"name" validate:"required"`
+	Gender     string `json:"gender" validate:"required"`
+	Class      string `json:"class" validate:"required"`
+	Expiration int64  `json:"expiration"`
+	Version    int64  `json:"version"`
+	// DeletedAt 软删除的时间 没有删除时为nil
+	DeletedAt *time.Time `json:"deleted_at"`
 }
 
 type Grade struct {
@@ -28,3 +48,38 @@ type StudentCount struct {
 	StudentId string `json:"student_id" validate:"required"`
 	Count     int32  `json:"count" validate:"required"`
 }
+
+// AuditContext 发起变更的人和请求 和变更一起写入审计表
+type AuditContext struct {
+	Actor     string `json:"actor"`
+	RequestID string `json:"request_id"`
+}
+
+// StudentAudit 审计表中的一行 一次变更中一个字段的旧值和新值 添加前和删除后的值为nil
+// 同一次变更的所有行有相同的 Version 也就是变更后的版本号 删除时是被删除的版本号
+type StudentAudit struct {
+	ID        uint64    `json:"id"`
+	StudentId string    `json:"student_id"`
+	Action    string    `json:"action"`
+	Field     string    `json:"field"`
+	OldValue  *string   `json:"old_value"`
+	NewValue  *string   `json:"new_value"`
+	Version   int64     `json:"version"`
+	Actor     string    `json:"actor"`
+	RequestId string    `json:"request_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OutboxEvent 发件箱中的一条学生变更 Origin 是写入该变更的实例 只由该实例的中继处理
+type OutboxEvent struct {
+	ID        uint64    `json:"id"`
+	StudentId string    `json:"student_id"`
+	Op        string    `json:"op"`
+	Payload   string    `json:"payload"`
+	Origin    string    `json:"origin"`
+	Attempts  int32     `json:"attempts"`
+	LastError string    `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+	// NextAttemptAt 应用失败后下次重试的时间 没有失败过时为nil
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+}
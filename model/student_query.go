@@ -0,0 +1,46 @@
+package model
+
+// 列出学生时的排序字段
+const (
+	SortById    = "id"
+	SortByName  = "name"
+	SortByScore = "score"
+	SortByAvg   = "avg"
+)
+
+// ScoreRange 某一学科的分数范围 Min 和 Max 为nil时不限制 都包含边界
+type ScoreRange struct {
+	Subject string
+	Min     *float64
+	Max     *float64
+}
+
+// StudentQuery 列出学生的过滤和排序条件 空字符串表示不过滤
+type StudentQuery struct {
+	Class      string
+	Gender     string
+	NamePrefix string
+	Scores     []ScoreRange
+	// SortBy 为 SortByScore 时按 SortSubject 的分数排序 只列出有这门成绩的学生
+	SortBy      string
+	SortSubject string
+	Desc        bool
+	Limit       int
+	// After 上一页最后一个学生的位置 为nil时从第一页开始
+	After *StudentCursor
+}
+
+// StudentCursor 分页的位置 按排序字段的值和学号确定 序列化后作为不透明的游标返回给客户端
+type StudentCursor struct {
+	// Sort 生成游标时的排序方式 换了排序方式的游标不能使用
+	Sort  string   `json:"s"`
+	ID    string   `json:"i"`
+	Name  string   `json:"n,omitempty"`
+	Score *float64 `json:"v,omitempty"`
+}
+
+// StudentListRow 列出学生时数据库返回的一行 SortScore 是按分数或平均分排序时的排序值
+type StudentListRow struct {
+	StudentDB
+	SortScore *float64
+}
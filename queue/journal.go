@@ -0,0 +1,292 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	journalFileName = "journal.log"
+	ackFileName     = "journal.ack"
+	idFileName      = "journal.id"
+)
+
+// compactSize 日志超过这个大小并且至少一半已经确认时 只保留没有确认的记录重写日志文件
+var compactSize int64 = 4 << 20
+
+// Record 日志中的一条记录 Seq 从1开始严格递增
+type Record struct {
+	Seq  uint64          `json:"seq"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Journal 基于文件的持久化队列 每条记录追加写入后立即刷盘
+// 消费者处理完记录后调用 Ack 记录进度 重启时 Open 返回所有未确认的记录
+type Journal struct {
+	mu      sync.Mutex
+	dir     string
+	id      string
+	file    *os.File
+	size    int64
+	nextSeq uint64
+	acked   uint64
+	// ends 日志文件中每条记录的序号和结尾位置 用于计算已确认的记录占用的长度
+	ends []recordEnd
+}
+
+type recordEnd struct {
+	seq uint64
+	end int64
+}
+
+// Open 打开目录下的日志 不存在时创建 返回日志和所有未确认的记录
+func Open(dir string) (*Journal, []Record, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("创建日志目录失败：%w", err)
+	}
+	id, err := readOrCreateID(filepath.Join(dir, idFileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	acked, err := readAck(filepath.Join(dir, ackFileName))
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, journalFileName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("打开日志文件失败：%w", err)
+	}
+	records, ends, size, lastSeq, err := readRecords(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	// 崩溃时最后一条记录可能只写了一半 截掉无法解析的部分
+	if err = file.Truncate(size); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("截断日志文件失败：%w", err)
+	}
+	if _, err = file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if lastSeq < acked {
+		lastSeq = acked
+	}
+	j := &Journal{
+		dir:     dir,
+		id:      id,
+		file:    file,
+		size:    size,
+		nextSeq: lastSeq + 1,
+		acked:   acked,
+		ends:    ends,
+	}
+	var pending []Record
+	for _, record := range records {
+		if record.Seq > acked {
+			pending = append(pending, record)
+		}
+	}
+	return j, pending, nil
+}
+
+// readRecords 读取日志中的记录和每条记录的结尾位置 返回可以正常解析的部分的长度
+func readRecords(file *os.File) ([]Record, []recordEnd, int64, uint64, error) {
+	var records []Record
+	var ends []recordEnd
+	var size int64
+	var lastSeq uint64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return records, ends, size, lastSeq, nil
+		}
+		if err != nil {
+			return nil, nil, 0, 0, fmt.Errorf("读取日志文件失败：%w", err)
+		}
+		var record Record
+		if json.Unmarshal(bytes.TrimSpace(line), &record) != nil || record.Seq <= lastSeq {
+			return records, ends, size, lastSeq, nil
+		}
+		records = append(records, record)
+		size += int64(len(line))
+		ends = append(ends, recordEnd{seq: record.Seq, end: size})
+		lastSeq = record.Seq
+	}
+}
+
+// readOrCreateID 读取日志的标识 第一次打开时生成 日志目录被删除后重新生成 序号从1开始时不会和之前的日志混淆
+func readOrCreateID(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("读取日志标识失败：%w", err)
+	}
+	buf := make([]byte, 16)
+	if _, err = rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成日志标识失败：%w", err)
+	}
+	id := hex.EncodeToString(buf)
+	tmp := path + ".tmp"
+	if err = writeFileSync(tmp, []byte(id)); err != nil {
+		return "", fmt.Errorf("写入日志标识失败：%w", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("写入日志标识失败：%w", err)
+	}
+	return id, nil
+}
+
+func readAck(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("读取确认文件失败：%w", err)
+	}
+	acked, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("确认文件格式错误：%w", err)
+	}
+	return acked, nil
+}
+
+// Append 追加一条记录并刷盘 返回记录的序号
+func (j *Journal) Append(data []byte) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	record := Record{Seq: j.nextSeq, Data: data}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+	line = append(line, '\n')
+	if _, err = j.file.Write(line); err != nil {
+		return 0, fmt.Errorf("写入日志失败：%w", err)
+	}
+	if err = j.file.Sync(); err != nil {
+		return 0, fmt.Errorf("日志刷盘失败：%w", err)
+	}
+	j.size += int64(len(line))
+	j.ends = append(j.ends, recordEnd{seq: record.Seq, end: j.size})
+	j.nextSeq++
+	return record.Seq, nil
+}
+
+// ID 返回日志的标识 同一个目录下的日志重新打开后标识不变
+func (j *Journal) ID() string {
+	return j.id
+}
+
+// Acked 返回已经确认的最大序号
+func (j *Journal) Acked() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.acked
+}
+
+// Ack 确认序号及之前的所有记录已经处理完成
+func (j *Journal) Ack(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if seq <= j.acked {
+		return nil
+	}
+	// 先写临时文件再重命名 保证确认文件不会只写一半
+	path := filepath.Join(j.dir, ackFileName)
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, []byte(strconv.FormatUint(seq, 10))); err != nil {
+		return fmt.Errorf("写入确认文件失败：%w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("写入确认文件失败：%w", err)
+	}
+	j.acked = seq
+	return j.compact()
+}
+
+// compact 日志过大并且至少一半已经确认时 把没有确认的记录写入临时文件再替换日志文件
+// 消费者一直落后时日志也不会无限增长 替换前崩溃时原来的日志仍然完整
+func (j *Journal) compact() error {
+	if j.size <= compactSize {
+		return nil
+	}
+	n := sort.Search(len(j.ends), func(i int) bool { return j.ends[i].seq > j.acked })
+	if n == 0 {
+		return nil
+	}
+	ackedSize := j.ends[n-1].end
+	if ackedSize < j.size/2 {
+		return nil
+	}
+	path := filepath.Join(j.dir, journalFileName)
+	tmp := path + ".tmp"
+	tail := io.NewSectionReader(j.file, ackedSize, j.size-ackedSize)
+	if err := copyFileSync(tmp, tail); err != nil {
+		return fmt.Errorf("压缩日志文件失败：%w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("压缩日志文件失败：%w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("重新打开日志文件失败：%w", err)
+	}
+	if _, err = file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	ends := make([]recordEnd, 0, len(j.ends)-n)
+	for _, e := range j.ends[n:] {
+		ends = append(ends, recordEnd{seq: e.seq, end: e.end - ackedSize})
+	}
+	j.ends = ends
+	j.size -= ackedSize
+	return nil
+}
+
+// copyFileSync 把 reader 中的内容写入文件并刷盘
+func copyFileSync(path string, reader io.Reader) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, reader); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeFileSync(path string, data []byte) error {
+	return copyFileSync(path, bytes.NewReader(data))
+}
+
+// Close 关闭日志文件
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
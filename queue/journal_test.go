@@ -0,0 +1,107 @@
+package queue
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func appendRecords(t *testing.T, j *Journal, count int) []uint64 {
+	t.Helper()
+	seqs := make([]uint64, 0, count)
+	for i := 0; i < count; i++ {
+		seq, err := j.Append([]byte(fmt.Sprintf(`{"n":%d,"pad":"%0100d"}`, i, i)))
+		if err != nil {
+			t.Fatalf("追加记录失败：%v", err)
+		}
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func journalSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, journalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// TestJournalCompactsUnderLoad 一直有没有确认的记录时 日志也会被压缩 重新打开后只返回没有确认的记录
+func TestJournalCompactsUnderLoad(t *testing.T) {
+	old := compactSize
+	compactSize = 16 << 10
+	defer func() { compactSize = old }()
+
+	dir := t.TempDir()
+	j, pending, err := Open(dir)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("Open = %v, %v", pending, err)
+	}
+	// 消费者始终落后10条记录
+	var seqs []uint64
+	for round := 0; round < 20; round++ {
+		seqs = append(seqs, appendRecords(t, j, 50)...)
+		if err = j.Ack(seqs[len(seqs)-11]); err != nil {
+			t.Fatalf("确认记录失败：%v", err)
+		}
+	}
+	if size := journalSize(t, dir); size > 2*compactSize {
+		t.Fatalf("日志文件大小 = %d，没有被压缩", size)
+	}
+	// 压缩后继续追加
+	seqs = append(seqs, appendRecords(t, j, 5)...)
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, pending, err = Open(dir)
+	if err != nil {
+		t.Fatalf("重新打开日志失败：%v", err)
+	}
+	defer j.Close()
+	want := seqs[len(seqs)-15:]
+	if len(pending) != len(want) {
+		t.Fatalf("重新打开后有%d条没有确认的记录，期望%d条", len(pending), len(want))
+	}
+	for i, record := range pending {
+		if record.Seq != want[i] {
+			t.Fatalf("第%d条记录的序号 = %d，期望 %d", i, record.Seq, want[i])
+		}
+	}
+	seq, err := j.Append([]byte(`{}`))
+	if err != nil || seq != seqs[len(seqs)-1]+1 {
+		t.Fatalf("重新打开后追加的序号 = %d, %v，期望 %d", seq, err, seqs[len(seqs)-1]+1)
+	}
+}
+
+// TestJournalTruncatesPartialRecord 最后一条只写了一半的记录被截掉
+func TestJournalTruncatesPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	j, _, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, j, 3)
+	j.Close()
+	file, err := os.OpenFile(filepath.Join(dir, journalFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"seq":4,"da`)
+	file.Close()
+
+	j, pending, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if len(pending) != 3 {
+		t.Fatalf("有%d条记录，期望3条", len(pending))
+	}
+	if seq, _ := j.Append([]byte(`{}`)); seq != 4 {
+		t.Fatalf("追加的序号 = %d，期望 4", seq)
+	}
+}
@@ -14,6 +14,8 @@ type StudentCommand struct {
 	Operation string         `json:"operation"`
 	Student   *model.Student `json:"student,omitempty"`
 	Id        string         `json:"id"`
+	// Audit 发起变更的人和请求 写入审计记录
+	Audit model.AuditContext `json:"audit"`
 }
 
 // StudentFSM 实现 raft.FSM 接口
@@ -36,11 +38,17 @@ func (fsm *StudentFSM) Apply(log *raft.Log) interface{} {
 	}
 	switch cmd.Operation {
 	case "add":
-		return fsm.service.AddStudentInternal(cmd.Student)
+		return fsm.service.AddStudentInternal(cmd.Student, cmd.Audit)
 	case "update":
-		return fsm.service.UpdateStudentInternal(cmd.Student)
+		return fsm.service.UpdateStudentInternal(cmd.Student, cmd.Audit)
 	case "delete":
-		return fsm.service.DeleteStudentInternal(cmd.Id)
+		var version int64
+		if cmd.Student != nil {
+			version = cmd.Student.Version
+		}
+		return fsm.service.DeleteStudentInternal(cmd.Id, version, cmd.Audit)
+	case "restore":
+		return fsm.service.RestoreStudentInternal(cmd.Id, cmd.Audit)
 	case "reloadCacheData":
 		fsm.service.ReloadCacheDataInternal()
 		return nil
@@ -52,14 +60,41 @@ func (fsm *StudentFSM) Apply(log *raft.Log) interface{} {
 	}
 }
 
-// Snapshot 实现快照功能 还没写
+// Snapshot 实现快照功能 学生数据保存在数据库中 快照只保存服务的内存状态(如布隆过滤器)
 func (fsm *StudentFSM) Snapshot() (raft.FSMSnapshot, error) {
-	return nil, nil
+	data, err := fsm.service.SnapshotState()
+	if err != nil {
+		return nil, err
+	}
+	return &studentSnapshot{data: data}, nil
 }
 
-// Restore 恢复状态机到快照状态 还没写
+// Restore 恢复状态机到快照状态
 func (fsm *StudentFSM) Restore(snapshot io.ReadCloser) error {
 	defer snapshot.Close()
-	fmt.Printf("Restoring snapshot data\n")
-	return nil
+	data, err := io.ReadAll(snapshot)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return fsm.service.RestoreState(data)
+}
+
+// studentSnapshot 实现 raft.FSMSnapshot 接口
+type studentSnapshot struct {
+	data []byte
+}
+
+// Persist 把快照数据写入 sink
+func (s *studentSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
 }
+
+// Release 快照数据在内存中 不需要释放资源
+func (s *studentSnapshot) Release() {}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"memoryDataBase/cache"
+	"memoryDataBase/config"
+	"memoryDataBase/dao"
+	"memoryDataBase/database"
+	"memoryDataBase/service"
+	"os"
+)
+
+// runReconcile 命令行对账 只检查数据库和 Redis 内存是每个服务进程独有的 需要通过 POST /admin/reconcile 检查
+// 命令行看不到服务进程异步写数据库的队列 启用异步写数据库时只能报告 修复需要通过 POST /admin/reconcile
+func runReconcile(name string, args []string) int {
+	cfg := config.Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	var options service.ReconcileOptions
+	fs.BoolVar(&options.Repair, "repair", false, "以数据库为准修复 Redis 中不一致的学生")
+	fs.BoolVar(&options.DryRun, "dry-run", false, "只报告需要修复的学生 不做任何修改")
+	fs.IntVar(&options.Rate, "rate", 500, "每秒最多检查的学生数 0表示不限制")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Printf("解析启动参数失败：%v", err)
+		return 2
+	}
+	if cfg.WriteBehind && options.Repair && !options.DryRun {
+		log.Printf("启用异步写数据库时数据库中的学生可能比缓存旧，请使用 POST /admin/reconcile 修复")
+		return 2
+	}
+	if cfg.CacheBackend != config.CacheBackendRedis {
+		log.Printf("进程内缓存只存在于服务进程中，请使用 POST /admin/reconcile 对账")
+		return 2
+	}
+
+	if err := database.InitDB(cfg.DBDriver, cfg.DatabaseDSN()); err != nil {
+		log.Printf("连接数据库失败：%v", err)
+		return 1
+	}
+	cache.InitRedis(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+
+	reconciler := service.NewReconcileService(
+		service.NewStudentMysqlService(dao.NewStudentMysqlDao(database.DB)),
+		service.NewStudentCacheService(dao.NewStudentCacheDao(cache.RedisClient, studentCacheOptions(cfg))),
+		nil,
+		nil,
+	)
+	report, err := reconciler.Run(options)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if encodeErr := encoder.Encode(report); encodeErr != nil {
+		log.Printf("输出对账报告失败：%v", encodeErr)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
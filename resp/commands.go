@@ -0,0 +1,489 @@
+package resp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// command 描述一条命令 arity 为正数表示参数个数固定(包含命令名) 为负数表示至少需要的参数个数
+// flags 标记会修改数据的命令 受保护的命名空间只能通过服务层修改
+type command struct {
+	arity   int
+	flags   int
+	handler func(sess *session, args []string)
+}
+
+const (
+	// flagWrite 修改当前命名空间
+	flagWrite = 1 << iota
+	// flagWriteAll 修改所有命名空间
+	flagWriteAll
+)
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":     {-1, 0, cmdPing},
+		"ECHO":     {2, 0, cmdEcho},
+		"HELLO":    {-1, 0, cmdHello},
+		"AUTH":     {-2, 0, cmdAuth},
+		"QUIT":     {-1, 0, cmdQuit},
+		"SELECT":   {2, 0, cmdSelect},
+		"CLIENT":   {-2, 0, cmdClient},
+		"COMMAND":  {-1, 0, cmdCommand},
+		"GET":      {2, 0, cmdGet},
+		"SET":      {-3, flagWrite, cmdSet},
+		"DEL":      {-2, flagWrite, cmdDel},
+		"UNLINK":   {-2, flagWrite, cmdDel},
+		"EXISTS":   {-2, 0, cmdExists},
+		"EXPIRE":   {3, flagWrite, cmdExpire},
+		"PEXPIRE":  {3, flagWrite, cmdPExpire},
+		"TTL":      {2, 0, cmdTTL},
+		"PTTL":     {2, 0, cmdPTTL},
+		"PERSIST":  {2, flagWrite, cmdPersist},
+		"KEYS":     {2, 0, cmdKeys},
+		"SCAN":     {-2, 0, cmdScan},
+		"DBSIZE":   {1, 0, cmdDBSize},
+		"FLUSHDB":  {-1, flagWrite, cmdFlushDB},
+		"FLUSHALL": {-1, flagWriteAll, cmdFlushAll},
+		"INFO":     {-1, 0, cmdInfo},
+	}
+}
+
+func cmdPing(sess *session, args []string) {
+	switch len(args) {
+	case 0:
+		sess.writer.WriteSimpleString("PONG")
+	case 1:
+		sess.writer.WriteBulkString(args[0])
+	default:
+		sess.writer.WriteError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func cmdEcho(sess *session, args []string) {
+	sess.writer.WriteBulkString(args[0])
+}
+
+// cmdHello 协商协议版本 HELLO [protover [AUTH username password] [SETNAME clientname]]
+func cmdHello(sess *session, args []string) {
+	proto := sess.writer.Protocol()
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			sess.writer.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if version != 2 && version != 3 {
+			sess.writer.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = version
+		for i := 1; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				if i+2 >= len(args) {
+					sess.writer.WriteError("ERR Syntax error in HELLO option 'AUTH'")
+					return
+				}
+				if !authenticate(sess, args[i+1], args[i+2]) {
+					return
+				}
+				i += 2
+			case "SETNAME":
+				if i+1 < len(args) {
+					sess.name = args[i+1]
+				}
+				i++
+			default:
+				sess.writer.WriteError("ERR Syntax error in HELLO option '" + args[i] + "'")
+				return
+			}
+		}
+	}
+	if !sess.authenticated {
+		sess.writer.WriteError("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+		return
+	}
+	sess.writer.SetProtocol(proto)
+	sess.writer.WriteMapHeader(7)
+	sess.writer.WriteBulkString("server")
+	sess.writer.WriteBulkString("memorydb")
+	sess.writer.WriteBulkString("version")
+	sess.writer.WriteBulkString("1.0.0")
+	sess.writer.WriteBulkString("proto")
+	sess.writer.WriteInteger(int64(proto))
+	sess.writer.WriteBulkString("id")
+	sess.writer.WriteInteger(1)
+	sess.writer.WriteBulkString("mode")
+	sess.writer.WriteBulkString("standalone")
+	sess.writer.WriteBulkString("role")
+	sess.writer.WriteBulkString("master")
+	sess.writer.WriteBulkString("modules")
+	sess.writer.WriteArrayHeader(0)
+}
+
+// cmdAuth AUTH [username] password 只支持 default 用户
+func cmdAuth(sess *session, args []string) {
+	if len(args) > 2 {
+		sess.writer.WriteError("ERR syntax error")
+		return
+	}
+	username, password := "default", args[0]
+	if len(args) == 2 {
+		username, password = args[0], args[1]
+	}
+	if authenticate(sess, username, password) {
+		sess.writer.WriteSimpleString("OK")
+	}
+}
+
+// authenticate 验证用户名和密码 失败时已经写入错误回复
+func authenticate(sess *session, username, password string) bool {
+	if sess.server.password == "" {
+		sess.writer.WriteError("ERR AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?")
+		return false
+	}
+	if !sess.server.checkPassword(username, password) {
+		sess.writer.WriteError("WRONGPASS invalid username-password pair or user is disabled.")
+		return false
+	}
+	sess.authenticated = true
+	return true
+}
+
+func cmdQuit(sess *session, args []string) {
+	sess.quit = true
+	sess.writer.WriteSimpleString("OK")
+}
+
+// cmdSelect 切换到已存在的命名空间 0 号库是服务器的默认命名空间
+func cmdSelect(sess *session, args []string) {
+	name := args[0]
+	if name == "0" {
+		name = sess.server.defaultNamespace
+	}
+	db, exists := sess.server.memoryDB.Lookup(name)
+	if !exists {
+		sess.writer.WriteError("ERR DB index is out of range")
+		return
+	}
+	sess.db = db
+	sess.writer.WriteSimpleString("OK")
+}
+
+// cmdClient 只实现客户端连接时常用的子命令
+func cmdClient(sess *session, args []string) {
+	switch strings.ToUpper(args[0]) {
+	case "SETNAME":
+		if len(args) != 2 {
+			sess.writer.WriteError("ERR wrong number of arguments for 'client|setname' command")
+			return
+		}
+		sess.name = args[1]
+		sess.writer.WriteSimpleString("OK")
+	case "GETNAME":
+		if sess.name == "" {
+			sess.writer.WriteNull()
+			return
+		}
+		sess.writer.WriteBulkString(sess.name)
+	case "SETINFO":
+		sess.writer.WriteSimpleString("OK")
+	default:
+		sess.writer.WriteError("ERR unknown subcommand '" + args[0] + "'")
+	}
+}
+
+// cmdCommand redis-cli 启动时会查询命令文档 返回空数组即可
+func cmdCommand(sess *session, args []string) {
+	if len(args) > 0 && strings.ToUpper(args[0]) == "COUNT" {
+		sess.writer.WriteInteger(int64(len(commands)))
+		return
+	}
+	sess.writer.WriteArrayHeader(0)
+}
+
+// cmdGet 使用 Peek 读取 不延长滑动过期时间 也不计入命中和未命中次数 查看数据不会改变内存数据库的行为
+func cmdGet(sess *session, args []string) {
+	value, exists := sess.db.Peek(args[0])
+	if !exists {
+		sess.writer.WriteNull()
+		return
+	}
+	text, err := encodeValue(value)
+	if err != nil {
+		sess.writer.WriteError("ERR failed to encode value: " + err.Error())
+		return
+	}
+	sess.writer.WriteBulkString(text)
+}
+
+// cmdSet SET key value [EX seconds | PX milliseconds]
+func cmdSet(sess *session, args []string) {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if (option != "EX" && option != "PX") || i+1 >= len(args) || ttl != 0 {
+			sess.writer.WriteError("ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || n <= 0 {
+			sess.writer.WriteError("ERR invalid expire time in 'set' command")
+			return
+		}
+		if option == "EX" {
+			ttl = time.Duration(n) * time.Second
+		} else {
+			ttl = time.Duration(n) * time.Millisecond
+		}
+		i++
+	}
+	sess.db.SetWithTTL(key, value, ttl)
+	sess.writer.WriteSimpleString("OK")
+}
+
+func cmdDel(sess *session, args []string) {
+	var deleted int64
+	for _, key := range args {
+		if sess.db.Exists(key) {
+			sess.db.Delete(key)
+			deleted++
+		}
+	}
+	sess.writer.WriteInteger(deleted)
+}
+
+func cmdExists(sess *session, args []string) {
+	var count int64
+	for _, key := range args {
+		if sess.db.Exists(key) {
+			count++
+		}
+	}
+	sess.writer.WriteInteger(count)
+}
+
+func cmdExpire(sess *session, args []string) {
+	expire(sess, args, time.Second)
+}
+
+func cmdPExpire(sess *session, args []string) {
+	expire(sess, args, time.Millisecond)
+}
+
+func expire(sess *session, args []string, unit time.Duration) {
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		sess.writer.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+	if sess.db.Expire(args[0], time.Duration(n)*unit) {
+		sess.writer.WriteInteger(1)
+		return
+	}
+	sess.writer.WriteInteger(0)
+}
+
+func cmdTTL(sess *session, args []string) {
+	ttl(sess, args, time.Second)
+}
+
+func cmdPTTL(sess *session, args []string) {
+	ttl(sess, args, time.Millisecond)
+}
+
+// ttl 键不存在返回-2 没有过期时间返回-1 否则返回向上取整后的剩余时间
+func ttl(sess *session, args []string, unit time.Duration) {
+	remaining, exists := sess.db.TTL(args[0])
+	switch {
+	case !exists:
+		sess.writer.WriteInteger(-2)
+	case remaining < 0:
+		sess.writer.WriteInteger(-1)
+	default:
+		sess.writer.WriteInteger(int64((remaining + unit - 1) / unit))
+	}
+}
+
+func cmdPersist(sess *session, args []string) {
+	if sess.db.Persist(args[0]) {
+		sess.writer.WriteInteger(1)
+		return
+	}
+	sess.writer.WriteInteger(0)
+}
+
+func cmdKeys(sess *session, args []string) {
+	sess.writer.WriteStringArray(sess.db.Keys(args[0]))
+}
+
+// cmdScan SCAN cursor [MATCH pattern] [COUNT count] 使用内存数据库的增量遍历
+func cmdScan(sess *session, args []string) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		sess.writer.WriteError("ERR invalid cursor")
+		return
+	}
+	match, count, ok := parseScanOptions(sess, args[1:])
+	if !ok {
+		return
+	}
+	keys, next := sess.db.Scan(cursor, match, count)
+	sess.writer.WriteArrayHeader(2)
+	sess.writer.WriteBulkString(strconv.FormatUint(next, 10))
+	sess.writer.WriteStringArray(keys)
+}
+
+// parseScanOptions 解析 SCAN 的 MATCH 和 COUNT 选项 出错时已经写入错误回复
+func parseScanOptions(sess *session, args []string) (string, int, bool) {
+	match, count := "*", 10
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			sess.writer.WriteError("ERR syntax error")
+			return "", 0, false
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			match = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				sess.writer.WriteError("ERR value is out of range, must be positive")
+				return "", 0, false
+			}
+			count = n
+		default:
+			sess.writer.WriteError("ERR syntax error")
+			return "", 0, false
+		}
+	}
+	return match, count, true
+}
+
+func cmdDBSize(sess *session, args []string) {
+	sess.writer.WriteInteger(int64(sess.db.Count()))
+}
+
+// cmdFlushDB 清空当前命名空间 ASYNC 和 SYNC 选项被忽略
+func cmdFlushDB(sess *session, args []string) {
+	sess.db.Flush()
+	sess.writer.WriteSimpleString("OK")
+}
+
+// cmdFlushAll 清空所有命名空间
+func cmdFlushAll(sess *session, args []string) {
+	sess.server.memoryDB.FlushAll()
+	sess.writer.WriteSimpleString("OK")
+}
+
+// cmdInfo INFO [section] 返回服务器和键空间的信息
+func cmdInfo(sess *session, args []string) {
+	section := "all"
+	if len(args) > 0 {
+		section = strings.ToLower(args[0])
+	}
+	var sb strings.Builder
+	if section == "all" || section == "default" || section == "server" {
+		hostname, _ := os.Hostname()
+		sb.WriteString("# Server\r\n")
+		sb.WriteString("server_name:memorydb\r\n")
+		sb.WriteString(fmt.Sprintf("go_version:%s\r\n", runtime.Version()))
+		sb.WriteString(fmt.Sprintf("process_id:%d\r\n", os.Getpid()))
+		sb.WriteString(fmt.Sprintf("hostname:%s\r\n", hostname))
+		sb.WriteString(fmt.Sprintf("tcp_port:%s\r\n", portOf(sess.server)))
+		sb.WriteString(fmt.Sprintf("uptime_in_seconds:%d\r\n", int64(time.Since(sess.server.startTime).Seconds())))
+		sb.WriteString("\r\n")
+	}
+	if section == "all" || section == "default" || section == "clients" {
+		sb.WriteString("# Clients\r\n")
+		sb.WriteString(fmt.Sprintf("connected_clients:%d\r\n", atomic.LoadInt64(&sess.server.connCount)))
+		sb.WriteString("\r\n")
+	}
+	if section == "all" || section == "default" || section == "memory" {
+		info := sess.server.memoryDB.Stats()
+		sb.WriteString("# Memory\r\n")
+		sb.WriteString(fmt.Sprintf("used_memory:%d\r\n", info.TotalUsedBytes))
+		sb.WriteString(fmt.Sprintf("used_memory_human:%s\r\n", humanBytes(info.TotalUsedBytes)))
+		sb.WriteString("\r\n")
+	}
+	if section == "all" || section == "default" || section == "stats" {
+		stats := sess.db.Stats()
+		sb.WriteString("# Stats\r\n")
+		sb.WriteString(fmt.Sprintf("keyspace_hits:%d\r\n", stats.Hits))
+		sb.WriteString(fmt.Sprintf("keyspace_misses:%d\r\n", stats.Misses))
+		sb.WriteString(fmt.Sprintf("expired_keys:%d\r\n", stats.ExpiredKeys))
+		sb.WriteString(fmt.Sprintf("evicted_keys:%d\r\n", stats.EvictedKeys))
+		sb.WriteString(fmt.Sprintf("lock_acquisitions:%d\r\n", stats.LockAcquisitions))
+		sb.WriteString(fmt.Sprintf("lock_wait_total_us:%d\r\n", stats.LockWaitTotalNs/int64(time.Microsecond)))
+		sb.WriteString("\r\n")
+	}
+	if section == "all" || section == "default" || section == "keyspace" {
+		sb.WriteString("# Keyspace\r\n")
+		for _, name := range sess.server.memoryDB.Names() {
+			db, exists := sess.server.memoryDB.Lookup(name)
+			if !exists {
+				continue
+			}
+			if stats := db.Stats(); stats.Keys > 0 {
+				sb.WriteString(fmt.Sprintf("%s:keys=%d,expires=%d,used_memory=%d\r\n", name, stats.Keys, stats.Expires, stats.UsedBytes))
+			}
+		}
+	}
+	sess.writer.WriteVerbatim("txt", sb.String())
+}
+
+func portOf(s *Server) string {
+	addr := s.Addr()
+	if addr == nil {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// humanBytes 把字节数转换为便于阅读的格式
+func humanBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2fK", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// encodeValue 把内存数据库中的值编码为字符串 非字符串的值编码为 json
+func encodeValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
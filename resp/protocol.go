@@ -0,0 +1,237 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 协议限制 防止恶意客户端发送超大的请求 没有验证的连接使用和 Redis 相同的更小的限制
+const (
+	maxArrayLength = 1024 * 1024
+	maxBulkLength  = 512 * 1024 * 1024
+	maxInlineSize  = 64 * 1024
+
+	maxUnauthArrayLength = 10
+	maxUnauthBulkLength  = 16 * 1024
+
+	// preallocArgs 预先分配的参数个数 更多的参数随着读取增长
+	preallocArgs = 1024
+)
+
+var errProtocol = errors.New("ERR Protocol error")
+
+// Reader 从连接中读取 RESP 请求
+type Reader struct {
+	rd            *bufio.Reader
+	authenticated bool
+}
+
+// NewReader 创建一个新的 RESP 请求读取器 默认按已经验证的连接限制请求大小
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{rd: bufio.NewReader(rd), authenticated: true}
+}
+
+// SetAuthenticated 设置连接是否已经验证 没有验证时数组最多10个元素 批量字符串最多16KB
+func (r *Reader) SetAuthenticated(authenticated bool) {
+	r.authenticated = authenticated
+}
+
+// ReadCommand 读取一条命令 支持多条批量字符串组成的数组和 telnet 风格的内联命令
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			// 忽略空行
+			continue
+		}
+		if line[0] != '*' {
+			args := strings.Fields(line)
+			if len(args) == 0 {
+				continue
+			}
+			return args, nil
+		}
+		maxLength := maxArrayLength
+		if !r.authenticated {
+			maxLength = maxUnauthArrayLength
+		}
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > maxLength {
+			return nil, errProtocol
+		}
+		if n <= 0 {
+			continue
+		}
+		args := make([]string, 0, min(n, preallocArgs))
+		for i := 0; i < n; i++ {
+			arg, err := r.readBulk()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+}
+
+// readBulk 读取一个批量字符串
+func (r *Reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", errProtocol
+	}
+	maxLength := maxBulkLength
+	if !r.authenticated {
+		maxLength = maxUnauthBulkLength
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxLength {
+		return "", errProtocol
+	}
+	// 按实际收到的数据增长缓冲区 声明很大的长度但不发送数据不会分配内存
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, r.rd, int64(n)+2); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	data := buf.Bytes()
+	if data[n] != '\r' || data[n+1] != '\n' {
+		return "", errProtocol
+	}
+	return string(data[:n]), nil
+}
+
+// readLine 读取一行并去掉行尾的 \r\n 一行超过 maxInlineSize 时在读完之前返回协议错误
+func (r *Reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.rd.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInlineSize {
+			return "", errProtocol
+		}
+		line = append(line, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+// Writer 向连接写入 RESP 回复 根据协议版本选择 RESP2 或 RESP3 的编码
+type Writer struct {
+	wr    *bufio.Writer
+	proto int
+}
+
+// NewWriter 创建一个新的 RESP 回复写入器 默认使用 RESP2
+func NewWriter(wr io.Writer) *Writer {
+	return &Writer{wr: bufio.NewWriter(wr), proto: 2}
+}
+
+// SetProtocol 切换协议版本
+func (w *Writer) SetProtocol(proto int) {
+	w.proto = proto
+}
+
+// Protocol 返回当前的协议版本
+func (w *Writer) Protocol() int {
+	return w.proto
+}
+
+// Flush 把缓冲区中的回复写入连接
+func (w *Writer) Flush() error {
+	return w.wr.Flush()
+}
+
+// WriteSimpleString 写入简单字符串
+func (w *Writer) WriteSimpleString(s string) {
+	w.wr.WriteString("+" + s + "\r\n")
+}
+
+// WriteError 写入错误 没有错误码时补上 ERR
+// 第一个单词全是大写字母时就是错误码 例如 NOAUTH WRONGPASS 客户端根据错误码区分错误的类型
+func (w *Writer) WriteError(msg string) {
+	if !hasErrorCode(msg) {
+		msg = "ERR " + msg
+	}
+	w.wr.WriteString("-" + strings.ReplaceAll(msg, "\r\n", " ") + "\r\n")
+}
+
+func hasErrorCode(msg string) bool {
+	code, _, found := strings.Cut(msg, " ")
+	if !found || code == "" {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] < 'A' || code[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteInteger 写入整数
+func (w *Writer) WriteInteger(n int64) {
+	w.wr.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// WriteBulkString 写入批量字符串
+func (w *Writer) WriteBulkString(s string) {
+	w.wr.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+// WriteNull 写入空值 RESP2 使用空批量字符串 RESP3 使用专门的空类型
+func (w *Writer) WriteNull() {
+	if w.proto >= 3 {
+		w.wr.WriteString("_\r\n")
+		return
+	}
+	w.wr.WriteString("$-1\r\n")
+}
+
+// WriteArrayHeader 写入数组头 后面需要紧跟 n 个元素
+func (w *Writer) WriteArrayHeader(n int) {
+	w.wr.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// WriteStringArray 写入由批量字符串组成的数组
+func (w *Writer) WriteStringArray(items []string) {
+	w.WriteArrayHeader(len(items))
+	for _, item := range items {
+		w.WriteBulkString(item)
+	}
+}
+
+// WriteMapHeader 写入映射头 RESP2 没有映射类型 使用长度翻倍的数组代替
+func (w *Writer) WriteMapHeader(n int) {
+	if w.proto >= 3 {
+		w.wr.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.WriteArrayHeader(n * 2)
+}
+
+// WriteVerbatim 写入原样文本 RESP2 下退化为批量字符串
+func (w *Writer) WriteVerbatim(format, s string) {
+	if w.proto >= 3 {
+		w.wr.WriteString(fmt.Sprintf("=%d\r\n%s:%s\r\n", len(s)+4, format, s))
+		return
+	}
+	w.WriteBulkString(s)
+}
@@ -0,0 +1,89 @@
+package resp
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReaderCommands(t *testing.T) {
+	reader := NewReader(strings.NewReader("*2\r\n$3\r\nGET\r\n$1\r\na\r\n\r\nPING hello\r\n"))
+	args, err := reader.ReadCommand()
+	if err != nil || strings.Join(args, " ") != "GET a" {
+		t.Fatalf("读取数组命令 = %q, %v", args, err)
+	}
+	args, err = reader.ReadCommand()
+	if err != nil || strings.Join(args, " ") != "PING hello" {
+		t.Fatalf("读取内联命令 = %q, %v", args, err)
+	}
+	if _, err = reader.ReadCommand(); !errors.Is(err, io.EOF) {
+		t.Fatalf("读完之后的错误 = %v，期望 EOF", err)
+	}
+}
+
+// TestReaderRejectsOversizedInput 超过限制的请求在分配内存之前返回协议错误 没有验证的连接限制更小
+func TestReaderRejectsOversizedInput(t *testing.T) {
+	bulk := func(n int) string {
+		return "*1\r\n$" + strconv.Itoa(n) + "\r\n" + strings.Repeat("x", n) + "\r\n"
+	}
+	for _, tc := range []struct {
+		name          string
+		input         string
+		authenticated bool
+		wantErr       bool
+	}{
+		{"内联命令过长", strings.Repeat("x", maxInlineSize+1) + "\r\n", true, true},
+		{"没有换行的长行", strings.Repeat("x", maxInlineSize*4), true, true},
+		{"数组过长", "*" + strconv.Itoa(maxArrayLength+1) + "\r\n", true, true},
+		{"批量字符串过长", "*1\r\n$" + strconv.Itoa(maxBulkLength+1) + "\r\n", true, true},
+		{"未验证时数组过长", "*" + strconv.Itoa(maxUnauthArrayLength+1) + "\r\n", false, true},
+		{"未验证时批量字符串过长", bulk(maxUnauthBulkLength + 1), false, true},
+		{"未验证时最大的批量字符串", bulk(maxUnauthBulkLength), false, false},
+		{"验证后的批量字符串", bulk(maxUnauthBulkLength + 1), true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := NewReader(strings.NewReader(tc.input))
+			reader.SetAuthenticated(tc.authenticated)
+			_, err := reader.ReadCommand()
+			if tc.wantErr && !errors.Is(err, errProtocol) {
+				t.Fatalf("错误 = %v，期望协议错误", err)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("读取失败：%v", err)
+			}
+		})
+	}
+}
+
+// TestReaderTruncatedBulk 声明的长度大于实际发送的数据时返回错误 不会按声明的长度分配内存
+func TestReaderTruncatedBulk(t *testing.T) {
+	reader := NewReader(strings.NewReader("*1\r\n$100000000\r\nabc"))
+	if _, err := reader.ReadCommand(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("错误 = %v，期望 ErrUnexpectedEOF", err)
+	}
+}
+
+// TestWriteErrorCode 已经有错误码的错误原样写入 其他错误补上 ERR
+func TestWriteErrorCode(t *testing.T) {
+	for msg, want := range map[string]string{
+		"NOAUTH Authentication required.": "-NOAUTH Authentication required.\r\n",
+		"WRONGPASS invalid password":      "-WRONGPASS invalid password\r\n",
+		"NOPERM no permission":            "-NOPERM no permission\r\n",
+		"WRONGTYPE wrong kind":            "-WRONGTYPE wrong kind\r\n",
+		"ERR unknown command":             "-ERR unknown command\r\n",
+		"找不到键":                            "-ERR 找不到键\r\n",
+		"Invalid argument":                "-ERR Invalid argument\r\n",
+		"NOAUTH":                          "-ERR NOAUTH\r\n",
+		"BAD line\r\nbreak":               "-BAD line break\r\n",
+	} {
+		var buf strings.Builder
+		writer := NewWriter(&buf)
+		writer.WriteError(msg)
+		writer.Flush()
+		if buf.String() != want {
+			t.Errorf("WriteError(%q) = %q，期望 %q", msg, buf.String(), want)
+		}
+	}
+}
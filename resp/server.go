@@ -0,0 +1,233 @@
+package resp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"memoryDataBase/dao"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Server 使用 Redis 协议对外暴露内存数据库 可以直接用 redis-cli 等工具连接
+// 每个命名空间相当于 Redis 的一个逻辑库 客户端通过 SELECT 切换
+type Server struct {
+	memoryDB         *dao.MemoryNamespaceDao
+	defaultNamespace string
+	password         string
+	protected        map[string]bool
+	startTime        time.Time
+
+	mu        sync.Mutex
+	listener  net.Listener
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+	connCount int64
+}
+
+// Options RESP 服务器的选项
+type Options struct {
+	// Password 不为空时客户端必须先通过 AUTH 或 HELLO AUTH 验证 相当于 Redis 的 requirepass
+	Password string
+	// ProtectedNamespaces 由服务层管理的命名空间 其中的值都是学生 不允许通过 RESP 修改
+	// 绕过服务层修改会让 Raft 日志 布隆过滤器和姓名索引和内存中的数据不一致
+	ProtectedNamespaces []string
+}
+
+// NewServer 创建一个新的 RESP 服务器 新连接默认使用 defaultNamespace 命名空间
+func NewServer(memoryDB *dao.MemoryNamespaceDao, defaultNamespace string, options Options) *Server {
+	protected := make(map[string]bool, len(options.ProtectedNamespaces))
+	for _, name := range options.ProtectedNamespaces {
+		protected[name] = true
+	}
+	return &Server{
+		memoryDB:         memoryDB,
+		defaultNamespace: defaultNamespace,
+		password:         options.Password,
+		protected:        protected,
+		startTime:        time.Now(),
+		conns:            make(map[net.Conn]struct{}),
+	}
+}
+
+// checkPassword 用固定时间的比较检查密码 用户名只支持 default
+func (s *Server) checkPassword(username, password string) bool {
+	return username == "default" && subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+}
+
+// ListenAndServe 监听指定地址并处理连接 地址端口为0时会使用随机端口
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve 在给定的监听器上接受连接 直到服务器关闭
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return net.ErrClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+	log.Printf("RESP 服务器开始监听：%s", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !s.trackConn(conn) {
+			conn.Close()
+			return nil
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrackConn(conn)
+			s.handleConn(conn)
+		}()
+	}
+}
+
+// Addr 返回服务器实际监听的地址 尚未开始监听时返回nil
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close 关闭监听器和所有客户端连接 并等待连接处理协程退出
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	atomic.AddInt64(&s.connCount, 1)
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	atomic.AddInt64(&s.connCount, -1)
+	conn.Close()
+}
+
+// session 保存单个客户端连接的状态
+type session struct {
+	server *Server
+	db     *dao.MemoryDBDao
+	writer *Writer
+	name   string
+	quit   bool
+	// authenticated 服务器没有设置密码时总是为true
+	authenticated bool
+}
+
+// handleConn 循环读取命令并写回回复 一次读到的多条命令会合并成一次写入以支持管道
+func (s *Server) handleConn(conn net.Conn) {
+	reader := NewReader(conn)
+	sess := &session{
+		server: s,
+		db:     s.memoryDB.Namespace(s.defaultNamespace),
+		writer: NewWriter(conn),
+		// 没有设置密码时不需要验证
+		authenticated: s.password == "",
+	}
+	for !sess.quit {
+		// 验证之前只接受很小的请求 和 Redis 一样防止没有密码的客户端让服务器分配大量内存
+		reader.SetAuthenticated(sess.authenticated)
+		args, err := reader.ReadCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				sess.writer.WriteError(err.Error())
+				sess.writer.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("读取 RESP 客户端：%s的命令时失败：%v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		sess.execute(args)
+		if reader.rd.Buffered() > 0 && !sess.quit {
+			continue
+		}
+		if err = sess.writer.Flush(); err != nil {
+			log.Printf("向 RESP 客户端：%s写入回复时失败：%v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// execute 执行一条命令
+func (sess *session) execute(args []string) {
+	name := strings.ToUpper(args[0])
+	cmd, exists := commands[name]
+	if !exists {
+		sess.writer.WriteError("ERR unknown command '" + args[0] + "'")
+		return
+	}
+	// 和 Redis 一样 没有验证的连接只能执行 AUTH HELLO 和 QUIT
+	if !sess.authenticated && name != "AUTH" && name != "HELLO" && name != "QUIT" {
+		sess.writer.WriteError("NOAUTH Authentication required.")
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		sess.writer.WriteError("ERR wrong number of arguments for '" + strings.ToLower(args[0]) + "' command")
+		return
+	}
+	if cmd.flags&flagWrite != 0 && sess.server.protected[sess.db.Name()] {
+		sess.writer.WriteError("ERR namespace '" + sess.db.Name() + "' is managed by the student service and is read-only")
+		return
+	}
+	if cmd.flags&flagWriteAll != 0 && len(sess.server.protected) > 0 {
+		sess.writer.WriteError("ERR '" + strings.ToLower(args[0]) + "' would modify namespaces managed by the student service")
+		return
+	}
+	cmd.handler(sess, args[1:])
+}
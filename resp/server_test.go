@@ -0,0 +1,200 @@
+package resp
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"memoryDataBase/dao"
+)
+
+// startServer 在随机端口上启动服务器 测试结束时关闭
+func startServer(t *testing.T, options Options) (*Server, *dao.MemoryNamespaceDao, string) {
+	t.Helper()
+	memoryDB := dao.NewMemoryNamespaceDao()
+	memoryDB.CreateNamespace("students", dao.DefaultTTLPolicy())
+	memoryDB.CreateNamespace("scratch", dao.TTLPolicy{})
+	server := NewServer(memoryDB, "scratch", options)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听随机端口失败：%v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return server, memoryDB, listener.Addr().String()
+}
+
+func newClient(t *testing.T, addr string, protocol int, password string) *redis.Client {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: addr, Protocol: protocol, Password: password})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestCommands(t *testing.T) {
+	for _, protocol := range []int{2, 3} {
+		t.Run("RESP"+string(rune('0'+protocol)), func(t *testing.T) {
+			_, _, addr := startServer(t, Options{})
+			client := newClient(t, addr, protocol, "")
+			ctx := context.Background()
+
+			if got, err := client.Set(ctx, "a", "1", 0).Result(); err != nil || got != "OK" {
+				t.Fatalf("SET a = %q, %v", got, err)
+			}
+			if got, err := client.Get(ctx, "a").Result(); err != nil || got != "1" {
+				t.Fatalf("GET a = %q, %v", got, err)
+			}
+			if _, err := client.Get(ctx, "missing").Result(); err != redis.Nil {
+				t.Fatalf("GET missing 的错误 = %v，期望 redis.Nil", err)
+			}
+
+			if got := client.TTL(ctx, "a").Val(); got != -1 {
+				t.Fatalf("没有过期时间的 TTL = %v，期望 -1", got)
+			}
+			if !client.Expire(ctx, "a", 100*time.Second).Val() {
+				t.Fatalf("EXPIRE a 返回 false")
+			}
+			if got := client.TTL(ctx, "a").Val(); got <= 99*time.Second || got > 100*time.Second {
+				t.Fatalf("EXPIRE 后的 TTL = %v", got)
+			}
+			if got := client.TTL(ctx, "missing").Val(); got != -2 {
+				t.Fatalf("不存在的键的 TTL = %v，期望 -2", got)
+			}
+			if client.Expire(ctx, "missing", time.Second).Val() {
+				t.Fatalf("EXPIRE 不存在的键返回 true")
+			}
+
+			for _, key := range []string{"b", "c", "user:1", "user:2", "user:3"} {
+				client.Set(ctx, key, key, 0)
+			}
+			var keys []string
+			var cursor uint64
+			for {
+				page, next, err := client.Scan(ctx, cursor, "user:*", 2).Result()
+				if err != nil {
+					t.Fatalf("SCAN 失败：%v", err)
+				}
+				keys = append(keys, page...)
+				if cursor = next; cursor == 0 {
+					break
+				}
+			}
+			sort.Strings(keys)
+			if strings.Join(keys, ",") != "user:1,user:2,user:3" {
+				t.Fatalf("SCAN MATCH user:* = %v", keys)
+			}
+
+			if got := client.Del(ctx, "a", "b", "missing").Val(); got != 2 {
+				t.Fatalf("DEL 删除的键数 = %d，期望 2", got)
+			}
+			if got := client.Exists(ctx, "a", "b", "c").Val(); got != 1 {
+				t.Fatalf("DEL 后 EXISTS = %d，期望 1", got)
+			}
+		})
+	}
+}
+
+func TestGetDoesNotTouchStats(t *testing.T) {
+	_, memoryDB, addr := startServer(t, Options{})
+	client := newClient(t, addr, 3, "")
+	ctx := context.Background()
+	client.Set(ctx, "a", "1", 0)
+	client.Get(ctx, "a")
+	client.Get(ctx, "missing")
+	stats := memoryDB.Namespace("scratch").Stats()
+	if stats.Hits != 0 || stats.Misses != 0 {
+		t.Fatalf("GET 改变了命中统计：hits=%d misses=%d", stats.Hits, stats.Misses)
+	}
+}
+
+func TestProtectedNamespace(t *testing.T) {
+	_, memoryDB, addr := startServer(t, Options{ProtectedNamespaces: []string{"students"}})
+	memoryDB.Namespace("students").Set("s1", "student", 0)
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: 0, PoolSize: 1})
+	t.Cleanup(func() { client.Close() })
+	ctx := context.Background()
+	if err := client.Do(ctx, "SELECT", "students").Err(); err != nil {
+		t.Fatalf("SELECT students 失败：%v", err)
+	}
+	writes := [][]interface{}{
+		{"SET", "s1", "not a student"},
+		{"DEL", "s1"},
+		{"UNLINK", "s1"},
+		{"EXPIRE", "s1", "1"},
+		{"PEXPIRE", "s1", "1"},
+		{"PERSIST", "s1"},
+		{"FLUSHDB"},
+	}
+	for _, args := range writes {
+		if err := client.Do(ctx, args...).Err(); err == nil || !strings.Contains(err.Error(), "read-only") {
+			t.Fatalf("在学生命名空间执行 %v 的错误 = %v", args[0], err)
+		}
+	}
+	if err := client.Do(ctx, "FLUSHALL").Err(); err == nil {
+		t.Fatalf("有受保护的命名空间时 FLUSHALL 成功了")
+	}
+	// 读命令不受影响
+	if got := client.Do(ctx, "EXISTS", "s1").Val(); got != int64(1) {
+		t.Fatalf("EXISTS s1 = %v，期望学生仍然存在", got)
+	}
+	if _, exists := memoryDB.Namespace("students").Peek("s1"); !exists {
+		t.Fatalf("受保护命名空间中的学生被删除了")
+	}
+}
+
+// TestUnauthenticatedLimits 没有验证的连接发送超过限制的请求时返回协议错误并断开
+func TestUnauthenticatedLimits(t *testing.T) {
+	_, _, addr := startServer(t, Options{Password: "secret"})
+	for _, request := range []string{
+		"*1000\r\n",
+		"*1\r\n$1000000\r\n",
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("连接失败：%v", err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err = conn.Write([]byte(request)); err != nil {
+			t.Fatalf("发送请求失败：%v", err)
+		}
+		reply, _ := io.ReadAll(conn)
+		conn.Close()
+		if !strings.HasPrefix(string(reply), "-ERR Protocol error") {
+			t.Fatalf("请求 %q 的回复 = %q，期望协议错误", request, reply)
+		}
+	}
+	// 验证之后可以发送更大的请求
+	client := newClient(t, addr, 2, "secret")
+	value := strings.Repeat("x", maxUnauthBulkLength*2)
+	if err := client.Set(context.Background(), "big", value, 0).Err(); err != nil {
+		t.Fatalf("验证后 SET 大的值失败：%v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	for _, protocol := range []int{2, 3} {
+		t.Run("RESP"+string(rune('0'+protocol)), func(t *testing.T) {
+			_, _, addr := startServer(t, Options{Password: "secret"})
+			ctx := context.Background()
+
+			if err := newClient(t, addr, protocol, "").Ping(ctx).Err(); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+				t.Fatalf("没有密码的错误 = %v，期望 NOAUTH", err)
+			}
+			if err := newClient(t, addr, protocol, "wrong").Ping(ctx).Err(); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+				t.Fatalf("错误密码的错误 = %v，期望 WRONGPASS", err)
+			}
+			client := newClient(t, addr, protocol, "secret")
+			if err := client.Set(ctx, "a", "1", 0).Err(); err != nil {
+				t.Fatalf("验证后 SET 失败：%v", err)
+			}
+			if got := client.Get(ctx, "a").Val(); got != "1" {
+				t.Fatalf("验证后 GET a = %q", got)
+			}
+		})
+	}
+}
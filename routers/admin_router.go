@@ -0,0 +1,19 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"memoryDataBase/controller"
+)
+
+func SetUpAdminRouter(r *gin.Engine, adminController *controller.AdminController) {
+	adminGroup := r.Group("/admin")
+
+	adminGroup.GET("/memdb/info", adminController.MemoryDBInfo)
+	adminGroup.GET("/memdb/keys", adminController.ScanKeys)
+	adminGroup.GET("/loader/stats", adminController.LoaderStats)
+	adminGroup.GET("/writebehind/stats", adminController.WriteBehindStats)
+	adminGroup.GET("/access/stats", adminController.AccessCounterStats)
+	adminGroup.GET("/hot", adminController.HotStudents)
+	adminGroup.POST("/reconcile", adminController.StartReconcile)
+	adminGroup.GET("/reconcile", adminController.ReconcileStatus)
+}
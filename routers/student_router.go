@@ -10,7 +10,11 @@ func SetUpStudentRouter(studentController *controller.StudentController) *gin.En
 	studentGroup := r.Group("/student")
 
 	studentGroup.POST("", studentController.AddStudent)
+	studentGroup.GET("", studentController.ListStudents)
+	studentGroup.GET("/search", studentController.SearchStudents)
 	studentGroup.GET("/:id", studentController.GetStudent)
+	studentGroup.GET("/:id/history", studentController.GetStudentHistory)
+	studentGroup.POST("/:id/restore", studentController.RestoreStudent)
 	studentGroup.PUT("", studentController.UpdateStudent)
 	studentGroup.DELETE("/:id", studentController.DeleteStudent)
 
@@ -0,0 +1,352 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// gramStart 和 gramEnd 标记词的开头和结尾 开头的二元组可以匹配前缀
+	gramStart = '\x02'
+	gramEnd   = '\x03'
+)
+
+// 匹配方式 按分数从高到低
+const (
+	MatchExact     = "exact"
+	MatchPrefix    = "prefix"
+	MatchSubstring = "substring"
+	MatchFuzzy     = "fuzzy"
+	MatchNgram     = "ngram"
+	// pinyinPrefix 通过拼音匹配时加在匹配方式前面 例如 pinyin-prefix
+	pinyinPrefix = "pinyin-"
+)
+
+// 每种匹配方式的分数 拼音匹配的分数打折 相同时汉字匹配排在前面
+const (
+	exactScore     = 1.0
+	prefixScore    = 0.9
+	substringScore = 0.8
+	fuzzyScore     = 0.7
+	// fuzzyPrefixScore 查询和词的前缀相差几个字符 例如输入到一半的拼写错误
+	fuzzyPrefixScore = 0.6
+	editPenalty      = 0.1
+	// minNgramSimilarity 二元组相似度低于这个值时不算匹配
+	minNgramSimilarity = 0.5
+	ngramScore         = 0.5
+	pinyinDiscount     = 0.95
+)
+
+// Match 一条搜索结果
+type Match struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Score     float64 `json:"score"`
+	MatchedBy string  `json:"matched_by"`
+}
+
+type term struct {
+	text   []rune
+	grams  map[string]bool
+	pinyin bool
+}
+
+// Index 姓名的倒排索引 按二元组找到候选 再按前缀 子串 编辑距离和二元组相似度打分
+// 汉字姓名同时索引全拼和首字母 可以用拼音搜索
+type Index struct {
+	mu    sync.RWMutex
+	names map[string]string
+	terms map[string][]term
+	// postings 二元组和单个字符到包含它的 ID
+	postings map[string]map[string]struct{}
+}
+
+// New 创建空的索引
+func New() *Index {
+	return &Index{
+		names:    make(map[string]string),
+		terms:    make(map[string][]term),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// normalize 转换为小写 去掉空白和姓名中的间隔号
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '·' || r == '•' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// grams 词的所有二元组 开头和结尾加上标记 再加上每个单独的字符用于单字查询
+func grams(text []rune) map[string]bool {
+	result := make(map[string]bool, len(text)*2+1)
+	padded := make([]rune, 0, len(text)+2)
+	padded = append(append(append(padded, gramStart), text...), gramEnd)
+	for i := 0; i+1 < len(padded); i++ {
+		result[string(padded[i:i+2])] = true
+	}
+	for _, r := range text {
+		result[string(r)] = true
+	}
+	return result
+}
+
+// queryGrams 查询使用的二元组 单字查询使用单个字符
+func queryGrams(text []rune) []string {
+	if len(text) == 1 {
+		return []string{string(text)}
+	}
+	var result []string
+	for gram := range grams(text) {
+		if len([]rune(gram)) == 2 {
+			result = append(result, gram)
+		}
+	}
+	return result
+}
+
+func newTerm(text string, pinyin bool) term {
+	runes := []rune(text)
+	return term{text: runes, grams: grams(runes), pinyin: pinyin}
+}
+
+// nameTerms 姓名索引的词 包括姓名本身 多个单词的姓名中的每个单词 汉字姓名的全拼和首字母
+func nameTerms(name string) []term {
+	normalized := normalize(name)
+	if normalized == "" {
+		return nil
+	}
+	terms := []term{newTerm(normalized, false)}
+	if words := strings.Fields(name); len(words) > 1 {
+		for _, word := range words {
+			terms = append(terms, newTerm(normalize(word), false))
+		}
+	}
+	if full, initials, ok := Pinyin(normalized); ok {
+		terms = append(terms, newTerm(full, true))
+		if initials != full {
+			terms = append(terms, newTerm(initials, true))
+		}
+	}
+	return terms
+}
+
+// Put 添加或更新 ID 对应的姓名
+func (x *Index) Put(id, name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old, exists := x.names[id]; exists {
+		if old == name {
+			return
+		}
+		x.remove(id)
+	}
+	terms := nameTerms(name)
+	if len(terms) == 0 {
+		return
+	}
+	x.names[id] = name
+	x.terms[id] = terms
+	for _, t := range terms {
+		for gram := range t.grams {
+			ids := x.postings[gram]
+			if ids == nil {
+				ids = make(map[string]struct{})
+				x.postings[gram] = ids
+			}
+			ids[id] = struct{}{}
+		}
+	}
+}
+
+// Remove 从索引中删除 ID
+func (x *Index) Remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(id)
+}
+
+func (x *Index) remove(id string) {
+	for _, t := range x.terms[id] {
+		for gram := range t.grams {
+			if ids := x.postings[gram]; ids != nil {
+				delete(ids, id)
+				if len(ids) == 0 {
+					delete(x.postings, gram)
+				}
+			}
+		}
+	}
+	delete(x.names, id)
+	delete(x.terms, id)
+}
+
+// Reset 清空索引
+func (x *Index) Reset() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.names = make(map[string]string)
+	x.terms = make(map[string][]term)
+	x.postings = make(map[string]map[string]struct{})
+}
+
+// Len 返回索引中的姓名数
+func (x *Index) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.names)
+}
+
+// Search 返回和查询最匹配的最多 limit 个结果 按分数从高到低 分数相同时按姓名和 ID 排序
+func (x *Index) Search(query string, limit int) []Match {
+	q := []rune(normalize(query))
+	if len(q) == 0 || limit <= 0 {
+		return nil
+	}
+	qGrams := queryGrams(q)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	candidates := make(map[string]struct{})
+	for _, gram := range qGrams {
+		for id := range x.postings[gram] {
+			candidates[id] = struct{}{}
+		}
+	}
+	// 短查询的拼写错误可能破坏所有的二元组 例如 ab 和 ba 没有相同的二元组 这时也用单个字符的倒排找候选
+	if editsBreakAllGrams(len(q)) {
+		for _, r := range q {
+			for id := range x.postings[string(r)] {
+				candidates[id] = struct{}{}
+			}
+		}
+	}
+
+	matches := make([]Match, 0, len(candidates))
+	for id := range candidates {
+		best := Match{ID: id, Name: x.names[id]}
+		for _, t := range x.terms[id] {
+			score, matchedBy := scoreTerm(q, qGrams, t)
+			if t.pinyin {
+				score *= pinyinDiscount
+				matchedBy = pinyinPrefix + matchedBy
+			}
+			if score > best.Score {
+				best.Score = score
+				best.MatchedBy = matchedBy
+			}
+		}
+		if best.Score > 0 {
+			matches = append(matches, best)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if matches[i].Name != matches[j].Name {
+			return matches[i].Name < matches[j].Name
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// maxEdits 允许的编辑距离随查询长度增加 单字不做模糊匹配
+func maxEdits(length int) int {
+	switch {
+	case length <= 1:
+		return 0
+	case length <= 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editsBreakAllGrams 允许的编辑是否可能破坏查询和词的前缀共有的所有二元组
+// 长度为 n 的查询和词的前缀比较时有 n 个二元组(开头标记和 n-1 个相邻字符) 一次编辑最多破坏3个(交换相邻字符)
+func editsBreakAllGrams(length int) bool {
+	edits := maxEdits(length)
+	return edits > 0 && length <= 3*edits
+}
+
+// scoreTerm 计算查询和一个词的分数 不匹配时返回0
+func scoreTerm(q []rune, qGrams []string, t term) (float64, string) {
+	text := string(t.text)
+	query := string(q)
+	switch {
+	case text == query:
+		return exactScore, MatchExact
+	case strings.HasPrefix(text, query):
+		return prefixScore, MatchPrefix
+	case strings.Contains(text, query):
+		return substringScore, MatchSubstring
+	}
+	// 首字母很短 模糊匹配会匹配到大量无关的姓名
+	if limit := maxEdits(len(q)); limit > 0 && !(t.pinyin && len(t.text) <= 4) {
+		if d := editDistance(q, t.text); d <= limit {
+			return fuzzyScore - editPenalty*float64(d), MatchFuzzy
+		}
+		if len(q) >= 3 && len(t.text) > len(q) {
+			if d := editDistance(q, t.text[:len(q)]); d <= limit {
+				return fuzzyPrefixScore - editPenalty*float64(d), MatchFuzzy
+			}
+		}
+	}
+	if len(qGrams) > 1 {
+		shared := 0
+		for _, gram := range qGrams {
+			if t.grams[gram] {
+				shared++
+			}
+		}
+		// 词的二元组数 t.grams 中还包含单个字符
+		termGrams := len(t.grams) - distinctRunes(t.text)
+		similarity := 2 * float64(shared) / float64(len(qGrams)+termGrams)
+		if similarity >= minNgramSimilarity {
+			return ngramScore * similarity, MatchNgram
+		}
+	}
+	return 0, ""
+}
+
+func distinctRunes(text []rune) int {
+	seen := make(map[rune]bool, len(text))
+	for _, r := range text {
+		seen[r] = true
+	}
+	return len(seen)
+}
+
+// editDistance 两个字符串的编辑距离 相邻两个字符交换算一次编辑
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"testing"
+)
+
+func newTestIndex() *Index {
+	x := New()
+	for id, name := range map[string]string{
+		"1": "张三",
+		"2": "张三丰",
+		"3": "李四",
+		"4": "Alexander",
+		"5": "Alexandra Smith",
+		"6": "ba",
+		"7": "Catherine",
+		"8": "欧阳修",
+	} {
+		x.Put(id, name)
+	}
+	return x
+}
+
+func TestSearch(t *testing.T) {
+	x := newTestIndex()
+	for _, tc := range []struct {
+		name      string
+		query     string
+		id        string
+		matchedBy string
+	}{
+		{name: "汉字完全匹配", query: "张三", id: "1", matchedBy: MatchExact},
+		{name: "忽略大小写和空白", query: " ALEXANDER ", id: "4", matchedBy: MatchExact},
+		{name: "多个单词中的一个", query: "smith", id: "5", matchedBy: MatchExact},
+		{name: "前缀", query: "alexa", id: "4", matchedBy: MatchPrefix},
+		{name: "子串", query: "三丰", id: "2", matchedBy: MatchSubstring},
+		{name: "拼写错误", query: "alaxander", id: "4", matchedBy: MatchFuzzy},
+		{name: "交换相邻字符", query: "catherien", id: "7", matchedBy: MatchFuzzy},
+		{name: "输入到一半的拼写错误", query: "cathr", id: "7", matchedBy: MatchFuzzy},
+		{name: "没有相同二元组的短查询", query: "ab", id: "6", matchedBy: MatchFuzzy},
+		{name: "二元组相似", query: "alexanderson", id: "4", matchedBy: MatchNgram},
+		{name: "全拼", query: "zhangsan", id: "1", matchedBy: pinyinPrefix + MatchExact},
+		{name: "拼音前缀", query: "ouyang", id: "8", matchedBy: pinyinPrefix + MatchPrefix},
+		{name: "首字母", query: "zsf", id: "2", matchedBy: pinyinPrefix + MatchExact},
+		{name: "拼音拼写错误", query: "zhangsna", id: "1", matchedBy: pinyinPrefix + MatchFuzzy},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, match := range x.Search(tc.query, 10) {
+				if match.ID == tc.id {
+					if match.MatchedBy != tc.matchedBy {
+						t.Fatalf("搜索%q匹配%s的方式 = %s，期望 %s", tc.query, tc.id, match.MatchedBy, tc.matchedBy)
+					}
+					return
+				}
+			}
+			t.Fatalf("搜索%q = %+v，没有找到%s", tc.query, x.Search(tc.query, 10), tc.id)
+		})
+	}
+}
+
+// TestSearchOrder 分数高的匹配方式排在前面 汉字匹配排在拼音匹配前面
+func TestSearchOrder(t *testing.T) {
+	x := newTestIndex()
+	matches := x.Search("张三", 10)
+	if len(matches) < 2 || matches[0].ID != "1" || matches[1].ID != "2" {
+		t.Fatalf("搜索张三 = %+v，期望先完全匹配的张三再前缀匹配的张三丰", matches)
+	}
+	if matches := x.Search("alex", 1); len(matches) != 1 {
+		t.Fatalf("limit为1时返回了%d个结果", len(matches))
+	}
+}
+
+func TestSearchRemove(t *testing.T) {
+	x := newTestIndex()
+	x.Put("1", "王五")
+	x.Remove("3")
+	for _, query := range []string{"李四", "lisi"} {
+		if matches := x.Search(query, 10); len(matches) != 0 {
+			t.Fatalf("删除后搜索%s = %+v，期望没有结果", query, matches)
+		}
+	}
+	if matches := x.Search("张三", 10); len(matches) != 1 || matches[0].ID != "2" {
+		t.Fatalf("改名后搜索张三 = %+v，期望只有张三丰", matches)
+	}
+	if x.Len() != 7 {
+		t.Fatalf("索引中的姓名数 = %d，期望 7", x.Len())
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"ab", "ba", 1},
+		{"abcd", "acbd", 1},
+		{"kitten", "sitting", 3},
+		{"张三", "张山", 1},
+	} {
+		if got := editDistance([]rune(tc.a), []rune(tc.b)); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d，期望 %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
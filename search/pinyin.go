@@ -0,0 +1,36 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// runePinyin 汉字到拼音的映射 由 pinyinTable 生成
+var runePinyin = make(map[rune]string)
+
+func init() {
+	for syllable, chars := range pinyinTable {
+		for _, r := range chars {
+			runePinyin[r] = syllable
+		}
+	}
+}
+
+// Pinyin 把姓名转换为全拼和首字母 例如 张三 转换为 zhangsan 和 zs
+// 非汉字原样保留 没有收录的汉字也原样保留 姓名中没有可以转换的汉字时 ok 为false
+func Pinyin(name string) (full string, initials string, ok bool) {
+	var fullBuilder, initialsBuilder strings.Builder
+	for _, r := range name {
+		syllable, found := runePinyin[r]
+		if !found {
+			r = unicode.ToLower(r)
+			fullBuilder.WriteRune(r)
+			initialsBuilder.WriteRune(r)
+			continue
+		}
+		ok = true
+		fullBuilder.WriteString(syllable)
+		initialsBuilder.WriteByte(syllable[0])
+	}
+	return fullBuilder.String(), initialsBuilder.String(), ok
+}
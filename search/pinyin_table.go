@@ -0,0 +1,314 @@
+package search
+
+// pinyinTable 常用姓氏和名字用字的拼音 不带声调 多音字只收录作为姓名时的读音
+// 没有收录的汉字在转换时原样保留
+var pinyinTable = map[string]string{
+	"a":      "阿啊",
+	"ai":     "艾爱哀蔼碍",
+	"an":     "安按岸案暗谙鞍庵",
+	"ang":    "昂盎",
+	"ao":     "敖奥傲澳遨翱",
+	"ba":     "巴八把霸坝拔芭",
+	"bai":    "白百柏佰拜摆",
+	"ban":    "班半板版斑般伴扮",
+	"bang":   "邦帮榜棒",
+	"bao":    "包宝保鲍葆褒报抱暴豹",
+	"bei":    "贝北倍备蓓悲背杯碑辈",
+	"ben":    "本奔贲",
+	"beng":   "崩蹦",
+	"bi":     "毕碧璧必比彼笔壁币闭庇弼毖",
+	"bian":   "边卞汴便变辩辨遍编鞭",
+	"biao":   "彪标表镖骠",
+	"bie":    "别",
+	"bin":    "宾彬斌滨缤濒",
+	"bing":   "冰兵丙秉炳昞病并",
+	"bo":     "波博伯勃泊渤薄搏铂舶帛",
+	"bu":     "卜步布不部补",
+	"cai":    "才财材采彩菜蔡裁",
+	"can":    "参灿璨餐残蚕",
+	"cang":   "仓苍沧藏舱",
+	"cao":    "曹草操槽",
+	"ce":     "策册侧测",
+	"cen":    "岑",
+	"ceng":   "层",
+	"cha":    "查茶察",
+	"chai":   "柴钗",
+	"chan":   "婵蝉禅产阐",
+	"chang":  "常昌长畅倡唱厂场尝肠",
+	"chao":   "超朝潮巢晁",
+	"che":    "车彻澈",
+	"chen":   "陈晨辰臣沉宸琛尘忱谌",
+	"cheng":  "成程城承诚橙乘澄呈丞骋铖",
+	"chi":    "池迟驰赤持尺齿炽",
+	"chong":  "崇充冲宠",
+	"chou":   "仇酬稠",
+	"chu":    "楚初储褚出处础雏",
+	"chuan":  "川传船穿",
+	"chuang": "创窗床闯",
+	"chun":   "春纯淳醇椿",
+	"ci":     "慈辞词瓷磁次",
+	"cong":   "从丛聪琮葱",
+	"cui":    "崔翠萃粹璀",
+	"cun":    "村存寸",
+	"cuo":    "措",
+	"da":     "达大答",
+	"dai":    "戴代岱黛带待",
+	"dan":    "丹单旦但淡诞蛋",
+	"dang":   "党当荡",
+	"dao":    "道刀导岛稻",
+	"de":     "德得",
+	"deng":   "邓登灯等",
+	"di":     "狄迪笛帝弟第地娣荻",
+	"dian":   "典殿点电",
+	"diao":   "刁雕",
+	"ding":   "丁定鼎顶",
+	"dong":   "东董冬栋洞",
+	"dou":    "窦斗豆",
+	"du":     "杜都度渡笃督独读",
+	"duan":   "段端",
+	"dui":    "对",
+	"dun":    "敦顿盾",
+	"duo":    "多朵铎",
+	"e":      "鄂峨娥鹅额",
+	"en":     "恩",
+	"er":     "尔二儿",
+	"fa":     "法发",
+	"fan":    "范樊凡帆繁梵藩反饭",
+	"fang":   "方房芳放访舫",
+	"fei":    "费飞菲斐霏妃肥",
+	"fen":    "芬分汾份奋",
+	"feng":   "冯封丰峰风凤锋枫逢奉",
+	"fo":     "佛",
+	"fu":     "傅付符富福伏扶甫府复阜芙孚馥",
+	"gai":    "盖改",
+	"gan":    "甘干淦赣感",
+	"gang":   "刚钢港岗纲",
+	"gao":    "高郜皋杲",
+	"ge":     "葛戈格歌阁鸽",
+	"gen":    "根",
+	"geng":   "耿庚更",
+	"gong":   "龚宫巩公功贡恭",
+	"gou":    "勾苟",
+	"gu":     "顾古谷辜鼓固",
+	"gua":    "瓜",
+	"guan":   "关管官冠贯观",
+	"guang":  "光广",
+	"gui":    "桂贵归瑰规",
+	"gun":    "衮",
+	"guo":    "郭国果过",
+	"ha":     "哈",
+	"hai":    "海亥",
+	"han":    "韩汉寒涵翰函含晗瀚",
+	"hang":   "杭航",
+	"hao":    "郝浩昊好豪皓灏",
+	"he":     "何贺和赫荷鹤河禾合",
+	"hei":    "黑",
+	"heng":   "恒衡亨珩",
+	"hong":   "洪红宏鸿弘虹泓",
+	"hou":    "侯后厚",
+	"hu":     "胡呼虎湖护沪瑚",
+	"hua":    "华花化桦画",
+	"huai":   "怀淮",
+	"huan":   "欢环桓焕寰",
+	"huang":  "黄皇煌凰璜",
+	"hui":    "惠辉慧晖会徽卉",
+	"hun":    "浑",
+	"huo":    "霍火",
+	"ji":     "纪季吉姬冀嵇计济继基积际绩骥",
+	"jia":    "贾家佳嘉甲夹",
+	"jian":   "简建剑坚健鉴菅",
+	"jiang":  "江姜蒋将疆",
+	"jiao":   "焦娇姣皎",
+	"jie":    "杰洁捷婕节介",
+	"jin":    "金晋靳锦瑾劲津进今",
+	"jing":   "景静晶京靖敬荆井菁",
+	"jiong":  "炯",
+	"jiu":    "九久玖",
+	"ju":     "居菊聚鞠巨举",
+	"juan":   "娟涓隽",
+	"jue":    "觉珏",
+	"jun":    "军君俊峻钧骏",
+	"kai":    "凯开楷恺",
+	"kan":    "阚堪",
+	"kang":   "康亢",
+	"ke":     "柯可克科珂",
+	"ken":    "肯",
+	"kong":   "孔空",
+	"kou":    "寇",
+	"kuai":   "蒯",
+	"kuan":   "宽",
+	"kuang":  "匡况旷邝",
+	"kui":    "奎葵魁",
+	"kun":    "坤昆琨",
+	"la":     "拉",
+	"lai":    "来赖莱",
+	"lan":    "兰蓝岚澜",
+	"lang":   "郎朗浪",
+	"lao":    "劳",
+	"le":     "勒",
+	"lei":    "雷蕾磊",
+	"leng":   "冷",
+	"li":     "李黎丽利礼力立莉历励理厉郦栗",
+	"lian":   "连廉莲联练炼涟",
+	"liang":  "梁良亮凉",
+	"liao":   "廖辽",
+	"lin":    "林琳霖麟临淋蔺",
+	"ling":   "凌玲灵令岭菱龄",
+	"liu":    "刘柳留流六",
+	"long":   "龙隆",
+	"lou":    "楼娄",
+	"lu":     "卢鲁陆路露璐禄鹿芦",
+	"lv":     "吕律绿旅",
+	"luan":   "栾",
+	"lun":    "伦纶",
+	"luo":    "罗骆洛落",
+	"ma":     "马麻玛",
+	"mai":    "麦迈",
+	"man":    "满曼蔓",
+	"mang":   "芒",
+	"mao":    "毛茅茂懋",
+	"mei":    "梅美玫媚眉",
+	"men":    "门",
+	"meng":   "孟蒙萌梦",
+	"mi":     "米宓密蜜",
+	"mian":   "勉",
+	"miao":   "苗妙淼缪",
+	"min":    "闵敏民旻珉",
+	"ming":   "明名鸣铭茗",
+	"mo":     "莫墨默茉",
+	"mou":    "牟",
+	"mu":     "穆木牧慕沐睦",
+	"na":     "娜纳那",
+	"nai":    "乃",
+	"nan":    "南楠男",
+	"ni":     "倪尼妮霓",
+	"nian":   "年念",
+	"ning":   "宁凝",
+	"niu":    "牛钮",
+	"nong":   "农",
+	"nuo":    "诺",
+	"ou":     "欧区鸥",
+	"pan":    "潘盼攀",
+	"pang":   "庞",
+	"pei":    "裴沛培佩",
+	"peng":   "彭鹏蓬",
+	"pi":     "皮",
+	"piao":   "朴飘",
+	"pin":    "品",
+	"ping":   "平萍屏坪",
+	"pu":     "蒲浦普溥",
+	"qi":     "齐戚祁琪棋奇启其淇琦起麒",
+	"qian":   "钱倩谦乾千前黔",
+	"qiang":  "强蔷",
+	"qiao":   "乔巧",
+	"qin":    "秦琴勤钦覃沁",
+	"qing":   "青清庆卿晴擎",
+	"qiong":  "琼穹",
+	"qiu":    "邱秋丘裘",
+	"qu":     "曲屈瞿渠",
+	"quan":   "全权泉",
+	"que":    "阙",
+	"qun":    "群",
+	"ran":    "冉然",
+	"rao":    "饶",
+	"ren":    "任仁",
+	"rong":   "荣容蓉融榕戎",
+	"rou":    "柔",
+	"ru":     "茹如儒汝",
+	"ruan":   "阮",
+	"rui":    "瑞睿芮蕊锐",
+	"run":    "润",
+	"ruo":    "若",
+	"sa":     "萨",
+	"sai":    "赛",
+	"san":    "三",
+	"sang":   "桑",
+	"sen":    "森",
+	"sha":    "沙莎",
+	"shan":   "山珊善杉姗",
+	"shang":  "商尚上",
+	"shao":   "邵韶绍少",
+	"she":    "佘",
+	"shen":   "沈申深神慎莘",
+	"sheng":  "盛生胜圣晟升",
+	"shi":    "石史施时世诗士师实仕",
+	"shou":   "寿守",
+	"shu":    "舒书淑树殊蜀",
+	"shuang": "双爽",
+	"shui":   "水",
+	"shun":   "顺舜",
+	"shuo":   "朔硕",
+	"si":     "司思斯四",
+	"song":   "宋松嵩颂",
+	"su":     "苏素肃",
+	"sui":    "隋穗遂",
+	"sun":    "孙",
+	"suo":    "索",
+	"tai":    "台泰太",
+	"tan":    "谭谈檀坦",
+	"tang":   "唐汤堂棠",
+	"tao":    "陶涛桃韬",
+	"teng":   "滕腾",
+	"ti":     "提",
+	"tian":   "田天甜恬",
+	"ting":   "婷亭庭廷霆",
+	"tong":   "童佟同彤桐通",
+	"tu":     "涂屠图",
+	"tuo":    "拓",
+	"wan":    "万宛婉晚皖",
+	"wang":   "王汪旺望",
+	"wei":    "魏韦卫蔚伟威薇巍维微为",
+	"wen":    "文温闻雯稳",
+	"weng":   "翁",
+	"wo":     "沃",
+	"wu":     "吴武伍巫邬乌午舞悟",
+	"xi":     "席西熙希曦喜夕溪锡奚羲",
+	"xia":    "夏霞侠",
+	"xian":   "冼贤仙先娴鲜宪显",
+	"xiang":  "向项香祥翔湘相襄",
+	"xiao":   "肖萧晓小孝笑筱潇",
+	"xie":    "谢解协",
+	"xin":    "辛新欣心馨鑫信昕",
+	"xing":   "邢星兴行杏幸醒",
+	"xiong":  "熊雄",
+	"xiu":    "秀修",
+	"xu":     "徐许胥旭续序绪栩",
+	"xuan":   "宣轩萱玄璇炫",
+	"xue":    "薛学雪",
+	"xun":    "荀寻迅勋逊",
+	"ya":     "雅亚娅",
+	"yan":    "严颜阎燕闫言艳岩彦妍晏炎",
+	"yang":   "杨阳扬洋羊央仰",
+	"yao":    "姚尧瑶耀",
+	"ye":     "叶业野夜烨",
+	"yi":     "易伊依毅怡仪艺宜逸一亿义益谊奕",
+	"yin":    "尹殷阴银音寅茵",
+	"ying":   "应英颖莹盈迎影瑛樱鹰",
+	"yong":   "雍永勇涌咏泳",
+	"you":    "尤游友优有佑悠",
+	"yu":     "于余俞虞喻郁宇雨玉羽语瑜钰裕煜昱禹鱼渝",
+	"yuan":   "袁元原远苑源媛圆渊",
+	"yue":    "岳越悦乐月跃",
+	"yun":    "云运韵芸允蕴昀",
+	"zang":   "臧",
+	"zeng":   "曾增",
+	"zha":    "扎",
+	"zhai":   "翟",
+	"zhan":   "詹展湛战占",
+	"zhang":  "张章彰璋樟",
+	"zhao":   "赵昭照兆钊",
+	"zhe":    "哲浙",
+	"zhen":   "甄真珍贞振震臻镇",
+	"zheng":  "郑正征政峥铮",
+	"zhi":    "支志智芝之直知致植至治",
+	"zhong":  "钟仲中忠众",
+	"zhou":   "周舟洲州",
+	"zhu":    "朱祝诸竹珠铸柱主",
+	"zhuang": "庄壮",
+	"zhuo":   "卓",
+	"zi":     "子紫梓资姿",
+	"zong":   "宗",
+	"zou":    "邹",
+	"zu":     "祖",
+	"zuo":    "左",
+}
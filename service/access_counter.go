@@ -0,0 +1,204 @@
+package service
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AccessCountOptions 访问计数的配置
+type AccessCountOptions struct {
+	// FlushInterval 把累积的访问次数写入数据库的间隔
+	FlushInterval time.Duration
+	// MaxPending 内存中最多累积多少个学生的访问次数 超过后新学生的访问不再计数
+	MaxPending int
+	// BatchSize 每条语句最多写入的学生数
+	BatchSize int
+}
+
+// DefaultAccessCountOptions 返回访问计数的默认配置
+func DefaultAccessCountOptions() AccessCountOptions {
+	return AccessCountOptions{
+		FlushInterval: 5 * time.Second,
+		MaxPending:    100000,
+		BatchSize:     500,
+	}
+}
+
+// AccessCounterStats 访问计数的统计信息
+type AccessCounterStats struct {
+	Pending  int   `json:"pending"`
+	Flushed  int64 `json:"flushed"`
+	Dropped  int64 `json:"dropped"`
+	Failures int64 `json:"failures"`
+}
+
+// accessCounter 在内存中累积每个学生的访问次数 由后台定期批量写入数据库 读请求不再等待数据库
+type accessCounter struct {
+	mysqlService *StudentMysqlService
+	options      AccessCountOptions
+
+	mu     sync.Mutex
+	deltas map[string]int64
+	// forgotten 正在写入的这一批中被 forget 的学生 写入失败后不再放回缓冲区
+	forgotten map[string]bool
+
+	full chan struct{}
+	quit chan struct{}
+	done chan struct{}
+
+	flushed  int64
+	dropped  int64
+	failures int64
+}
+
+func newAccessCounter(mysqlService *StudentMysqlService, options AccessCountOptions) *accessCounter {
+	ac := &accessCounter{
+		mysqlService: mysqlService,
+		options:      options,
+		deltas:       make(map[string]int64),
+		forgotten:    make(map[string]bool),
+		full:         make(chan struct{}, 1),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go ac.run()
+	return ac
+}
+
+// record 记录一次访问 缓冲区满时丢弃新学生的访问并提前触发写入
+func (ac *accessCounter) record(id string) {
+	ac.mu.Lock()
+	_, exists := ac.deltas[id]
+	if exists || len(ac.deltas) < ac.options.MaxPending {
+		ac.deltas[id]++
+	} else {
+		atomic.AddInt64(&ac.dropped, 1)
+	}
+	full := len(ac.deltas) >= ac.options.MaxPending
+	ac.mu.Unlock()
+	if full {
+		select {
+		case ac.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// forget 丢弃学生还没有写入的访问次数 学生被删除时调用 正在写入的访问次数失败后也不再重试
+func (ac *accessCounter) forget(id string) {
+	ac.mu.Lock()
+	delete(ac.deltas, id)
+	ac.forgotten[id] = true
+	ac.mu.Unlock()
+}
+
+// isForgotten 判断学生在这一批写入期间是否被 forget
+func (ac *accessCounter) isForgotten(id string) bool {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return ac.forgotten[id]
+}
+
+func (ac *accessCounter) run() {
+	defer close(ac.done)
+	ticker := time.NewTicker(ac.options.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ac.quit:
+			ac.flush()
+			return
+		case <-ticker.C:
+		case <-ac.full:
+		}
+		ac.flush()
+	}
+}
+
+// flush 取出累积的访问次数分批写入数据库 整批失败时逐个学生重试 仍然失败的放回缓冲区下次再写
+func (ac *accessCounter) flush() {
+	ac.mu.Lock()
+	deltas := ac.deltas
+	ac.deltas = make(map[string]int64)
+	ac.forgotten = make(map[string]bool)
+	ac.mu.Unlock()
+	if len(deltas) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(deltas))
+	for id := range deltas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for start := 0; start < len(ids); start += ac.options.BatchSize {
+		end := start + ac.options.BatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := make(map[string]int64, end-start)
+		for _, id := range ids[start:end] {
+			batch[id] = deltas[id]
+		}
+		if err := ac.mysqlService.AddStudentCounts(batch); err == nil {
+			atomic.AddInt64(&ac.flushed, int64(len(batch)))
+			continue
+		}
+		// 逐个学生重试 只有仍然失败的学生放回缓冲区 重试前被删除的学生不再写入
+		for id, delta := range batch {
+			if ac.isForgotten(id) {
+				continue
+			}
+			if err := ac.mysqlService.AddStudentCounts(map[string]int64{id: delta}); err != nil {
+				atomic.AddInt64(&ac.failures, 1)
+				if existsErr := ac.mysqlService.StudentExists(id); existsErr != nil && ac.mysqlService.StudentNotFoundErr(id, existsErr) {
+					continue
+				}
+				log.Printf("写入学生：%s的访问次数失败，稍后重试：%v", id, err)
+				ac.restore(id, delta)
+				continue
+			}
+			atomic.AddInt64(&ac.flushed, 1)
+		}
+	}
+}
+
+// restore 把写入失败的访问次数放回缓冲区 写入期间被删除的学生直接丢弃
+func (ac *accessCounter) restore(id string, delta int64) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.forgotten[id] {
+		return
+	}
+	if _, exists := ac.deltas[id]; !exists && len(ac.deltas) >= ac.options.MaxPending {
+		atomic.AddInt64(&ac.dropped, delta)
+		return
+	}
+	ac.deltas[id] += delta
+}
+
+// AccessCounterStats 返回访问计数的统计信息
+func (ss *StudentService) AccessCounterStats() AccessCounterStats {
+	return ss.accessCounter.stats()
+}
+
+func (ac *accessCounter) stats() AccessCounterStats {
+	ac.mu.Lock()
+	pending := len(ac.deltas)
+	ac.mu.Unlock()
+	return AccessCounterStats{
+		Pending:  pending,
+		Flushed:  atomic.LoadInt64(&ac.flushed),
+		Dropped:  atomic.LoadInt64(&ac.dropped),
+		Failures: atomic.LoadInt64(&ac.failures),
+	}
+}
+
+// close 停止后台协程 退出前把累积的访问次数写入数据库
+func (ac *accessCounter) close() {
+	close(ac.quit)
+	<-ac.done
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"memoryDataBase/dao"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func newTestAccessCounter(mysqlService *StudentMysqlService) *accessCounter {
+	return &accessCounter{
+		mysqlService: mysqlService,
+		options:      DefaultAccessCountOptions(),
+		deltas:       make(map[string]int64),
+		forgotten:    make(map[string]bool),
+		full:         make(chan struct{}, 1),
+	}
+}
+
+// TestAccessCounterSkipsDeletedStudents 被软删除的学生的访问次数不再增加 恢复后继续计数
+func TestAccessCounterSkipsDeletedStudents(t *testing.T) {
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t)))
+	for _, id := range []string{"s1", "s2"} {
+		err := mysqlService.InTx(func(tx *StudentMysqlService) error {
+			if err := tx.AddStudentToMysql(newTestStudent(id, "张三"), nil); err != nil {
+				return err
+			}
+			return tx.AddStudentCount(id)
+		})
+		if err != nil {
+			t.Fatalf("添加学生失败：%v", err)
+		}
+	}
+	err := mysqlService.InTx(func(tx *StudentMysqlService) error { return tx.DeleteStudent("s1", 1, nil) })
+	if err != nil {
+		t.Fatalf("删除学生失败：%v", err)
+	}
+
+	ac := newTestAccessCounter(mysqlService)
+	ac.record("s1")
+	ac.record("s2")
+	ac.record("s3")
+	ac.flush()
+	if count, err := mysqlService.GetStudentCountFromMysql("s1"); err != nil || count.Count != 1 {
+		t.Fatalf("被删除的学生的访问次数 = %+v, %v，期望保持1", count, err)
+	}
+	if count, err := mysqlService.GetStudentCountFromMysql("s2"); err != nil || count.Count != 2 {
+		t.Fatalf("没有删除的学生的访问次数 = %+v, %v，期望2", count, err)
+	}
+	if count, err := mysqlService.GetStudentCountFromMysql("s3"); err == nil && count.Count != 0 {
+		t.Fatalf("不存在的学生有访问次数：%+v", count)
+	}
+	if stats := ac.stats(); stats.Pending != 0 || stats.Failures != 0 {
+		t.Fatalf("写入后的统计 = %+v，期望没有失败和积压", stats)
+	}
+}
+
+// TestAccessCounterForgetCancelsRetry 写入期间被删除的学生 写入失败后不再放回缓冲区重试
+func TestAccessCounterForgetCancelsRetry(t *testing.T) {
+	db := newTestDB(t)
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(db))
+	for _, id := range []string{"s1", "s2"} {
+		err := mysqlService.InTx(func(tx *StudentMysqlService) error {
+			return tx.AddStudentToMysql(newTestStudent(id, "张三"), nil)
+		})
+		if err != nil {
+			t.Fatalf("添加学生失败：%v", err)
+		}
+	}
+	ac := newTestAccessCounter(mysqlService)
+	// 写入访问次数总是失败 第一次写入时模拟学生 s1 被删除
+	forgot := false
+	err := db.Callback().Raw().Before("gorm:raw").Register("test:fail_counts", func(tx *gorm.DB) {
+		if !strings.Contains(tx.Statement.SQL.String(), "insert into student_count") {
+			return
+		}
+		if !forgot {
+			forgot = true
+			ac.forget("s1")
+		}
+		tx.AddError(errors.New("模拟写入失败"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ac.record("s1")
+	ac.record("s2")
+	ac.flush()
+	ac.mu.Lock()
+	_, s1Pending := ac.deltas["s1"]
+	s2Pending := ac.deltas["s2"]
+	ac.mu.Unlock()
+	if s1Pending {
+		t.Fatalf("写入期间被删除的学生的访问次数被放回了缓冲区")
+	}
+	if s2Pending != 1 {
+		t.Fatalf("写入失败的学生的访问次数 = %d，期望放回1", s2Pending)
+	}
+
+	// 下一批写入时之前被删除的标记已经清除 新的访问正常重试
+	ac.record("s1")
+	ac.flush()
+	ac.mu.Lock()
+	s1Delta := ac.deltas["s1"]
+	ac.mu.Unlock()
+	if s1Delta != 1 {
+		t.Fatalf("下一批写入失败后 s1 的访问次数 = %d，期望放回1", s1Delta)
+	}
+}
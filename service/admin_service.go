@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"memoryDataBase/dao"
+	"memoryDataBase/hotkey"
+)
+
+// KeyPage 一次增量遍历的结果 Cursor 为0表示遍历结束
+type KeyPage struct {
+	Cursor uint64   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+// AdminService 提供运维相关的查询和操作
+type AdminService struct {
+	memoryDB       *dao.MemoryNamespaceDao
+	studentService *StudentService
+	reconciler     *ReconcileService
+}
+
+func NewAdminService(memoryDB *dao.MemoryNamespaceDao, studentService *StudentService) *AdminService {
+	return &AdminService{
+		memoryDB:       memoryDB,
+		studentService: studentService,
+		reconciler:     NewReconcileService(studentService.MysqlService, studentService.CacheService, studentService.MdbService, studentService.writePending),
+	}
+}
+
+// MemoryDBInfo 返回内存数据库各命名空间的统计信息
+func (as *AdminService) MemoryDBInfo() dao.MemoryDBInfo {
+	return as.memoryDB.Stats()
+}
+
+// LoaderStats 返回读穿透加载的统计信息 包括被合并的请求数
+func (as *AdminService) LoaderStats() LoaderStats {
+	return as.studentService.LoaderStats()
+}
+
+// WriteBehindStats 返回异步写数据库的统计信息 没有启用时返回nil
+func (as *AdminService) WriteBehindStats() *WriteBehindStats {
+	return as.studentService.WriteBehindStats()
+}
+
+// HotStudents 返回当前的热点学生
+func (as *AdminService) HotStudents() []hotkey.Entry {
+	return as.studentService.HotStudents()
+}
+
+// AccessCounterStats 返回访问计数的统计信息
+func (as *AdminService) AccessCounterStats() AccessCounterStats {
+	return as.studentService.AccessCounterStats()
+}
+
+// StartReconcile 在后台以数据库为准检查缓存和内存 已经有对账在执行时返回错误
+func (as *AdminService) StartReconcile(options ReconcileOptions) error {
+	return as.reconciler.Start(options)
+}
+
+// ReconcileStatus 返回最近一次对账的报告
+func (as *AdminService) ReconcileStatus() ReconcileStatus {
+	return as.reconciler.Status()
+}
+
+// ScanKeys 增量遍历命名空间中的键 不会长时间阻塞内存数据库
+func (as *AdminService) ScanKeys(namespace string, cursor uint64, match string, count int) (*KeyPage, error) {
+	ns, exists := as.memoryDB.Lookup(namespace)
+	if !exists {
+		return nil, fmt.Errorf("不存在命名空间：%s", namespace)
+	}
+	keys, next := ns.Scan(cursor, match, count)
+	return &KeyPage{Cursor: next, Keys: keys}, nil
+}
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"math"
+	"memoryDataBase/model"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 对账的配置
+var (
+	// ReconcileBatchSize 每批从数据库读取的学生数
+	ReconcileBatchSize = 500
+	// ReconcileMaxReportedDrifts 报告中最多列出的不一致学生数 超过后只计数
+	ReconcileMaxReportedDrifts = 1000
+)
+
+// 不一致的类型
+const (
+	DriftMismatch = "mismatch"
+	DriftOrphan   = "orphan"
+)
+
+// 数据层的名字
+const (
+	TierCache  = "cache"
+	TierMemory = "memory"
+)
+
+var errReconcileRunning = errors.New("已经有对账正在执行")
+
+// ReconcileOptions 对账的参数
+type ReconcileOptions struct {
+	// Repair 为 true 时以数据库为准修复缓存和内存
+	Repair bool `json:"repair"`
+	// DryRun 为 true 时只报告需要修复的学生 不做任何修改
+	DryRun bool `json:"dry_run"`
+	// Rate 每秒最多检查的学生数 0表示不限制
+	Rate int `json:"rate"`
+}
+
+// FieldDiff 一个字段在数据库和缓存层中的值 不存在的字段为空字符串
+type FieldDiff struct {
+	Field string `json:"field"`
+	Mysql string `json:"mysql"`
+	Tier  string `json:"tier"`
+}
+
+// StudentDrift 一个学生在某一层中的不一致 orphan 表示数据库中没有这个学生
+type StudentDrift struct {
+	StudentID string      `json:"student_id"`
+	Tier      string      `json:"tier"`
+	Kind      string      `json:"kind"`
+	Fields    []FieldDiff `json:"fields,omitempty"`
+	Repaired  bool        `json:"repaired"`
+}
+
+// TierSummary 某一层的对账结果
+type TierSummary struct {
+	Checked      int `json:"checked"`
+	Matched      int `json:"matched"`
+	Mismatched   int `json:"mismatched"`
+	Orphans      int `json:"orphans"`
+	Repaired     int `json:"repaired"`
+	RepairErrors int `json:"repair_errors"`
+	// Superseded 修复前学生已经被更新 不需要再修复的学生数
+	Superseded int `json:"superseded"`
+}
+
+// ReconcileReport 一次对账的报告 Memory 为nil表示没有检查内存(例如命令行对账)
+type ReconcileReport struct {
+	Options       ReconcileOptions `json:"options"`
+	StartedAt     time.Time        `json:"started_at"`
+	FinishedAt    time.Time        `json:"finished_at"`
+	MysqlStudents int              `json:"mysql_students"`
+	// Pending 还在异步写数据库队列中的学生数 这些学生没有检查
+	Pending   int            `json:"pending"`
+	Cache     TierSummary    `json:"cache"`
+	Memory    *TierSummary   `json:"memory,omitempty"`
+	Drifts    []StudentDrift `json:"drifts"`
+	Truncated bool           `json:"truncated"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// ReconcileStatus 最近一次对账的报告和是否正在执行
+type ReconcileStatus struct {
+	Running bool             `json:"running"`
+	Report  *ReconcileReport `json:"report"`
+}
+
+// ReconcileService 以数据库为准检查缓存和内存中的学生是否一致 并可以修复
+type ReconcileService struct {
+	mysqlService *StudentMysqlService
+	cacheService *StudentCacheService
+	// mdbService 为nil时不检查内存
+	mdbService *StudentMdbService
+	// writePending 判断学生是否在异步写数据库的队列中 为nil表示没有队列
+	writePending func(id string) bool
+
+	mu      sync.Mutex
+	running bool
+	last    *ReconcileReport
+}
+
+func NewReconcileService(mysqlService *StudentMysqlService, cacheService *StudentCacheService, mdbService *StudentMdbService,
+	writePending func(id string) bool) *ReconcileService {
+	return &ReconcileService{
+		mysqlService: mysqlService,
+		cacheService: cacheService,
+		mdbService:   mdbService,
+		writePending: writePending,
+	}
+}
+
+// Start 在后台执行一次对账 已经有对账在执行时返回错误
+func (rs *ReconcileService) Start(options ReconcileOptions) error {
+	if !rs.begin() {
+		return errReconcileRunning
+	}
+	go rs.run(options)
+	return nil
+}
+
+// Run 执行一次对账并返回报告 已经有对账在执行时返回错误
+func (rs *ReconcileService) Run(options ReconcileOptions) (*ReconcileReport, error) {
+	if !rs.begin() {
+		return nil, errReconcileRunning
+	}
+	report := rs.run(options)
+	if report.Error != "" {
+		return report, errors.New(report.Error)
+	}
+	return report, nil
+}
+
+// Status 返回最近一次对账的报告
+func (rs *ReconcileService) Status() ReconcileStatus {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return ReconcileStatus{Running: rs.running, Report: rs.last}
+}
+
+func (rs *ReconcileService) begin() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.running {
+		return false
+	}
+	rs.running = true
+	return true
+}
+
+func (rs *ReconcileService) run(options ReconcileOptions) *ReconcileReport {
+	report := &ReconcileReport{Options: options, StartedAt: time.Now(), Drifts: make([]StudentDrift, 0)}
+	if rs.mdbService != nil {
+		report.Memory = &TierSummary{}
+	}
+	log.Printf("开始对账，修复：%v，演练：%v，速率：%d/s", options.Repair, options.DryRun, options.Rate)
+	if err := rs.reconcile(options, report); err != nil {
+		log.Printf("对账失败：%v", err)
+		report.Error = err.Error()
+	}
+	report.FinishedAt = time.Now()
+	log.Printf("对账结束，检查了%d个学生，缓存不一致：%d，缓存多余：%d", report.MysqlStudents,
+		report.Cache.Mismatched, report.Cache.Orphans)
+
+	rs.mu.Lock()
+	rs.running = false
+	rs.last = report
+	rs.mu.Unlock()
+	return report
+}
+
+func (rs *ReconcileService) reconcile(options ReconcileOptions, report *ReconcileReport) error {
+	limiter := newRateLimiter(options.Rate)
+	// 每批只从缓存读取这一批学生 不需要一次性读取整个缓存
+	err := rs.mysqlService.StreamStudents(ReconcileBatchSize, func(students []*model.Student) error {
+		ids := make([]string, len(students))
+		for i, student := range students {
+			ids[i] = student.ID
+		}
+		cached, err := rs.cacheService.GetStudentsFromCache(ids)
+		if err != nil {
+			return err
+		}
+		for _, student := range students {
+			limiter.wait()
+			report.MysqlStudents++
+			if rs.pending(student.ID) {
+				report.Pending++
+				continue
+			}
+			if cachedStudent, exists := cached[student.ID]; exists {
+				rs.compare(report, &report.Cache, TierCache, student, cachedStudent, options)
+			}
+			if rs.mdbService != nil {
+				if memoryStudent, exists := rs.mdbService.PeekStudent(student.ID); exists {
+					rs.compare(report, report.Memory, TierMemory, student, memoryStudent, options)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 再分批遍历缓存和内存中的学号 向数据库确认没有的学生
+	err = rs.cacheService.ScanStudentIDs(func(ids []string) error {
+		return rs.checkOrphans(report, &report.Cache, TierCache, ids, limiter, options)
+	})
+	if err != nil {
+		return err
+	}
+	if rs.mdbService != nil {
+		ids := rs.mdbService.StudentIDs()
+		sort.Strings(ids)
+		for start := 0; start < len(ids); start += ReconcileBatchSize {
+			end := start + ReconcileBatchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			if err = rs.checkOrphans(report, report.Memory, TierMemory, ids[start:end], limiter, options); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pending 学生是否还有没有写入数据库的变更 这时以数据库为准会覆盖更新的数据 跳过这个学生
+func (rs *ReconcileService) pending(id string) bool {
+	return rs.writePending != nil && rs.writePending(id)
+}
+
+// compare 逐个字段对比数据库和某一层中的学生 不一致时按参数修复
+func (rs *ReconcileService) compare(report *ReconcileReport, summary *TierSummary, tier string, expected, actual *model.Student, options ReconcileOptions) {
+	summary.Checked++
+	diffs := diffStudent(expected, actual)
+	if len(diffs) == 0 {
+		summary.Matched++
+		return
+	}
+	summary.Mismatched++
+	drift := StudentDrift{StudentID: expected.ID, Tier: tier, Kind: DriftMismatch, Fields: diffs}
+	if options.Repair && !options.DryRun {
+		repaired, err := rs.repair(tier, expected.ID)
+		switch {
+		case err != nil:
+			log.Printf("修复%s中的学生：%s失败：%v", tier, expected.ID, err)
+			summary.RepairErrors++
+		case repaired:
+			drift.Repaired = true
+			summary.Repaired++
+		default:
+			summary.Superseded++
+		}
+	}
+	report.addDrift(drift)
+}
+
+// checkOrphans 用一次查询确认一批学生在数据库中是否存在 遍历期间新添加的学生也会被遍历到 确认数据库中确实没有后才算多余
+func (rs *ReconcileService) checkOrphans(report *ReconcileReport, summary *TierSummary, tier string, ids []string, limiter *rateLimiter, options ReconcileOptions) error {
+	candidates := make([]string, 0, len(ids))
+	for _, id := range ids {
+		limiter.wait()
+		if !rs.pending(id) {
+			candidates = append(candidates, id)
+		}
+	}
+	existing, err := rs.mysqlService.ExistingStudentIDs(candidates)
+	if err != nil {
+		return err
+	}
+	missing := make([]string, 0)
+	for _, id := range candidates {
+		if !existing[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	// 遍历到的键可能是墓碑或者已经被删除
+	present := make(map[string]bool, len(missing))
+	if tier == TierCache {
+		cached, err := rs.cacheService.GetStudentsFromCache(missing)
+		if err != nil {
+			return err
+		}
+		for id := range cached {
+			present[id] = true
+		}
+	} else {
+		for _, id := range missing {
+			_, present[id] = rs.mdbService.PeekStudent(id)
+		}
+	}
+	sort.Strings(missing)
+	for _, id := range missing {
+		if present[id] {
+			rs.reportOrphan(report, summary, tier, id, options)
+		}
+	}
+	return nil
+}
+
+func (rs *ReconcileService) reportOrphan(report *ReconcileReport, summary *TierSummary, tier string, id string, options ReconcileOptions) {
+	summary.Checked++
+	summary.Orphans++
+	drift := StudentDrift{StudentID: id, Tier: tier, Kind: DriftOrphan}
+	if options.Repair && !options.DryRun {
+		if err := rs.remove(tier, id); err != nil {
+			log.Printf("从%s中删除多余的学生：%s失败：%v", tier, id, err)
+			summary.RepairErrors++
+		} else {
+			drift.Repaired = true
+			summary.Repaired++
+		}
+	}
+	report.addDrift(drift)
+}
+
+// repair 用数据库中的学生覆盖某一层中的学生 返回 false 表示不需要修复
+// 对比用的是这一批开始时读到的学生 之后提交的写入可能已经更新了这一层 所以重新读取数据库和这一层
+// 这一层的版本号更大 或者版本号相同并且已经一致时 说明这一层已经是新的数据 不能用数据库中的旧数据覆盖
+func (rs *ReconcileService) repair(tier string, id string) (bool, error) {
+	student, err := rs.mysqlService.GetStudentFromMysql(id)
+	if err != nil {
+		// 学生在对比之后被删除 由删除自己从这一层中淘汰
+		if rs.mysqlService.StudentNotFoundErr(id, err) {
+			return false, nil
+		}
+		return false, err
+	}
+	var current *model.Student
+	if tier == TierCache {
+		current, _ = rs.cacheService.GetStudentFromCache(id)
+	} else {
+		current, _ = rs.mdbService.PeekStudent(id)
+	}
+	if current != nil && (current.Version > student.Version ||
+		current.Version == student.Version && len(diffStudent(student, current)) == 0) {
+		return false, nil
+	}
+	if tier == TierCache {
+		return true, rs.cacheService.AddStudent(student)
+	}
+	rs.mdbService.AddStudent(student)
+	return true, nil
+}
+
+func (rs *ReconcileService) remove(tier string, id string) error {
+	if tier == TierCache {
+		err := rs.cacheService.DeleteStudent(id)
+		if isStudentNotFound(id, err) {
+			return nil
+		}
+		return err
+	}
+	rs.mdbService.Evict(id)
+	return nil
+}
+
+func (report *ReconcileReport) addDrift(drift StudentDrift) {
+	if len(report.Drifts) >= ReconcileMaxReportedDrifts {
+		report.Truncated = true
+		return
+	}
+	report.Drifts = append(report.Drifts, drift)
+}
+
+// diffStudent 返回两个学生不同的字段 成绩按学科逐个对比
+func diffStudent(expected, actual *model.Student) []FieldDiff {
+	var diffs []FieldDiff
+	if expected.Name != actual.Name {
+		diffs = append(diffs, FieldDiff{Field: "name", Mysql: expected.Name, Tier: actual.Name})
+	}
+	if expected.Gender != actual.Gender {
+		diffs = append(diffs, FieldDiff{Field: "gender", Mysql: expected.Gender, Tier: actual.Gender})
+	}
+	if expected.Class != actual.Class {
+		diffs = append(diffs, FieldDiff{Field: "class", Mysql: expected.Class, Tier: actual.Class})
+	}
+	if expected.Version != actual.Version {
+		diffs = append(diffs, FieldDiff{Field: "version", Mysql: strconv.FormatInt(expected.Version, 10), Tier: strconv.FormatInt(actual.Version, 10)})
+	}
+	subjects := make(map[string]bool)
+	for subject := range expected.Grades {
+		subjects[subject] = true
+	}
+	for subject := range actual.Grades {
+		subjects[subject] = true
+	}
+	for _, subject := range sortedKeys(subjects) {
+		expectedScore, inExpected := expected.Grades[subject]
+		actualScore, inActual := actual.Grades[subject]
+		if inExpected == inActual && math.Abs(expectedScore-actualScore) < 1e-9 {
+			continue
+		}
+		diff := FieldDiff{Field: "grades." + subject}
+		if inExpected {
+			diff.Mysql = strconv.FormatFloat(expectedScore, 'f', -1, 64)
+		}
+		if inActual {
+			diff.Tier = strconv.FormatFloat(actualScore, 'f', -1, 64)
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rateLimiter 把检查的速度限制在每秒 rate 个以内
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	if rate <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(rate), next: time.Now()}
+}
+
+func (rl *rateLimiter) wait() {
+	if rl.interval == 0 {
+		return
+	}
+	if delay := time.Until(rl.next); delay > 0 {
+		time.Sleep(delay)
+	}
+	rl.next = rl.next.Add(rl.interval)
+	// 长时间没有调用时不要攒下太多额度
+	if now := time.Now(); rl.next.Before(now.Add(-time.Second)) {
+		rl.next = now
+	}
+}
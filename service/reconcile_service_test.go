@@ -0,0 +1,128 @@
+package service
+
+import (
+	"memoryDataBase/dao"
+	"memoryDataBase/model"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// TestReconcileSkipsPendingWrites 对账按批对比并修复缓存和内存 跳过还在异步写数据库队列中的学生
+func TestReconcileSkipsPendingWrites(t *testing.T) {
+	batchSize := ReconcileBatchSize
+	ReconcileBatchSize = 2
+	t.Cleanup(func() { ReconcileBatchSize = batchSize })
+
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t)))
+	cacheService := NewStudentCacheService(dao.NewStudentLocalCacheDao(dao.DefaultStudentCacheOptions()))
+	mdbService := NewStudentMdbService(dao.NewMemoryNamespaceDao(), DefaultNegativeCacheTTL)
+	for _, id := range []string{"s1", "s2", "s3", "s4"} {
+		student := newTestStudent(id, "张三")
+		student.Version = 1
+		err := mysqlService.InTx(func(tx *StudentMysqlService) error {
+			return tx.AddStudentToMysql(student.Copy(), nil)
+		})
+		if err != nil {
+			t.Fatalf("向数据库添加学生失败：%v", err)
+		}
+		cacheService.AddStudent(student.Copy())
+		mdbService.AddStudent(student.Copy())
+	}
+	// s2 在缓存中不一致 s4 的更新还没有写入数据库
+	cacheService.AddStudent(&model.Student{ID: "s2", Name: "李四", Version: 1})
+	cacheService.AddStudent(&model.Student{ID: "s4", Name: "王五", Version: 2})
+	mdbService.AddStudent(&model.Student{ID: "s4", Name: "王五", Version: 2})
+	// s5 只在缓存中 s6 是还没有写入数据库的新学生
+	cacheService.AddStudent(newTestStudent("s5", "赵六"))
+	cacheService.AddStudent(newTestStudent("s6", "孙七"))
+	mdbService.AddStudent(newTestStudent("s6", "孙七"))
+
+	pending := map[string]bool{"s4": true, "s6": true}
+	rs := NewReconcileService(mysqlService, cacheService, mdbService, func(id string) bool { return pending[id] })
+	report, err := rs.Run(ReconcileOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("对账失败：%v", err)
+	}
+	if report.MysqlStudents != 4 || report.Pending != 1 {
+		t.Fatalf("检查了%d个学生，跳过%d个，期望4和1", report.MysqlStudents, report.Pending)
+	}
+	if report.Cache.Mismatched != 1 || report.Cache.Orphans != 1 || report.Cache.Repaired != 2 {
+		t.Fatalf("缓存的对账结果 = %+v，期望一个不一致和一个多余并且都已修复", report.Cache)
+	}
+	if report.Memory.Mismatched != 0 || report.Memory.Orphans != 0 {
+		t.Fatalf("内存的对账结果 = %+v，期望没有不一致", *report.Memory)
+	}
+
+	if student, _ := cacheService.GetStudentFromCache("s2"); student == nil || student.Name != "张三" {
+		t.Fatalf("缓存中的s2 = %+v，期望修复为数据库中的学生", student)
+	}
+	if _, err = cacheService.GetStudentFromCache("s5"); err == nil {
+		t.Fatalf("缓存中多余的学生s5没有删除")
+	}
+	for _, id := range []string{"s4", "s6"} {
+		if _, err = cacheService.GetStudentFromCache(id); err != nil {
+			t.Fatalf("队列中的学生%s被对账修改了：%v", id, err)
+		}
+	}
+	if student, _ := cacheService.GetStudentFromCache("s4"); student.Name != "王五" {
+		t.Fatalf("队列中的学生s4被数据库中的旧数据覆盖：%+v", student)
+	}
+}
+
+// TestReconcileRepairAfterConcurrentWrite 对比之后修复之前提交的写入不会被这一批开始时读到的旧数据覆盖
+func TestReconcileRepairAfterConcurrentWrite(t *testing.T) {
+	db := newTestDB(t)
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(db))
+	cacheService := NewStudentCacheService(dao.NewStudentLocalCacheDao(dao.DefaultStudentCacheOptions()))
+	for _, id := range []string{"s1", "s2"} {
+		student := newTestStudent(id, "张三")
+		student.Version = 1
+		err := mysqlService.InTx(func(tx *StudentMysqlService) error {
+			return tx.AddStudentToMysql(student.Copy(), nil)
+		})
+		if err != nil {
+			t.Fatalf("向数据库添加学生失败：%v", err)
+		}
+		// 缓存中的两个学生都和数据库不一致
+		cacheService.AddStudent(&model.Student{ID: id, Name: "李四", Version: 1})
+	}
+
+	// 修复第一个学生之前 两个学生的更新都提交了 s1 的更新已经写入缓存 s2 的还没有
+	written := false
+	err := db.Callback().Row().Before("gorm:row").Register("test:concurrent_write", func(tx *gorm.DB) {
+		if written || !strings.Contains(tx.Statement.SQL.String(), "from student where id = ?") {
+			return
+		}
+		written = true
+		if err := db.Exec("update student set name = ?, version = 2 where id in (?, ?)", "王五", "s1", "s2").Error; err != nil {
+			t.Errorf("更新学生失败：%v", err)
+		}
+		updated := newTestStudent("s1", "王五")
+		updated.Version = 2
+		cacheService.AddStudent(updated)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Callback().Row().Remove("test:concurrent_write") })
+
+	rs := NewReconcileService(mysqlService, cacheService, nil, nil)
+	report, err := rs.Run(ReconcileOptions{Repair: true})
+	if err != nil {
+		t.Fatalf("对账失败：%v", err)
+	}
+	if !written {
+		t.Fatalf("修复时没有重新读取数据库")
+	}
+	if report.Cache.Mismatched != 2 || report.Cache.Superseded != 1 || report.Cache.Repaired != 1 {
+		t.Fatalf("缓存的对账结果 = %+v，期望两个不一致 一个已经被更新 一个修复", report.Cache)
+	}
+	for _, id := range []string{"s1", "s2"} {
+		student, err := cacheService.GetStudentFromCache(id)
+		if err != nil || student.Name != "王五" || student.Version != 2 {
+			t.Fatalf("缓存中的%s = %+v, %v，期望第2版的王五", id, student, err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"memoryDataBase/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 审计记录中的字段名 成绩按学科分别记录为 grades.学科
+const (
+	auditFieldName       = "name"
+	auditFieldGender     = "gender"
+	auditFieldClass      = "class"
+	auditFieldExpiration = "expiration"
+	auditFieldVersion    = "version"
+	auditGradePrefix     = "grades."
+)
+
+// auditValues 把学生转换为审计记录中的字段和值 学生为nil时没有任何字段
+func auditValues(student *model.Student) map[string]string {
+	values := make(map[string]string)
+	if student == nil {
+		return values
+	}
+	values[auditFieldName] = student.Name
+	values[auditFieldGender] = student.Gender
+	values[auditFieldClass] = student.Class
+	values[auditFieldExpiration] = strconv.FormatInt(student.Expiration, 10)
+	values[auditFieldVersion] = strconv.FormatInt(student.Version, 10)
+	for subject, score := range student.Grades {
+		values[auditGradePrefix+subject] = strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	return values
+}
+
+// studentFromAuditValues 把审计记录中的字段和值转换回学生 是 auditValues 的逆操作
+func studentFromAuditValues(id string, values map[string]string) (*model.Student, error) {
+	student := &model.Student{
+		ID:     id,
+		Name:   values[auditFieldName],
+		Gender: values[auditFieldGender],
+		Class:  values[auditFieldClass],
+		Grades: make(map[string]float64),
+	}
+	for field, value := range values {
+		switch {
+		case field == auditFieldExpiration:
+			expiration, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("学生：%s审计记录中的过期时间：%s格式错误", id, value)
+			}
+			student.Expiration = expiration
+		case field == auditFieldVersion:
+			version, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("学生：%s审计记录中的版本号：%s格式错误", id, value)
+			}
+			student.Version = version
+		case strings.HasPrefix(field, auditGradePrefix):
+			score, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("学生：%s审计记录中的成绩：%s格式错误", id, value)
+			}
+			student.Grades[strings.TrimPrefix(field, auditGradePrefix)] = score
+		}
+	}
+	return student, nil
+}
+
+// diffAudit 对比变更前后的学生 每个值发生变化的字段生成一条审计记录 before 或 after 为nil表示添加或删除
+func diffAudit(id string, action string, before, after *model.Student, audit model.AuditContext, at time.Time) []*model.StudentAudit {
+	oldValues := auditValues(before)
+	newValues := auditValues(after)
+	fields := make(map[string]bool, len(oldValues)+len(newValues))
+	for field := range oldValues {
+		fields[field] = true
+	}
+	for field := range newValues {
+		fields[field] = true
+	}
+	version := int64(0)
+	if after != nil {
+		version = after.Version
+	} else if before != nil {
+		version = before.Version
+	}
+
+	var entries []*model.StudentAudit
+	for _, field := range sortedKeys(fields) {
+		oldValue, hadOld := oldValues[field]
+		newValue, hasNew := newValues[field]
+		if hadOld && hasNew && oldValue == newValue {
+			continue
+		}
+		entry := &model.StudentAudit{
+			StudentId: id,
+			Action:    action,
+			Field:     field,
+			Version:   version,
+			Actor:     audit.Actor,
+			RequestId: audit.RequestID,
+			CreatedAt: at,
+		}
+		if hadOld {
+			entry.OldValue = &oldValue
+		}
+		if hasNew {
+			entry.NewValue = &newValue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// RecordChange 写入一次变更的审计记录 需要和变更在同一个 InTx 中调用
+// 时间统一使用 UTC 按时间查询时不受数据库时区设置的影响
+func (sms *StudentMysqlService) RecordChange(action string, before, after *model.Student, audit model.AuditContext) error {
+	return sms.recordChangeAt(action, before, after, audit, time.Now().UTC())
+}
+
+// recordChangeAt 写入一次变更的审计记录 at 是变更发生的时间 异步写数据库时是请求到达的时间
+func (sms *StudentMysqlService) recordChangeAt(action string, before, after *model.Student, audit model.AuditContext, at time.Time) error {
+	id := ""
+	if after != nil {
+		id = after.ID
+	} else if before != nil {
+		id = before.ID
+	}
+	entries := diffAudit(id, action, before, after, audit, at.UTC())
+	if err := sms.mysqlDao.AddAuditEntries(entries); err != nil {
+		log.Printf("写入学生：%s的审计记录失败：%v", id, err)
+		return err
+	}
+	return nil
+}
+
+// GetStudentHistory 按时间倒序分页返回学生的审计记录和记录总数 page 从1开始
+func (sms *StudentMysqlService) GetStudentHistory(id string, page, size int) ([]*model.StudentAudit, int64, error) {
+	total, err := sms.mysqlDao.CountStudentAudits(id)
+	if err != nil {
+		log.Printf("统计学生：%s的审计记录失败：%v", id, err)
+		return nil, 0, err
+	}
+	entries, err := sms.mysqlDao.GetStudentAudits(id, (page-1)*size, size)
+	if err != nil {
+		log.Printf("查询学生：%s的审计记录失败：%v", id, err)
+		return nil, 0, err
+	}
+	if entries == nil {
+		entries = make([]*model.StudentAudit, 0)
+	}
+	return entries, total, nil
+}
+
+// GetStudentAsOf 还原学生在 asOf 时的状态 不存在时返回找不到学生
+// 从数据库中的当前状态开始 按写入顺序倒序撤销 asOf 之后的每条审计记录 把字段恢复为旧值
+// 没有审计表之前写入的状态无法还原 会按照当前状态返回 开始记录过期时间之前删除的学生过期时间为0
+func (sms *StudentMysqlService) GetStudentAsOf(id string, asOf time.Time) (*model.Student, error) {
+	current, err := sms.GetStudentFromMysql(id)
+	if err != nil && !sms.StudentNotFoundErr(id, err) {
+		return nil, err
+	}
+	// 学生已经被删除时从空状态开始 删除的审计记录中保存了删除前的所有字段
+	values := auditValues(current)
+	entries, err := sms.mysqlDao.GetStudentAuditsAfter(id, asOf.UTC())
+	if err != nil {
+		log.Printf("查询学生：%s的审计记录失败：%v", id, err)
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.OldValue == nil {
+			delete(values, entry.Field)
+		} else {
+			values[entry.Field] = *entry.OldValue
+		}
+	}
+	if len(values) == 0 {
+		return nil, studentNotFound(id)
+	}
+	log.Printf("还原学生：%s在%s时的状态 撤销了%d条审计记录", id, asOf.Format(time.RFC3339), len(entries))
+	return studentFromAuditValues(id, values)
+}
+
+// StudentHistory 一页审计记录
+type StudentHistory struct {
+	Total int64                 `json:"total"`
+	Page  int                   `json:"page"`
+	Size  int                   `json:"size"`
+	Items []*model.StudentAudit `json:"items"`
+}
+
+// GetStudentHistory 按时间倒序分页返回学生的变更记录 学生删除后仍然可以查询
+func (ss *StudentService) GetStudentHistory(id string, page, size int) (*StudentHistory, error) {
+	items, total, err := ss.MysqlService.GetStudentHistory(id, page, size)
+	if err != nil {
+		return nil, err
+	}
+	return &StudentHistory{Total: total, Page: page, Size: size, Items: items}, nil
+}
+
+// GetStudentAsOf 查询学生在 asOf 时的状态 内存和缓存只保存当前状态 直接从数据库的审计记录还原
+// 异步写数据库时队列中还没有写入的变更不会出现在结果中
+func (ss *StudentService) GetStudentAsOf(id string, asOf time.Time) (*model.Student, error) {
+	return ss.MysqlService.GetStudentAsOf(id, asOf)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"memoryDataBase/dao"
+	"memoryDataBase/model"
+	"testing"
+	"time"
+)
+
+// TestGetStudentAsOf 按审计记录还原学生在各个时刻的所有字段 包括过期时间
+func TestGetStudentAsOf(t *testing.T) {
+	db := newTestDB(t)
+	sms := NewStudentMysqlService(dao.NewStudentMysqlDao(db))
+	audit := &model.AuditContext{Actor: "tester"}
+	student := newTestStudent("s1", "张三")
+	student.Expiration = 3600
+	base := time.Now().UTC().Add(-time.Hour)
+	steps := []func(tx *StudentMysqlService) error{
+		func(tx *StudentMysqlService) error {
+			return tx.AddStudentToMysql(student.Copy(), audit)
+		},
+		func(tx *StudentMysqlService) error {
+			_, err := tx.UpdateStudent(&model.Student{ID: "s1", Name: "李四", Grades: map[string]float64{"数学": 60}, Version: 1}, audit)
+			return err
+		},
+		func(tx *StudentMysqlService) error {
+			return tx.DeleteStudent("s1", 2, audit)
+		},
+	}
+	for i, step := range steps {
+		if err := sms.InTx(step); err != nil {
+			t.Fatalf("第%d步失败：%v", i+1, err)
+		}
+		// 审计记录按时间查询 把每一步的记录移到不同的时刻
+		at := base.Add(time.Duration(i+1) * 10 * time.Minute)
+		if err := db.Exec("update student_audit set created_at = ? where created_at > ?", at, base.Add(30*time.Minute)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		offset  time.Duration
+		name    string
+		math    float64
+		version int64
+	}{
+		{offset: 15 * time.Minute, name: "张三", math: 90, version: 1},
+		{offset: 25 * time.Minute, name: "李四", math: 60, version: 2},
+	} {
+		asOf, err := sms.GetStudentAsOf("s1", base.Add(tc.offset))
+		if err != nil {
+			t.Fatalf("还原学生在%v时的状态失败：%v", tc.offset, err)
+		}
+		if asOf.Name != tc.name || asOf.Grades["数学"] != tc.math || asOf.Version != tc.version ||
+			asOf.Expiration != 3600 || asOf.Gender != "男" || asOf.Class != "一班" {
+			t.Fatalf("学生在%v时的状态 = %+v", tc.offset, asOf)
+		}
+	}
+	for _, offset := range []time.Duration{5 * time.Minute, 35 * time.Minute} {
+		if _, err := sms.GetStudentAsOf("s1", base.Add(offset)); !isStudentNotFound("s1", err) {
+			t.Fatalf("学生在%v时不存在，返回 %v", offset, err)
+		}
+	}
+}
@@ -7,10 +7,10 @@ import (
 )
 
 type StudentCacheService struct {
-	cacheDao *dao.StudentCacheDao
+	cacheDao dao.StudentCache
 }
 
-func NewStudentCacheService(cacheDao *dao.StudentCacheDao) *StudentCacheService {
+func NewStudentCacheService(cacheDao dao.StudentCache) *StudentCacheService {
 	return &StudentCacheService{
 		cacheDao: cacheDao,
 	}
@@ -72,6 +72,26 @@ func (scs *StudentCacheService) DeleteStudent(id string) error {
 	return nil
 }
 
+// EvictLocal 其他实例修改学生后淘汰本实例独有的缓存 共享的缓存已经由发起变更的实例更新
+func (scs *StudentCacheService) EvictLocal(id string) {
+	if scs.cacheDao.Shared() {
+		return
+	}
+	if err := scs.cacheDao.DeleteStudent(id); err != nil {
+		log.Printf("从本地缓存淘汰学生：%s失败：%v", id, err)
+	}
+}
+
+// FlushLocal 可能丢失了失效通知时清空本实例独有的缓存
+func (scs *StudentCacheService) FlushLocal() {
+	if scs.cacheDao.Shared() {
+		return
+	}
+	if err := scs.cacheDao.ReLoadCacheData(nil); err != nil {
+		log.Printf("清空本地缓存失败：%v", err)
+	}
+}
+
 func (scs *StudentCacheService) ReLoadCacheData(students []*model.Student) error {
 	return scs.cacheDao.ReLoadCacheData(students)
 }
@@ -79,3 +99,18 @@ func (scs *StudentCacheService) ReLoadCacheData(students []*model.Student) error
 func (scs *StudentCacheService) GetAllStudentsFromCache() ([]*model.Student, error) {
 	return scs.cacheDao.GetAllStudents()
 }
+
+// GetStudentsFromCache 批量获取学生 不在缓存中的学生不出现在结果中
+func (scs *StudentCacheService) GetStudentsFromCache(ids []string) (map[string]*model.Student, error) {
+	students, err := scs.cacheDao.GetStudents(ids)
+	if err != nil {
+		log.Printf("从缓存批量查找%d个学生失败：%v", len(ids), err)
+		return nil, err
+	}
+	return students, nil
+}
+
+// ScanStudentIDs 分批遍历缓存中的学号
+func (scs *StudentCacheService) ScanStudentIDs(fn func(ids []string) error) error {
+	return scs.cacheDao.ScanStudentIDs(fn)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"memoryDataBase/bloom"
+	"strings"
+	"sync/atomic"
+)
+
+// StudentFilterOptions 学号布隆过滤器的配置
+type StudentFilterOptions struct {
+	// Enabled 是否使用布隆过滤器拒绝不存在的学号
+	Enabled bool
+	// ExpectedItems 预计的学生数量
+	ExpectedItems uint
+	// FalsePositiveRate 可以接受的误判率
+	FalsePositiveRate float64
+}
+
+// DefaultStudentFilterOptions 返回学号布隆过滤器的默认配置 默认不启用
+func DefaultStudentFilterOptions() StudentFilterOptions {
+	return StudentFilterOptions{ExpectedItems: 1000000, FalsePositiveRate: 0.01}
+}
+
+// serviceSnapshot 写入 Raft 快照的服务状态
+type serviceSnapshot struct {
+	StudentFilter []byte `json:"student_filter,omitempty"`
+}
+
+// studentNotFound 返回和内存层一致的找不到学生的错误 上层据此判断是否继续查找
+func studentNotFound(id string) error {
+	return errors.New(fmt.Sprintf("找不到学号为：%s的学生", id))
+}
+
+// isStudentNotFound 判断错误是不是内存或缓存中找不到学生
+func isStudentNotFound(id string, err error) bool {
+	return err != nil && strings.Contains(err.Error(), fmt.Sprintf("找不到学号为：%s的学生", id))
+}
+
+// rejectMissingStudent 判断学号是否可以确定不存在 可以确定时不再访问缓存和数据库
+func (ss *StudentService) rejectMissingStudent(id string) bool {
+	if ss.MdbService.IsMissing(id) {
+		atomic.AddInt64(&ss.loader.negativeHits, 1)
+		log.Printf("学号：%s最近被确认过不存在", id)
+		return true
+	}
+	if ss.studentFilter != nil && atomic.LoadInt32(&ss.filterReady) == 1 && !ss.studentFilter.MayContain(id) {
+		atomic.AddInt64(&ss.loader.rejections, 1)
+		ss.MdbService.MarkMissing(id)
+		log.Printf("布隆过滤器中不存在学号：%s", id)
+		return true
+	}
+	return false
+}
+
+// rememberStudent 学生添加成功后加入布隆过滤器并清除不存在的记录
+func (ss *StudentService) rememberStudent(id string) {
+	if ss.studentFilter != nil {
+		ss.filterMu.Lock()
+		ss.studentFilter.Add(id)
+		if ss.filterRebuild != nil {
+			ss.filterRebuild.Add(id)
+		}
+		ss.filterMu.Unlock()
+	}
+	ss.MdbService.ClearMissing(id)
+}
+
+// forgetStudent 学生删除成功后从布隆过滤器中移除
+// 正在重新构建时不从新的过滤器中移除 构建读到的学号可能还没有加入 多出的学号只会让请求多查一次数据库
+func (ss *StudentService) forgetStudent(id string) {
+	if ss.studentFilter != nil {
+		ss.studentFilter.Remove(id)
+	}
+}
+
+// BuildStudentFilter 启动时用数据库中的所有学号和还没有写入数据库的学号构建布隆过滤器
+// 快照之后添加的学生不在快照的过滤器中 所以从快照恢复后也要重新构建 恢复的过滤器只在构建期间使用
+// 构建期间添加的学生同时写入新的过滤器 构建完成后替换正在使用的过滤器
+func (ss *StudentService) BuildStudentFilter() error {
+	if ss.studentFilter == nil {
+		return nil
+	}
+	fresh := bloom.New(ss.options.Filter.ExpectedItems, ss.options.Filter.FalsePositiveRate)
+	ss.filterMu.Lock()
+	ss.filterRebuild = fresh
+	ss.filterMu.Unlock()
+	ids, err := ss.MysqlService.GetAllStudentIDs()
+	if err != nil {
+		ss.filterMu.Lock()
+		ss.filterRebuild = nil
+		ss.filterMu.Unlock()
+		// 快照中的过滤器可能缺少之后添加的学生 不能用来拒绝请求
+		atomic.StoreInt32(&ss.filterReady, 0)
+		return err
+	}
+	if ss.writeBehind != nil {
+		ids = append(ids, ss.writeBehind.pendingStudentIDs()...)
+	}
+	for _, id := range ids {
+		fresh.Add(id)
+	}
+	ss.filterMu.Lock()
+	ss.studentFilter.Replace(fresh)
+	ss.filterRebuild = nil
+	ss.filterMu.Unlock()
+	atomic.StoreInt32(&ss.filterReady, 1)
+	log.Printf("已用%d个学号构建布隆过滤器", len(ids))
+	return nil
+}
+
+// SnapshotState 导出需要写入 Raft 快照的状态
+func (ss *StudentService) SnapshotState() ([]byte, error) {
+	var snapshot serviceSnapshot
+	if ss.studentFilter != nil && atomic.LoadInt32(&ss.filterReady) == 1 {
+		data, err := ss.studentFilter.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		snapshot.StudentFilter = data
+	}
+	return json.Marshal(snapshot)
+}
+
+// RestoreState 从 Raft 快照中恢复状态
+// 快照中的布隆过滤器只用于启动时预热 已经从数据库构建过时忽略 避免用旧的过滤器覆盖
+func (ss *StudentService) RestoreState(data []byte) error {
+	var snapshot serviceSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("解析快照失败：%w", err)
+	}
+	if ss.studentFilter != nil && len(snapshot.StudentFilter) > 0 && atomic.LoadInt32(&ss.filterReady) == 0 {
+		if err := ss.studentFilter.UnmarshalBinary(snapshot.StudentFilter); err != nil {
+			return fmt.Errorf("从快照恢复布隆过滤器失败：%w", err)
+		}
+		atomic.StoreInt32(&ss.filterReady, 1)
+		log.Printf("已从快照恢复布隆过滤器，等待从数据库重新构建")
+	}
+	return nil
+}
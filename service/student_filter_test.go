@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/json"
+	"memoryDataBase/bloom"
+	"testing"
+)
+
+// TestBuildStudentFilterAfterSnapshot 从快照恢复后仍然从数据库重新构建 快照之后添加的学生不会被拒绝
+func TestBuildStudentFilterAfterSnapshot(t *testing.T) {
+	options := DefaultStudentServiceOptions()
+	options.Filter.Enabled = true
+	ss := newTestStudentServiceWithOptions(t, newTestDB(t), "node1", options)
+
+	// 快照中只有 s1
+	old := bloom.New(options.Filter.ExpectedItems, options.Filter.FalsePositiveRate)
+	old.Add("s1")
+	data, err := old.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := json.Marshal(serviceSnapshot{StudentFilter: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.RestoreState(snapshot); err != nil {
+		t.Fatalf("恢复快照失败：%v", err)
+	}
+
+	// 快照之后由其他节点写入数据库的学生
+	err = ss.MysqlService.InTx(func(tx *StudentMysqlService) error {
+		return tx.AddStudentToMysql(newTestStudent("s2", "李四"), nil)
+	})
+	if err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	if !ss.rejectMissingStudent("s2") {
+		t.Skip("恢复的过滤器误判 s2 存在")
+	}
+	ss.MdbService.ClearMissing("s2")
+
+	if err = ss.BuildStudentFilter(); err != nil {
+		t.Fatalf("构建布隆过滤器失败：%v", err)
+	}
+	student, err := ss.GetStudent("s2")
+	if err != nil || student.Name != "李四" {
+		t.Fatalf("GetStudent = %+v, %v，期望李四", student, err)
+	}
+
+	// 构建之后再恢复旧的快照不会覆盖从数据库构建的过滤器
+	if err = ss.RestoreState(snapshot); err != nil {
+		t.Fatalf("恢复快照失败：%v", err)
+	}
+	if !ss.studentFilter.MayContain("s2") {
+		t.Fatalf("旧的快照覆盖了从数据库构建的过滤器")
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"log"
+	"memoryDataBase/hotkey"
+	"memoryDataBase/model"
+	"time"
+)
+
+// HotStudentsOptions 热点学生的配置
+type HotStudentsOptions struct {
+	// K 统计的热点学生数 也是重新加载缓存和启动时加载到内存的学生数
+	K int
+	// HalfLife 访问次数衰减一半的时间
+	HalfLife time.Duration
+	// MinHits 衰减后的总访问次数达到这个值后才使用统计出的热点 刚启动时几次访问统计出的热点不可靠
+	MinHits int
+}
+
+// DefaultHotStudentsOptions 返回热点学生的默认配置
+func DefaultHotStudentsOptions() HotStudentsOptions {
+	return HotStudentsOptions{K: 10, HalfLife: 10 * time.Minute, MinHits: 100}
+}
+
+// HotStudents 返回当前的热点学生和衰减后的估计访问次数
+func (ss *StudentService) HotStudents() []hotkey.Entry {
+	return ss.hotStudents.Top()
+}
+
+// hotStudentsFromStore 从数据库读取当前的热点学生 结果会替换整个缓存
+// 总访问次数不到 MinHits 时(例如刚启动) 使用数据库中累计访问次数最多的学生
+// 统计出的热点不足 K 个时 用数据库中累计访问次数最多的学生补足
+func (ss *StudentService) hotStudentsFromStore() ([]*model.Student, error) {
+	var ids []string
+	if total := ss.hotStudents.Total(); total >= float64(ss.options.HotStudents.MinHits) {
+		ids = ss.hotStudents.Keys()
+	} else {
+		log.Printf("统计到的访问次数：%.0f少于%d，使用数据库中访问次数最多的学生", total, ss.options.HotStudents.MinHits)
+	}
+	if len(ids) >= ss.options.HotStudents.K {
+		return ss.MysqlService.GetStudentsFromMysql(ids)
+	}
+	stored, err := ss.MysqlService.GetHotStudentsFromMysql(ss.options.HotStudents.K)
+	if err != nil || len(ids) == 0 {
+		return stored, err
+	}
+	students, err := ss.MysqlService.GetStudentsFromMysql(ids)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(students))
+	for _, student := range students {
+		seen[student.ID] = true
+	}
+	for _, student := range stored {
+		if len(students) >= ss.options.HotStudents.K {
+			break
+		}
+		if !seen[student.ID] {
+			students = append(students, student)
+		}
+	}
+	return students, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+)
+
+// TestHotStudentsFromStore 访问次数太少时使用数据库中的热点 统计出的热点不足 K 个时用数据库中的热点补足
+func TestHotStudentsFromStore(t *testing.T) {
+	options := DefaultStudentServiceOptions()
+	options.HotStudents.K, options.HotStudents.MinHits = 3, 5
+	ss := newTestStudentServiceWithOptions(t, newTestDB(t), "node1", options)
+	for _, id := range []string{"s1", "s2", "s3", "s4"} {
+		err := ss.MysqlService.InTx(func(tx *StudentMysqlService) error {
+			return tx.AddStudentToMysql(newTestStudent(id, "张三"), nil)
+		})
+		if err != nil {
+			t.Fatalf("添加学生失败：%v", err)
+		}
+	}
+	if err := ss.MysqlService.AddStudentCounts(map[string]int64{"s1": 30, "s2": 20, "s3": 10}); err != nil {
+		t.Fatalf("写入访问次数失败：%v", err)
+	}
+
+	ss.hotStudents.Add("s4")
+	assertHotIDs(t, ss, []string{"s1", "s2", "s3"})
+
+	for i := 0; i < 5; i++ {
+		ss.hotStudents.Add("s4")
+	}
+	assertHotIDs(t, ss, []string{"s4", "s1", "s2"})
+}
+
+func assertHotIDs(t *testing.T, ss *StudentService, want []string) {
+	t.Helper()
+	students, err := ss.hotStudentsFromStore()
+	if err != nil {
+		t.Fatalf("读取热点学生失败：%v", err)
+	}
+	ids := make([]string, len(students))
+	for i, student := range students {
+		ids[i] = student.ID
+	}
+	if len(ids) != len(want) {
+		t.Fatalf("热点学生 = %v，期望 %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("热点学生 = %v，期望 %v", ids, want)
+		}
+	}
+}
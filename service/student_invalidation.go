@@ -0,0 +1,77 @@
+package service
+
+import (
+	"log"
+	"memoryDataBase/bus"
+	"memoryDataBase/model"
+	"sync/atomic"
+	"time"
+)
+
+// EnableInvalidation 启用跨实例的失效通知 本实例的学生变更会广播给其他实例 其他实例的变更会淘汰本实例内存中的学生
+func (ss *StudentService) EnableInvalidation(invalidationBus bus.InvalidationBus) error {
+	base := time.Now().UnixNano()
+	atomic.StoreInt64(&ss.invalidationBase, base)
+	atomic.StoreInt64(&ss.invalidationSeq, base)
+	if err := invalidationBus.Subscribe(ss.handleInvalidation, ss.resyncInvalidation); err != nil {
+		return err
+	}
+	ss.invalidationBus = invalidationBus
+	log.Printf("已启用跨实例的失效通知")
+	return nil
+}
+
+// publishChange 广播学生变更 name 是变更后学生的姓名 删除时为空
+// seq 必须在决定写入顺序的锁或事务中确定 广播在写入之后 同一个学生的两次变更广播的顺序可能和写入的顺序相反
+func (ss *StudentService) publishChange(op string, id string, name string, seq int64) {
+	if ss.invalidationBus == nil {
+		return
+	}
+	event := bus.Event{StudentID: id, Op: op, Name: name, Seq: seq}
+	if err := ss.invalidationBus.Publish(event); err != nil {
+		log.Printf("广播学生：%s的变更失败：%v", id, err)
+	}
+}
+
+// outboxSeq 同步写数据库时用发件箱变更的编号作为序号
+// 同一个学生的事务持有该学生的行锁依次提交 发件箱变更在事务中写入 编号的顺序就是提交的顺序
+func (ss *StudentService) outboxSeq(event *model.OutboxEvent) int64 {
+	return atomic.LoadInt64(&ss.invalidationBase) + int64(event.ID)
+}
+
+// nextInvalidationSeq 异步写数据库时的序号 调用方持有 writeMu 序号的顺序就是写入日志的顺序
+func (ss *StudentService) nextInvalidationSeq() int64 {
+	return atomic.AddInt64(&ss.invalidationSeq, 1)
+}
+
+// resyncInvalidation 可能丢失了失效通知时淘汰内存和本实例独有的缓存中的所有学生 并从数据库重新构建布隆过滤器和姓名索引
+// 丢失的可能是其他实例添加学生的通知 重新构建完成之前布隆过滤器不再拒绝请求
+func (ss *StudentService) resyncInvalidation() {
+	atomic.StoreInt32(&ss.filterReady, 0)
+	ss.MdbService.FlushStudents()
+	ss.CacheService.FlushLocal()
+	if err := ss.BuildStudentFilter(); err != nil {
+		log.Printf("重新同步时构建布隆过滤器失败，暂时不用它拒绝请求：%v", err)
+	}
+	if err := ss.BuildNameIndex(); err != nil {
+		log.Printf("重新同步时构建姓名索引失败：%v", err)
+	}
+}
+
+// handleInvalidation 处理其他实例的学生变更 从内存和本实例独有的缓存中淘汰该学生 下次读取时从共享的缓存或数据库重新加载
+// 姓名索引在订阅的协程中按收到的顺序更新 同一个实例先更新后删除的学生不会被重新加入索引
+func (ss *StudentService) handleInvalidation(event bus.Event) {
+	switch event.Op {
+	case bus.OpAdd:
+		ss.rememberStudent(event.StudentID)
+		ss.reindexStudent(event)
+	case bus.OpUpdate:
+		ss.reindexStudent(event)
+	case bus.OpDelete:
+		ss.forgetStudent(event.StudentID)
+		ss.nameIndex.Remove(event.StudentID)
+	}
+	ss.MdbService.Evict(event.StudentID)
+	ss.CacheService.EvictLocal(event.StudentID)
+	log.Printf("收到实例：%s的通知，学生：%s已%s，从内存中淘汰", event.Origin, event.StudentID, event.Op)
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"fmt"
+	"memoryDataBase/bus"
+	"memoryDataBase/model"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor 等待条件成立 超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestInvalidationBetweenServices 两个使用进程内缓存的实例通过 LocalBus 互相淘汰旧数据
+// 同一个实例重复或乱序到达的旧事件被忽略 不同实例的序号互不影响
+func TestInvalidationBetweenServices(t *testing.T) {
+	db := newTestDB(t)
+	hub := bus.NewLocalHub()
+	a := newTestStudentService(t, db, "node-a")
+	b := newTestStudentService(t, db, "node-b")
+	for origin, ss := range map[string]*StudentService{"node-a": a, "node-b": b} {
+		localBus := bus.NewLocalBus(hub, origin)
+		if err := ss.EnableInvalidation(localBus); err != nil {
+			t.Fatalf("启用失效通知失败：%v", err)
+		}
+		t.Cleanup(func() { localBus.Close() })
+	}
+	// 记录 node-a 发布的每种变更的序号 用来重放旧事件
+	var seqMu sync.Mutex
+	published := make(map[string]int64)
+	spy := bus.NewLocalBus(hub, "spy")
+	spy.Subscribe(func(event bus.Event) {
+		if event.Origin == "node-a" {
+			seqMu.Lock()
+			published[event.Op] = event.Seq
+			seqMu.Unlock()
+		}
+	}, func() {})
+	t.Cleanup(func() { spy.Close() })
+	// 模拟第三个实例 node-c 的事件 也用来确认 b 已经处理完之前收到的事件
+	other := bus.NewLocalBus(hub, "node-c")
+	var otherSeq int64
+	sync := func() {
+		t.Helper()
+		b.MdbService.AddStudent(&model.Student{ID: "marker", Grades: map[string]float64{}})
+		other.Publish(bus.Event{StudentID: "marker", Op: bus.OpUpdate, Seq: atomic.AddInt64(&otherSeq, 1)})
+		waitFor(t, "b 处理失效通知", func() bool {
+			_, exists := b.MdbService.PeekStudent("marker")
+			return !exists
+		})
+	}
+	audit := model.AuditContext{Actor: "tester"}
+
+	if err := a.AddStudentInternal(newTestStudent("s1", "张三"), audit); err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	sync()
+	if student, err := b.GetStudent("s1"); err != nil || student.Name != "张三" {
+		t.Fatalf("b 读取到 %+v, %v，期望张三", student, err)
+	}
+
+	// b 的内存和进程内缓存中都有旧数据 更新后都要淘汰
+	if err := a.UpdateStudentInternal(&model.Student{ID: "s1", Name: "李四", Version: 1}, audit); err != nil {
+		t.Fatalf("更新学生失败：%v", err)
+	}
+	sync()
+	if student, err := b.GetStudent("s1"); err != nil || student.Name != "李四" {
+		t.Fatalf("b 读取到 %+v, %v，期望李四", student, err)
+	}
+
+	// 重复和乱序到达的 node-a 的旧事件不会淘汰 b 内存中的学生
+	var updateSeq int64
+	waitFor(t, "记录更新的序号", func() bool {
+		seqMu.Lock()
+		defer seqMu.Unlock()
+		updateSeq = published[bus.OpUpdate]
+		return updateSeq != 0
+	})
+	replay := bus.NewLocalBus(hub, "node-a")
+	replay.Publish(bus.Event{StudentID: "s1", Op: bus.OpUpdate, Seq: updateSeq})
+	replay.Publish(bus.Event{StudentID: "s1", Op: bus.OpUpdate, Seq: updateSeq - 1})
+	sync()
+	if _, exists := b.MdbService.PeekStudent("s1"); !exists {
+		t.Fatalf("重复或乱序的旧事件淘汰了学生")
+	}
+
+	// 其他实例的序号和 node-a 无关 较小的序号也会处理
+	other.Publish(bus.Event{StudentID: "s1", Op: bus.OpUpdate, Seq: atomic.AddInt64(&otherSeq, 1)})
+	sync()
+	if _, exists := b.MdbService.PeekStudent("s1"); exists {
+		t.Fatalf("其他实例的事件没有淘汰学生")
+	}
+
+	if err := a.DeleteStudentInternal("s1", 2, audit); err != nil {
+		t.Fatalf("删除学生失败：%v", err)
+	}
+	sync()
+	if _, err := b.GetStudent("s1"); err == nil {
+		t.Fatalf("删除后 b 仍然能读到学生")
+	}
+}
+
+// TestInvalidationReindexInOrder 姓名索引按收到的顺序用事件中的姓名更新 不读取数据库
+func TestInvalidationReindexInOrder(t *testing.T) {
+	ss := newTestStudentService(t, newTestDB(t), "node-a")
+	// 异步写数据库的实例添加的学生还没有写入数据库
+	ss.handleInvalidation(bus.Event{StudentID: "s1", Op: bus.OpAdd, Name: "张三", Seq: 1, Origin: "node-b"})
+	if matches := ss.SearchStudents("张三", 10); len(matches) != 1 || matches[0].ID != "s1" {
+		t.Fatalf("添加后搜索张三 = %+v，期望找到s1", matches)
+	}
+	ss.handleInvalidation(bus.Event{StudentID: "s1", Op: bus.OpUpdate, Name: "李四", Seq: 2, Origin: "node-b"})
+	ss.handleInvalidation(bus.Event{StudentID: "s1", Op: bus.OpDelete, Seq: 3, Origin: "node-b"})
+	for _, name := range []string{"张三", "李四"} {
+		if matches := ss.SearchStudents(name, 10); len(matches) != 0 {
+			t.Fatalf("删除后搜索%s = %+v，期望没有结果", name, matches)
+		}
+	}
+}
+
+// TestResyncRebuildsFilterAndIndex 丢失了其他实例添加学生的通知时 重新同步后布隆过滤器不再拒绝这个学生 姓名索引中也能搜索到
+func TestResyncRebuildsFilterAndIndex(t *testing.T) {
+	options := DefaultStudentServiceOptions()
+	options.Filter.Enabled = true
+	db := newTestDB(t)
+	ss := newTestStudentServiceWithOptions(t, db, "node-a", options)
+	if err := ss.BuildStudentFilter(); err != nil {
+		t.Fatalf("构建布隆过滤器失败：%v", err)
+	}
+	// 其他实例添加了学生 本实例没有收到通知
+	err := ss.MysqlService.InTx(func(tx *StudentMysqlService) error {
+		return tx.AddStudentToMysql(newTestStudent("s1", "张三"), nil)
+	})
+	if err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	if !ss.rejectMissingStudent("s1") {
+		t.Skip("过滤器误判 s1 存在")
+	}
+
+	ss.resyncInvalidation()
+	student, err := ss.GetStudent("s1")
+	if err != nil || student.Name != "张三" {
+		t.Fatalf("重新同步后 GetStudent = %+v, %v，期望张三", student, err)
+	}
+	if matches := ss.SearchStudents("张三", 10); len(matches) != 1 || matches[0].ID != "s1" {
+		t.Fatalf("重新同步后搜索张三 = %+v，期望找到s1", matches)
+	}
+}
+
+// TestInvalidationSeqFollowsCommitOrder 同一个学生的并发更新 失效通知的序号和提交的顺序一致
+// 接收方按序号保留最后一次变更 序号乱序时姓名索引会停在旧的姓名上
+func TestInvalidationSeqFollowsCommitOrder(t *testing.T) {
+	const writers = 8
+	db := newTestDB(t)
+	hub := bus.NewLocalHub()
+	ss := newTestStudentService(t, db, "node-a")
+	localBus := bus.NewLocalBus(hub, "node-a")
+	if err := ss.EnableInvalidation(localBus); err != nil {
+		t.Fatalf("启用失效通知失败：%v", err)
+	}
+	t.Cleanup(func() { localBus.Close() })
+	audit := model.AuditContext{Actor: "tester"}
+	if err := ss.AddStudentInternal(newTestStudent("s1", "张三"), audit); err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+
+	var mu sync.Mutex
+	seqs := make(map[string]int64)
+	spy := bus.NewLocalBus(hub, "spy")
+	spy.Subscribe(func(event bus.Event) {
+		if event.Op == bus.OpUpdate {
+			mu.Lock()
+			seqs[event.Name] = event.Seq
+			mu.Unlock()
+		}
+	}, func() {})
+	t.Cleanup(func() { spy.Close() })
+
+	// 每个写入方读取当前版本后更新 版本冲突时重试 最后每个姓名对应一个提交后的版本号
+	versions := make(map[string]int64)
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		name := fmt.Sprintf("学生%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				current, err := ss.MysqlService.GetStudentFromMysql("s1")
+				if err != nil {
+					errs <- err
+					return
+				}
+				update := &model.Student{ID: "s1", Name: name, Version: current.Version}
+				err = ss.UpdateStudentInternal(update, audit)
+				if ss.VersionMismatchErr(err) {
+					continue
+				}
+				if err == nil {
+					mu.Lock()
+					versions[name] = update.Version
+					mu.Unlock()
+				}
+				errs <- err
+				return
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("并发更新学生失败：%v", err)
+		}
+	}
+	waitFor(t, "收到所有更新的通知", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seqs) == writers
+	})
+
+	names := make([]string, 0, writers)
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return versions[names[i]] < versions[names[j]] })
+	for i := 1; i < len(names); i++ {
+		if seqs[names[i]] <= seqs[names[i-1]] {
+			t.Fatalf("第%d版(%s)的序号%d不大于第%d版(%s)的序号%d", versions[names[i]], names[i], seqs[names[i]],
+				versions[names[i-1]], names[i-1], seqs[names[i-1]])
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"memoryDataBase/model"
+	"strconv"
+	"strings"
+)
+
+// listQueryErr 列出学生的参数错误
+func listQueryErr(format string, args ...interface{}) error {
+	return fmt.Errorf("列出学生的参数错误：%s", fmt.Sprintf(format, args...))
+}
+
+// StudentPage 一页学生 NextCursor 为空表示没有下一页
+type StudentPage struct {
+	Items      []*model.Student `json:"items"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+// ParseStudentSort 解析排序参数 id name avg 或者 score.学科 前面加 - 表示倒序 为空时按学号排序
+func ParseStudentSort(sort string, query *model.StudentQuery) error {
+	field := strings.TrimPrefix(sort, "-")
+	query.Desc = strings.HasPrefix(sort, "-")
+	switch {
+	case field == "" || field == model.SortById:
+		query.SortBy = model.SortById
+	case field == model.SortByName || field == model.SortByAvg:
+		query.SortBy = field
+	case strings.HasPrefix(field, model.SortByScore+".") && len(field) > len(model.SortByScore)+1:
+		query.SortBy = model.SortByScore
+		query.SortSubject = strings.TrimPrefix(field, model.SortByScore+".")
+	default:
+		return listQueryErr("无法识别的排序方式：%s", sort)
+	}
+	return nil
+}
+
+// ParseScoreRange 解析分数过滤条件 格式为 学科:最低分:最高分 最低分和最高分可以为空
+func ParseScoreRange(value string) (model.ScoreRange, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return model.ScoreRange{}, listQueryErr("分数条件的格式是 学科:最低分:最高分：%s", value)
+	}
+	scoreRange := model.ScoreRange{Subject: parts[0]}
+	bounds := []**float64{&scoreRange.Min, &scoreRange.Max}
+	for i, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		score, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return model.ScoreRange{}, listQueryErr("无法识别的分数：%s", part)
+		}
+		*bounds[i] = &score
+	}
+	return scoreRange, nil
+}
+
+// sortKey 排序方式的文本 写入游标 用来检查游标和当前的排序方式一致
+func sortKey(query *model.StudentQuery) string {
+	key := query.SortBy
+	if query.SortBy == model.SortByScore {
+		key += "." + query.SortSubject
+	}
+	if query.Desc {
+		key = "-" + key
+	}
+	return key
+}
+
+// encodeCursor 把分页位置编码为不透明的游标
+func encodeCursor(cursor *model.StudentCursor) (string, error) {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeStudentCursor 解码游标 游标必须是同一种排序方式生成的
+func DecodeStudentCursor(text string, query *model.StudentQuery) error {
+	data, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return listQueryErr("无法识别的游标")
+	}
+	var cursor model.StudentCursor
+	if err = json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return listQueryErr("无法识别的游标")
+	}
+	if cursor.Sort != sortKey(query) {
+		return listQueryErr("游标是按%s排序时生成的 不能用于按%s排序", cursor.Sort, sortKey(query))
+	}
+	needScore := query.SortBy == model.SortByScore || query.SortBy == model.SortByAvg
+	if needScore && cursor.Score == nil {
+		return listQueryErr("无法识别的游标")
+	}
+	query.After = &cursor
+	return nil
+}
+
+// ListStudents 从数据库按条件和排序返回一页学生
+func (sms *StudentMysqlService) ListStudents(query *model.StudentQuery) (*StudentPage, error) {
+	// 多查一个学生 判断有没有下一页
+	limit := query.Limit
+	query.Limit = limit + 1
+	rows, err := sms.mysqlDao.ListStudents(query)
+	query.Limit = limit
+	if err != nil {
+		log.Printf("从数据库列出学生失败：%v", err)
+		return nil, err
+	}
+	hasMore := len(rows) > limit
+	if hasMore {
+		rows = rows[:limit]
+	}
+	studentDBs := make([]model.StudentDB, len(rows))
+	for i, row := range rows {
+		studentDBs[i] = row.StudentDB
+	}
+	students, err := sms.withGrades(studentDBs)
+	if err != nil {
+		log.Printf("读取学生成绩失败：%v", err)
+		return nil, err
+	}
+	page := &StudentPage{Items: students}
+	if hasMore {
+		// 排序值使用数据库返回的值 平均分在程序中重新计算可能和数据库有误差
+		last := rows[len(rows)-1]
+		cursor := &model.StudentCursor{Sort: sortKey(query), ID: last.ID, Score: last.SortScore}
+		if query.SortBy == model.SortByName {
+			cursor.Name = last.Name
+		}
+		if page.NextCursor, err = encodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// ListStudents 按条件列出学生 直接从数据库查询 异步写数据库时队列中还没有写入的变更不会出现在结果中
+func (ss *StudentService) ListStudents(query *model.StudentQuery) (*StudentPage, error) {
+	return ss.MysqlService.ListStudents(query)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"fmt"
+	"memoryDataBase/dao"
+	"memoryDataBase/model"
+	"sort"
+	"testing"
+)
+
+// TestListStudentsPaging 按每种排序方式翻页 排序值有大量重复时也不会重复或漏掉学生
+func TestListStudentsPaging(t *testing.T) {
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t)))
+	names := []string{"张三", "李四", "王五"}
+	scores := []float64{60, 80, 80, 100}
+	var students []*model.Student
+	for i := 0; i < 23; i++ {
+		student := newTestStudent(fmt.Sprintf("s%02d", i), names[i%len(names)])
+		student.Grades = map[string]float64{"数学": scores[i%len(scores)], "语文": scores[(i/2)%len(scores)]}
+		// 没有数学成绩的学生不出现在按数学排序的结果中 没有成绩的学生平均分为0
+		if i%7 == 3 {
+			delete(student.Grades, "数学")
+		}
+		if i == 11 {
+			student.Grades = nil
+		}
+		students = append(students, student)
+	}
+	err := mysqlService.InTx(func(tx *StudentMysqlService) error {
+		for _, student := range students {
+			if err := tx.AddStudentToMysql(student, nil); err != nil {
+				return err
+			}
+		}
+		// 被删除的学生不出现在结果中
+		return tx.DeleteStudent("s05", 1, nil)
+	})
+	if err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	// 更新成绩后按新的平均分排序
+	students[7].Grades["语文"] = 0
+	err = mysqlService.InTx(func(tx *StudentMysqlService) error {
+		_, err := tx.UpdateStudent(&model.Student{ID: "s07", Grades: map[string]float64{"语文": 0}, Version: 1}, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("更新学生失败：%v", err)
+	}
+
+	avg := func(student *model.Student) float64 {
+		if len(student.Grades) == 0 {
+			return 0
+		}
+		sum := 0.0
+		for _, score := range student.Grades {
+			sum += score
+		}
+		return sum / float64(len(student.Grades))
+	}
+	for _, tc := range []struct {
+		sort string
+		// key 返回学生的排序值 第二个返回值为false时学生不出现在结果中
+		key func(student *model.Student) (interface{}, bool)
+	}{
+		{"id", func(s *model.Student) (interface{}, bool) { return "", true }},
+		{"name", func(s *model.Student) (interface{}, bool) { return s.Name, true }},
+		{"-name", func(s *model.Student) (interface{}, bool) { return s.Name, true }},
+		{"score.数学", func(s *model.Student) (interface{}, bool) { score, ok := s.Grades["数学"]; return score, ok }},
+		{"-score.数学", func(s *model.Student) (interface{}, bool) { score, ok := s.Grades["数学"]; return score, ok }},
+		{"avg", func(s *model.Student) (interface{}, bool) { return avg(s), true }},
+		{"-avg", func(s *model.Student) (interface{}, bool) { return avg(s), true }},
+	} {
+		t.Run(tc.sort, func(t *testing.T) {
+			query := &model.StudentQuery{Limit: 4}
+			if err := ParseStudentSort(tc.sort, query); err != nil {
+				t.Fatal(err)
+			}
+			var want []*model.Student
+			for _, student := range students {
+				if _, ok := tc.key(student); ok && student.ID != "s05" {
+					want = append(want, student)
+				}
+			}
+			sort.SliceStable(want, func(i, j int) bool {
+				ki, _ := tc.key(want[i])
+				kj, _ := tc.key(want[j])
+				if ki != kj {
+					less := fmt.Sprint(ki) < fmt.Sprint(kj)
+					if fi, ok := ki.(float64); ok {
+						less = fi < kj.(float64)
+					}
+					return less != query.Desc
+				}
+				return (want[i].ID < want[j].ID) != query.Desc
+			})
+
+			var got []string
+			seen := make(map[string]bool)
+			for pages := 0; ; pages++ {
+				if pages > len(students) {
+					t.Fatalf("翻页没有结束")
+				}
+				page, err := mysqlService.ListStudents(query)
+				if err != nil {
+					t.Fatalf("列出学生失败：%v", err)
+				}
+				for _, student := range page.Items {
+					if seen[student.ID] {
+						t.Fatalf("学生：%s重复出现", student.ID)
+					}
+					seen[student.ID] = true
+					got = append(got, student.ID)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				if err = DecodeStudentCursor(page.NextCursor, query); err != nil {
+					t.Fatalf("解码游标失败：%v", err)
+				}
+			}
+			if len(got) != len(want) {
+				t.Fatalf("列出的学生 = %v，期望%d个", got, len(want))
+			}
+			for i, student := range want {
+				if got[i] != student.ID {
+					t.Fatalf("第%d个学生 = %s，期望 %s\n列出的学生 = %v", i, got[i], student.ID, got)
+				}
+			}
+		})
+	}
+}
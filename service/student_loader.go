@@ -0,0 +1,117 @@
+package service
+
+import (
+	"golang.org/x/sync/singleflight"
+	"log"
+	"math"
+	"math/rand"
+	"memoryDataBase/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LoaderStats 读穿透加载的统计信息
+type LoaderStats struct {
+	// Loads 实际执行加载的次数
+	Loads int64 `json:"loads"`
+	// Coalesced 合并到其他请求的加载中 没有访问下层数据源的请求数
+	Coalesced int64 `json:"coalesced"`
+	// EarlyRefreshes 提前刷新的次数
+	EarlyRefreshes int64 `json:"early_refreshes"`
+	// AvgLoadMillis 平均加载耗时
+	AvgLoadMillis float64 `json:"avg_load_millis"`
+	// NegativeHits 命中不存在学号的缓存的请求数
+	NegativeHits int64 `json:"negative_hits"`
+	// FilterRejections 被布隆过滤器直接拒绝的请求数
+	FilterRejections int64 `json:"filter_rejections"`
+}
+
+// studentLoader 合并对同一个学生的并发加载 同一时刻每个学号只有一个请求访问缓存和数据库
+type studentLoader struct {
+	// earlyRefreshBeta 提前刷新的激进程度 越大越早刷新 0表示不提前刷新
+	// 使用 XFetch 算法 剩余存活时间小于 加载耗时*beta*(-ln(rand)) 时在后台刷新内存中的学生
+	earlyRefreshBeta float64
+	group            singleflight.Group
+	loads            int64
+	coalesced        int64
+	earlyRefreshes   int64
+	negativeHits     int64
+	rejections       int64
+
+	mu sync.Mutex
+	// avgLoad 加载耗时的指数移动平均值
+	avgLoad time.Duration
+}
+
+// load 加载学生 并发的相同学号的请求会共享同一次加载的结果
+func (l *studentLoader) load(id string, fn func() (*model.Student, error)) (*model.Student, error) {
+	// 共享结果时执行加载的请求也会得到 shared 只统计没有执行加载的请求
+	loaded := false
+	value, err, _ := l.group.Do(id, func() (interface{}, error) {
+		loaded = true
+		atomic.AddInt64(&l.loads, 1)
+		start := time.Now()
+		student, err := fn()
+		l.observe(time.Since(start))
+		return student, err
+	})
+	if !loaded {
+		atomic.AddInt64(&l.coalesced, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value.(*model.Student), nil
+}
+
+// refresh 在后台刷新学生 同一个学生同时只有一个刷新任务
+func (l *studentLoader) refresh(id string, fn func() error) {
+	atomic.AddInt64(&l.earlyRefreshes, 1)
+	l.group.DoChan("refresh:"+id, func() (interface{}, error) {
+		if err := fn(); err != nil {
+			log.Printf("提前刷新学生：%s失败：%v", id, err)
+			return nil, err
+		}
+		return nil, nil
+	})
+}
+
+// shouldRefreshEarly 根据剩余存活时间判断是否需要提前刷新
+func (l *studentLoader) shouldRefreshEarly(remaining time.Duration) bool {
+	if l.earlyRefreshBeta <= 0 || remaining < 0 {
+		return false
+	}
+	l.mu.Lock()
+	delta := l.avgLoad
+	l.mu.Unlock()
+	if delta <= 0 {
+		return false
+	}
+	gap := float64(delta) * l.earlyRefreshBeta * -math.Log(1-rand.Float64())
+	return gap >= float64(remaining)
+}
+
+func (l *studentLoader) observe(elapsed time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.avgLoad == 0 {
+		l.avgLoad = elapsed
+		return
+	}
+	l.avgLoad = (l.avgLoad*7 + elapsed) / 8
+}
+
+func (l *studentLoader) stats() LoaderStats {
+	l.mu.Lock()
+	avg := l.avgLoad
+	l.mu.Unlock()
+	return LoaderStats{
+		Loads:            atomic.LoadInt64(&l.loads),
+		Coalesced:        atomic.LoadInt64(&l.coalesced),
+		EarlyRefreshes:   atomic.LoadInt64(&l.earlyRefreshes),
+		AvgLoadMillis:    float64(avg) / float64(time.Millisecond),
+		NegativeHits:     atomic.LoadInt64(&l.negativeHits),
+		FilterRejections: atomic.LoadInt64(&l.rejections),
+	}
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"fmt"
+	"memoryDataBase/model"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// countStudentLoads 统计从数据库按学号读取学生的次数 每次读取前等待 delay
+func countStudentLoads(t *testing.T, db *gorm.DB, delay time.Duration) *int64 {
+	t.Helper()
+	var loads int64
+	err := db.Callback().Row().Before("gorm:row").Register("test:count_loads", func(tx *gorm.DB) {
+		if !strings.Contains(tx.Statement.SQL.String(), "from student where id = ?") {
+			return
+		}
+		atomic.AddInt64(&loads, 1)
+		time.Sleep(delay)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Callback().Row().Remove("test:count_loads") })
+	return &loads
+}
+
+// TestConcurrentMissesLoadOnce 内存和缓存中都没有的学生被并发读取时 只有一个请求访问数据库
+func TestConcurrentMissesLoadOnce(t *testing.T) {
+	const readers = 20
+	db := newTestDB(t)
+	ss := newTestStudentService(t, db, "node1")
+	if err := ss.AddStudentInternal(newTestStudent("s1", "张三"), model.AuditContext{Actor: "tester"}); err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	if err := ss.evictStudent("s1"); err != nil {
+		t.Fatal(err)
+	}
+	// 加载期间等待 让所有读取都在这次加载结束前到达
+	loads := countStudentLoads(t, db, 100*time.Millisecond)
+	before := ss.LoaderStats()
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			student, err := ss.GetStudent("s1")
+			if err == nil && student.Name != "张三" {
+				err = fmt.Errorf("读取到的学生 = %+v，期望张三", student)
+			}
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("并发读取学生失败：%v", err)
+		}
+	}
+
+	if got := atomic.LoadInt64(loads); got != 1 {
+		t.Fatalf("从数据库读取学生的次数 = %d，期望 1", got)
+	}
+	stats := ss.LoaderStats()
+	if stats.Loads-before.Loads != 1 || stats.Coalesced-before.Coalesced != readers-1 {
+		t.Fatalf("加载统计 = %+v，期望加载1次 合并%d次", stats, readers-1)
+	}
+}
+
+// TestEarlyRefreshWindow 剩余存活时间落在提前刷新的窗口内时在后台从数据库重新加载 窗口外和永不过期时不刷新
+func TestEarlyRefreshWindow(t *testing.T) {
+	db := newTestDB(t)
+	ss := newTestStudentService(t, db, "node1")
+	if err := ss.AddStudentInternal(newTestStudent("s1", "张三"), model.AuditContext{Actor: "tester"}); err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	loads := countStudentLoads(t, db, 0)
+	setAvgLoad := func(avg time.Duration) {
+		ss.loader.mu.Lock()
+		ss.loader.avgLoad = avg
+		ss.loader.mu.Unlock()
+	}
+	setMemoryTTL := func(seconds int64) {
+		student := newTestStudent("s1", "张三")
+		student.Expiration = seconds
+		ss.MdbService.AddStudent(student)
+	}
+	getStudent := func() {
+		t.Helper()
+		if _, err := ss.GetStudent("s1"); err != nil {
+			t.Fatalf("读取学生失败：%v", err)
+		}
+	}
+
+	// 剩余一小时 远大于 加载耗时*beta 不刷新
+	ss.loader.earlyRefreshBeta = 1
+	setAvgLoad(time.Millisecond)
+	setMemoryTTL(3600)
+	getStudent()
+	// 永不过期的学生不刷新
+	setMemoryTTL(0)
+	getStudent()
+	// beta 为0时关闭提前刷新
+	ss.loader.earlyRefreshBeta = 0
+	setAvgLoad(time.Second)
+	setMemoryTTL(1)
+	getStudent()
+	if stats := ss.LoaderStats(); stats.EarlyRefreshes != 0 || atomic.LoadInt64(loads) != 0 {
+		t.Fatalf("窗口外提前刷新了：%+v，读取数据库%d次", stats, atomic.LoadInt64(loads))
+	}
+
+	// 剩余时间远小于 加载耗时*beta 在后台刷新 刷新后内存中的学生使用数据库中的过期时间
+	ss.loader.earlyRefreshBeta = 1e6
+	setMemoryTTL(60)
+	getStudent()
+	if stats := ss.LoaderStats(); stats.EarlyRefreshes != 1 {
+		t.Fatalf("窗口内没有提前刷新：%+v", stats)
+	}
+	waitFor(t, "后台刷新", func() bool {
+		remaining, _ := ss.MdbService.StudentTTL("s1")
+		return remaining < 0
+	})
+	if got := atomic.LoadInt64(loads); got != 1 {
+		t.Fatalf("提前刷新读取数据库%d次，期望 1", got)
+	}
+}
@@ -6,15 +6,32 @@ import (
 	"log"
 	"memoryDataBase/dao"
 	"memoryDataBase/model"
+	"time"
 )
 
+// 内存数据库中存放学生和不存在的学号的命名空间
+const (
+	StudentNamespace        = "students"
+	MissingStudentNamespace = "students:missing"
+)
+
+// DefaultNegativeCacheTTL 不存在的学号在内存中默认缓存的时间
+const DefaultNegativeCacheTTL = 30 * time.Second
+
 type StudentMdbService struct {
+	memoryDB    *dao.MemoryNamespaceDao
 	memoryDBDao *dao.MemoryDBDao
+	missingDao  *dao.MemoryDBDao
+	// negativeCacheTTL 不存在的学号在内存中缓存多久 0表示不缓存
+	negativeCacheTTL time.Duration
 }
 
-func NewStudentMdbService(db *dao.MemoryDBDao) *StudentMdbService {
+func NewStudentMdbService(db *dao.MemoryNamespaceDao, negativeCacheTTL time.Duration) *StudentMdbService {
 	return &StudentMdbService{
-		memoryDBDao: db,
+		memoryDB:         db,
+		memoryDBDao:      db.CreateNamespace(StudentNamespace, dao.DefaultTTLPolicy()),
+		missingDao:       db.CreateNamespace(MissingStudentNamespace, dao.TTLPolicy{DefaultTTL: negativeCacheTTL}),
+		negativeCacheTTL: negativeCacheTTL,
 	}
 }
 
@@ -69,6 +86,59 @@ func (smdbs *StudentMdbService) StudentExists(studentId string) error {
 	return err
 }
 
+// MarkMissing 记录学号不存在 在 negativeCacheTTL 内不再去下层数据源查找
+func (smdbs *StudentMdbService) MarkMissing(studentId string) {
+	if smdbs.negativeCacheTTL <= 0 {
+		return
+	}
+	smdbs.missingDao.SetDefault(studentId, true)
+}
+
+// IsMissing 判断学号是否在最近被确认过不存在
+func (smdbs *StudentMdbService) IsMissing(studentId string) bool {
+	if smdbs.negativeCacheTTL <= 0 {
+		return false
+	}
+	return smdbs.missingDao.Exists(studentId)
+}
+
+// ClearMissing 学生被添加后清除不存在的记录
+func (smdbs *StudentMdbService) ClearMissing(studentId string) {
+	smdbs.missingDao.Delete(studentId)
+}
+
+// PeekStudent 读取内存中的学生 不影响命中率和过期时间 用于对账
+func (smdbs *StudentMdbService) PeekStudent(studentId string) (*model.Student, bool) {
+	value, exists := smdbs.memoryDBDao.Peek(studentId)
+	if !exists {
+		return nil, false
+	}
+	student, ok := value.(*model.Student)
+	return student, ok
+}
+
+// StudentIDs 返回内存中所有学生的学号
+func (smdbs *StudentMdbService) StudentIDs() []string {
+	return smdbs.memoryDBDao.Keys("*")
+}
+
+// Evict 从内存中淘汰学生 学生不存在时什么也不做
+func (smdbs *StudentMdbService) Evict(studentId string) {
+	smdbs.memoryDBDao.Delete(studentId)
+}
+
+// FlushStudents 淘汰内存中的所有学生
+func (smdbs *StudentMdbService) FlushStudents() {
+	smdbs.memoryDBDao.Flush()
+	smdbs.missingDao.Flush()
+}
+
+// StudentTTL 返回内存中学生的剩余存活时间 永不过期时小于0
+func (smdbs *StudentMdbService) StudentTTL(studentId string) (time.Duration, bool) {
+	return smdbs.memoryDBDao.TTL(studentId)
+}
+
+// PeriodicDelete 定期删除所有命名空间中的过期键
 func (smdbs *StudentMdbService) PeriodicDelete() {
-	smdbs.memoryDBDao.PeriodicDelete()
+	smdbs.memoryDB.PeriodicDelete()
 }
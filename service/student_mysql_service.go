@@ -1,23 +1,31 @@
 package service
 
 import (
-	"gorm.io/gorm"
+	"fmt"
 	"log"
 	"memoryDataBase/dao"
 	"memoryDataBase/model"
 	"strings"
+	"time"
 )
 
 type StudentMysqlService struct {
-	mysqlDao *dao.StudentMysqlDao
+	mysqlDao dao.StudentRepository
 }
 
-func NewStudentMysqlService(mysqlDao *dao.StudentMysqlDao) *StudentMysqlService {
+func NewStudentMysqlService(mysqlDao dao.StudentRepository) *StudentMysqlService {
 	return &StudentMysqlService{
 		mysqlDao: mysqlDao,
 	}
 }
 
+// InTx 在一个数据库事务中执行 fn 通过 tx 的读写都属于这个事务 fn 返回错误或 panic 时回滚
+func (sms *StudentMysqlService) InTx(fn func(tx *StudentMysqlService) error) error {
+	return sms.mysqlDao.InTx(func(repo dao.StudentRepository) error {
+		return fn(NewStudentMysqlService(repo))
+	})
+}
+
 func (sms *StudentMysqlService) ConvertToStudent(studentDB *model.StudentDB) (*model.Student, error) {
 	grades := make(map[string]float64)
 	result, err := sms.mysqlDao.GetGrade(studentDB.ID)
@@ -29,11 +37,13 @@ func (sms *StudentMysqlService) ConvertToStudent(studentDB *model.StudentDB) (*m
 		grades[v.Subject] = v.Score
 	}
 	return &model.Student{
-		ID:     studentDB.ID,
-		Name:   studentDB.Name,
-		Gender: studentDB.Gender,
-		Class:  studentDB.Class,
-		Grades: grades,
+		ID:         studentDB.ID,
+		Name:       studentDB.Name,
+		Gender:     studentDB.Gender,
+		Class:      studentDB.Class,
+		Grades:     grades,
+		Expiration: studentDB.Expiration,
+		Version:    studentDB.Version,
 	}, nil
 }
 
@@ -47,114 +57,237 @@ func (sms *StudentMysqlService) StudentCountExists(id string) error {
 	return err
 }
 
-func (sms *StudentMysqlService) AddStudentToMysql(tx *gorm.DB, student *model.Student) error {
-	if err := sms.mysqlDao.AddStudentToMysql(tx, student); err != nil {
-		tx.Rollback()
+// AddStudentToMysql 添加学生和成绩 需要在 InTx 中调用 audit 不为nil时同时写入审计记录
+func (sms *StudentMysqlService) AddStudentToMysql(student *model.Student, audit *model.AuditContext) error {
+	// 升级前写入本地日志的学生没有版本号
+	if student.Version <= 0 {
+		student.Version = 1
+	}
+	// 学号属于保留期内被删除的学生时不能添加 否则就无法再恢复之前的学生
+	if err := sms.checkNotDeleted(student.ID); err != nil {
+		log.Printf("添加学生：%s失败：%v", student.ID, err)
+		return err
+	}
+	if err := sms.mysqlDao.AddStudentToMysql(student); err != nil {
 		log.Printf("向学生表添加学生：%s失败：%v", student.ID, err)
 		return err
 	}
 	log.Printf("向数据库添加学生：%s", student.ID)
 
 	// 在事务中添加学生成绩信息
-	for k, v := range student.Grades {
-		if err := sms.mysqlDao.AddGradeToMysql(tx, k, v, student.ID); err != nil {
-			tx.Rollback()
-			log.Printf("向成绩表添加学生：%s的成绩失败：%v", student.ID, err)
-			return err
-		}
+	if err := sms.mysqlDao.UpsertGrades(student.ID, student.Grades); err != nil {
+		log.Printf("向成绩表添加学生：%s的成绩失败：%v", student.ID, err)
+		return err
 	}
 	log.Printf("向数据库添加学生的成绩：%s", student.ID)
+	if audit != nil {
+		return sms.RecordChange(studentOpAdd, nil, student, *audit)
+	}
 	return nil
 }
 
 func (sms *StudentMysqlService) GetStudentFromMysql(studentId string) (*model.Student, error) {
-	var studentDB *model.StudentDB
-	var student *model.Student
 	studentDB, err := sms.mysqlDao.GetStudent(studentId)
 	if err != nil {
 		log.Printf("从数据库查找学生：%s失败：%v", studentId, err)
 		return nil, err
 	}
 	log.Printf("从数据库查找学生：%s", studentId)
-	student, err = sms.ConvertToStudent(studentDB)
+	return sms.convertStudent(studentDB)
+}
+
+// getStudentForUpdate 在 InTx 中读取并锁住学生 之后基于它的修改在提交前不会和其他事务交错
+func (sms *StudentMysqlService) getStudentForUpdate(studentId string) (*model.Student, error) {
+	studentDB, err := sms.mysqlDao.GetStudentForUpdate(studentId)
+	if err != nil {
+		log.Printf("从数据库查找学生：%s失败：%v", studentId, err)
+		return nil, err
+	}
+	return sms.convertStudent(studentDB)
+}
+
+func (sms *StudentMysqlService) convertStudent(studentDB *model.StudentDB) (*model.Student, error) {
+	student, err := sms.ConvertToStudent(studentDB)
 	if err != nil {
-		log.Printf("数据库中学生：%s转化出错：%v", studentId, err)
+		log.Printf("数据库中学生：%s转化出错：%v", studentDB.ID, err)
 		return nil, err
 	}
 	log.Printf("数据库中学生：%s转化成功", student.ID)
 	return student, nil
 }
 
-func (sms *StudentMysqlService) UpdateStudent(tx *gorm.DB, student *model.Student) error {
-	err := sms.StudentExists(student.ID)
+// UpdateStudent 更新学生 需要在 InTx 中调用 返回更新后学生的完整状态 audit 不为nil时同时写入审计记录
+// student.Version 必须等于数据库中的版本号 更新后版本号加一
+func (sms *StudentMysqlService) UpdateStudent(student *model.Student, audit *model.AuditContext) (*model.Student, error) {
+	current, err := sms.getStudentForUpdate(student.ID)
 	if err != nil {
 		log.Printf("数据库不存在学生：%s", student.ID)
-		tx.Rollback()
+		return nil, err
+	}
+	if err = checkVersion(student.ID, student.Version, current.Version); err != nil {
+		return nil, err
+	}
+	state := mergeStudent(current, student)
+	state.Version = current.Version + 1
+	// 学生在读取时已经被锁住 条件更新只是防止没有在事务中调用
+	if err = sms.writeStudent(state, current.Version); err != nil {
+		return nil, err
+	}
+	if audit != nil {
+		if err = sms.RecordChange(studentOpUpdate, current, state, *audit); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// overwriteStudent 用学生的完整状态覆盖数据库中的学生 不检查版本号 只有异步写数据库使用 见 skipVersionCheck
+func (sms *StudentMysqlService) overwriteStudent(state *model.Student) error {
+	if err := sms.StudentExists(state.ID); err != nil {
+		log.Printf("数据库不存在学生：%s", state.ID)
 		return err
 	}
+	return sms.writeStudent(state, 0)
+}
 
-	if err = sms.mysqlDao.UpdateStudent(tx, student); err != nil {
-		tx.Rollback()
+func (sms *StudentMysqlService) writeStudent(student *model.Student, currentVersion int64) error {
+	if err := sms.mysqlDao.UpdateStudent(student, currentVersion); err != nil {
 		log.Printf("在数据库更新学生：%s失败：%v", student.ID, err)
 		return err
 	}
 	log.Printf("在数据库更新学生：%s", student.ID)
-	//向成绩表插入数据 先判断是否存在 如果存在就更新 不存在就添加
-	if student.Grades != nil {
-		for subject, grade := range student.Grades {
-			exists, err := sms.mysqlDao.GetGradeBySubject(student.ID, subject)
-			if err != nil {
-				tx.Rollback()
-				log.Printf("通过学科：%s查找学生：%s的记录失败：%v", student.ID, subject, err)
-				return err
-			}
-			if exists != nil {
-				//成绩记录已存在 修改
-				if err = sms.mysqlDao.UpdateGrade(tx, subject, grade, student.ID); err != nil {
-					tx.Rollback()
-					log.Printf("向成绩表添加成绩失败，学生：%s，错误：%v", student.ID, err)
-					return err
-				}
-			} else {
-				//成绩记录不存在 插入
-				if err = sms.mysqlDao.AddGradeToMysql(tx, subject, grade, student.ID); err != nil {
-					tx.Rollback()
-					log.Printf("向成绩表添加学生：%s的成绩：%s失败：%v", student.ID, subject, err)
-					return err
-				}
-			}
-		}
+	// 成绩已存在时更新 不存在时插入 在同一个事务中用一条语句完成
+	if err := sms.mysqlDao.UpsertGrades(student.ID, student.Grades); err != nil {
+		log.Printf("向成绩表写入学生：%s的成绩失败：%v", student.ID, err)
+		return err
 	}
 	log.Printf("在数据库更新学生：%s的成绩", student.ID)
 	return nil
 }
-func (sms *StudentMysqlService) DeleteStudent(tx *gorm.DB, id string) error {
-	if err := sms.StudentExists(id); err != nil {
+
+// DeleteStudent 软删除学生 需要在 InTx 中调用 成绩保留到学生被彻底删除 可以在保留期内恢复
+// version 必须等于数据库中的版本号 audit 不为nil时同时写入审计记录
+func (sms *StudentMysqlService) DeleteStudent(id string, version int64, audit *model.AuditContext) error {
+	return sms.deleteStudentAt(id, version, audit, time.Now().UTC())
+}
+
+// deleteStudentAt 软删除学生 deletedAt 是删除时间 同时也是审计记录的时间
+func (sms *StudentMysqlService) deleteStudentAt(id string, version int64, audit *model.AuditContext, deletedAt time.Time) error {
+	current, err := sms.getStudentForUpdate(id)
+	if err != nil {
 		log.Printf("数据库不存在学生：%s", id)
-		tx.Rollback()
+		return err
+	}
+	if err = checkVersion(id, version, current.Version); err != nil {
 		return err
 	}
 
-	if err := sms.mysqlDao.DeleteStudent(tx, id); err != nil {
-		tx.Rollback()
+	// 删除时间使用 UTC 和清理任务比较时不受数据库时区设置的影响
+	if err = sms.mysqlDao.DeleteStudent(id, deletedAt.UTC(), current.Version); err != nil {
 		log.Printf("删除学生：%s失败：%v", id, err)
 		return err
 	}
 	log.Printf("删除学生：%s", id)
-
-	if err := sms.mysqlDao.DeleteScore(tx, id); err != nil {
-		tx.Rollback()
-		log.Printf("删除学生：%s的成绩时失败：%v", id, err)
-		return err
+	if audit != nil {
+		return sms.recordChangeAt(studentOpDelete, current, nil, *audit, deletedAt)
 	}
-	log.Printf("删除学生：%s的成绩", id)
 	return nil
 }
 
-func (sms *StudentMysqlService) GetHotStudentsFromMysql() ([]*model.Student, error) {
+// GetAllStudentIDs 获取数据库中所有学生的学号
+func (sms *StudentMysqlService) GetAllStudentIDs() ([]string, error) {
+	ids, err := sms.mysqlDao.GetAllStudentIDs()
+	if err != nil {
+		log.Printf("获取所有学生的学号失败：%v", err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ExistingStudentIDs 返回 ids 中在数据库中存在的学号
+func (sms *StudentMysqlService) ExistingStudentIDs(ids []string) (map[string]bool, error) {
+	existing, err := sms.mysqlDao.GetExistingStudentIDs(ids)
+	if err != nil {
+		log.Printf("批量确认%d个学生是否存在失败：%v", len(ids), err)
+		return nil, err
+	}
+	result := make(map[string]bool, len(existing))
+	for _, id := range existing {
+		result[id] = true
+	}
+	return result, nil
+}
+
+// StreamStudents 按学号顺序分批读取数据库中的所有学生 每批连同成绩一起交给 fn 处理
+func (sms *StudentMysqlService) StreamStudents(batchSize int, fn func(students []*model.Student) error) error {
+	afterId := ""
+	for {
+		studentDBs, err := sms.mysqlDao.GetStudentsAfter(afterId, batchSize)
+		if err != nil {
+			log.Printf("分批读取学号大于：%s的学生失败：%v", afterId, err)
+			return err
+		}
+		if len(studentDBs) == 0 {
+			return nil
+		}
+		students, err := sms.withGrades(studentDBs)
+		if err != nil {
+			log.Printf("分批读取学生成绩失败：%v", err)
+			return err
+		}
+		if err = fn(students); err != nil {
+			return err
+		}
+		afterId = studentDBs[len(studentDBs)-1].ID
+	}
+}
+
+// withGrades 用一次查询读取一批学生的成绩 按原来的顺序转换为学生
+func (sms *StudentMysqlService) withGrades(studentDBs []model.StudentDB) ([]*model.Student, error) {
+	ids := make([]string, len(studentDBs))
+	for i, studentDB := range studentDBs {
+		ids[i] = studentDB.ID
+	}
+	grades, err := sms.mysqlDao.GetGradesByStudentIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	gradesById := make(map[string]map[string]float64, len(ids))
+	for _, grade := range grades {
+		if gradesById[grade.StudentId] == nil {
+			gradesById[grade.StudentId] = make(map[string]float64)
+		}
+		gradesById[grade.StudentId][grade.Subject] = grade.Score
+	}
+	students := make([]*model.Student, len(studentDBs))
+	for i, studentDB := range studentDBs {
+		studentGrades := gradesById[studentDB.ID]
+		if studentGrades == nil {
+			studentGrades = make(map[string]float64)
+		}
+		students[i] = &model.Student{
+			ID:         studentDB.ID,
+			Name:       studentDB.Name,
+			Gender:     studentDB.Gender,
+			Class:      studentDB.Class,
+			Grades:     studentGrades,
+			Expiration: studentDB.Expiration,
+			Version:    studentDB.Version,
+		}
+	}
+	return students, nil
+}
+
+// StudentNotFoundErr 判断错误是不是数据库中不存在该学生
+func (sms *StudentMysqlService) StudentNotFoundErr(id string, err error) bool {
+	return err != nil && strings.Contains(err.Error(), fmt.Sprintf("数据库不存在学生：%s", id))
+}
+
+// GetHotStudentsFromMysql 按累计访问次数返回最多 limit 个学生
+func (sms *StudentMysqlService) GetHotStudentsFromMysql(limit int) ([]*model.Student, error) {
 	var hotStudents []*model.StudentCount
 	var students []*model.Student
-	hotStudents, err := sms.GetHotStudentCount()
+	hotStudents, err := sms.GetHotStudentCount(limit)
 	if err != nil {
 		return nil, err
 	}
@@ -169,34 +302,42 @@ func (sms *StudentMysqlService) GetHotStudentsFromMysql() ([]*model.Student, err
 	return students, nil
 }
 
-func (sms *StudentMysqlService) AddStudentCount(id string) {
-	record, err := sms.GetStudentCountFromMysql(id)
-	if err != nil {
-		if strings.Contains(err.Error(), "不存在学生记录") {
-			if err = sms.mysqlDao.AddStudentCount(id); err != nil {
-				log.Printf("添加学生：%s的记录时出错：%v", id, err)
-			} else {
-				log.Printf("添加学生：%s记录", id)
-			}
-		} else {
-			log.Printf("添加学生记录：%s时失败：%v", id, err)
+// GetStudentsFromMysql 按顺序返回数据库中的学生 已经不存在的学生跳过
+func (sms *StudentMysqlService) GetStudentsFromMysql(ids []string) ([]*model.Student, error) {
+	students := make([]*model.Student, 0, len(ids))
+	for _, id := range ids {
+		student, err := sms.GetStudentFromMysql(id)
+		if sms.StudentNotFoundErr(id, err) {
+			continue
 		}
-	} else {
-		record.Count++
-		if err = sms.mysqlDao.UpdateStudentCount(record); err != nil {
-			log.Printf("更新学生：%s访问次数时出错：%v", id, err)
-		} else {
-			log.Printf("更新学生：%s的访问次数：%s", id, record.StudentId)
+		if err != nil {
+			return nil, err
 		}
+		students = append(students, student)
 	}
+	return students, nil
+}
+
+// AddStudentCounts 批量给学生的访问次数加上增量
+func (sms *StudentMysqlService) AddStudentCounts(deltas map[string]int64) error {
+	return sms.mysqlDao.IncrementStudentCounts(deltas)
+}
+
+// AddStudentCount 把学生的访问次数加一
+func (sms *StudentMysqlService) AddStudentCount(id string) error {
+	if err := sms.mysqlDao.IncrementStudentCounts(map[string]int64{id: 1}); err != nil {
+		log.Printf("更新学生：%s的访问次数时出错：%v", id, err)
+		return err
+	}
+	return nil
 }
 
 func (sms *StudentMysqlService) GetStudentCountFromMysql(id string) (*model.StudentCount, error) {
 	return sms.mysqlDao.GetStudentCount(id)
 }
 
-func (sms *StudentMysqlService) GetHotStudentCount() ([]*model.StudentCount, error) {
-	studentCounts, err := sms.mysqlDao.GetHotStudentCounts()
+func (sms *StudentMysqlService) GetHotStudentCount(limit int) ([]*model.StudentCount, error) {
+	studentCounts, err := sms.mysqlDao.GetHotStudentCounts(limit)
 	if err != nil {
 		log.Printf("获取访问最高的学生记录出错：%v", err)
 		return nil, err
@@ -212,3 +353,45 @@ func (sms *StudentMysqlService) DeleteStudentCount(id string) {
 		log.Printf("删除学生：%s记录时失败：%v", id, err)
 	}
 }
+
+// AddOutboxEvent 写入发件箱 需要和学生数据在同一个 InTx 中调用
+func (sms *StudentMysqlService) AddOutboxEvent(event *model.OutboxEvent) error {
+	if err := sms.mysqlDao.AddOutboxEvent(event); err != nil {
+		log.Printf("向发件箱写入学生：%s的变更失败：%v", event.StudentId, err)
+		return err
+	}
+	return nil
+}
+
+func (sms *StudentMysqlService) GetPendingOutboxEvents(origin string, afterId uint64, limit int) ([]*model.OutboxEvent, error) {
+	return sms.mysqlDao.GetPendingOutboxEvents(origin, afterId, limit)
+}
+
+func (sms *StudentMysqlService) GetFirstOutboxEvent(origin string, studentId string) (*model.OutboxEvent, error) {
+	return sms.mysqlDao.GetFirstOutboxEvent(origin, studentId)
+}
+
+func (sms *StudentMysqlService) DeleteOutboxEvent(id uint64) error {
+	return sms.mysqlDao.DeleteOutboxEvent(id)
+}
+
+func (sms *StudentMysqlService) MarkOutboxEventFailed(id uint64, reason string, nextAttemptAt time.Time) error {
+	return sms.mysqlDao.MarkOutboxEventFailed(id, reason, nextAttemptAt)
+}
+
+func (sms *StudentMysqlService) GetWriteBehindSeq(journalId string, studentId string) (uint64, error) {
+	return sms.mysqlDao.GetWriteBehindSeq(journalId, studentId)
+}
+
+// SetWriteBehindSeq 记录学生已经写入的日志序号 需要和学生数据在同一个 InTx 中调用
+func (sms *StudentMysqlService) SetWriteBehindSeq(journalId string, studentId string, seq uint64) error {
+	if err := sms.mysqlDao.SetWriteBehindSeq(journalId, studentId, seq); err != nil {
+		log.Printf("记录学生：%s写入的日志序号失败：%v", studentId, err)
+		return err
+	}
+	return nil
+}
+
+func (sms *StudentMysqlService) DeleteWriteBehindSeqs(journalId string, ackedSeq uint64) error {
+	return sms.mysqlDao.DeleteWriteBehindSeqs(journalId, ackedSeq)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"fmt"
+	"memoryDataBase/dao"
+	"memoryDataBase/database"
+	"memoryDataBase/migrations"
+	"memoryDataBase/model"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestStudentMysqlServiceOnSQLite 在临时的 SQLite 文件上执行迁移 再通过 StudentMysqlService 增删改学生
+func TestStudentMysqlServiceOnSQLite(t *testing.T) {
+	if err := database.InitDB(database.DriverSQLite, filepath.Join(t.TempDir(), "students.db")); err != nil {
+		t.Fatalf("打开 SQLite 数据库失败：%v", err)
+	}
+	db := database.DB
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("读取数据库迁移失败：%v", err)
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("执行数据库迁移失败：%v", err)
+	}
+	// 所有迁移都可以回滚后重新执行 再次执行 Up 不会重复执行
+	if _, err = migrator.Down(len(applied)); err != nil {
+		t.Fatalf("回滚数据库迁移失败：%v", err)
+	}
+	if again, err := migrator.Up(); err != nil || len(again) != len(applied) {
+		t.Fatalf("重新执行数据库迁移 = %d个, %v，期望%d个", len(again), err, len(applied))
+	}
+	if again, err := migrator.Up(); err != nil || len(again) != 0 {
+		t.Fatalf("第二次执行数据库迁移 = %d个, %v，期望没有需要执行的迁移", len(again), err)
+	}
+
+	sms := NewStudentMysqlService(dao.NewStudentMysqlDao(db))
+	audit := &model.AuditContext{Actor: "tester"}
+	err = sms.InTx(func(tx *StudentMysqlService) error {
+		return tx.AddStudentToMysql(newTestStudent("s1", "张三"), audit)
+	})
+	if err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	student, err := sms.GetStudentFromMysql("s1")
+	if err != nil || student.Name != "张三" || student.Version != 1 || student.Grades["语文"] != 85 {
+		t.Fatalf("添加后的学生 = %+v, %v", student, err)
+	}
+
+	var updated *model.Student
+	err = sms.InTx(func(tx *StudentMysqlService) error {
+		var err error
+		updated, err = tx.UpdateStudent(&model.Student{ID: "s1", Class: "二班", Grades: map[string]float64{"数学": 60, "英语": 70}, Version: 1}, audit)
+		return err
+	})
+	if err != nil || updated.Version != 2 {
+		t.Fatalf("更新学生 = %+v, %v，期望第2版", updated, err)
+	}
+	student, err = sms.GetStudentFromMysql("s1")
+	if err != nil || student.Name != "张三" || student.Class != "二班" || student.Version != 2 ||
+		student.Grades["数学"] != 60 || student.Grades["英语"] != 70 || student.Grades["语文"] != 85 {
+		t.Fatalf("更新后的学生 = %+v, %v", student, err)
+	}
+
+	// 版本号不一致时删除失败
+	err = sms.InTx(func(tx *StudentMysqlService) error {
+		return tx.DeleteStudent("s1", 1, audit)
+	})
+	if err == nil {
+		t.Fatalf("使用旧的版本号删除成功了")
+	}
+	err = sms.InTx(func(tx *StudentMysqlService) error {
+		return tx.DeleteStudent("s1", 2, audit)
+	})
+	if err != nil {
+		t.Fatalf("删除学生失败：%v", err)
+	}
+	if _, err = sms.GetStudentFromMysql("s1"); !sms.StudentNotFoundErr("s1", err) {
+		t.Fatalf("删除后读取学生的错误 = %v，期望找不到学生", err)
+	}
+	_, total, err := sms.GetStudentHistory("s1", 1, 100)
+	if err != nil || total == 0 {
+		t.Fatalf("学生的审计记录 = %d条, %v，期望有记录", total, err)
+	}
+}
+
+// TestStudentMysqlServiceConcurrentUpdates 在事务中先读取再更新 读到的版本号在提交前不会被其他事务修改
+func TestStudentMysqlServiceConcurrentUpdates(t *testing.T) {
+	sms := NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t)))
+	err := sms.InTx(func(tx *StudentMysqlService) error {
+		return tx.AddStudentToMysql(newTestStudent("s1", "张三"), nil)
+	})
+	if err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	const updates = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, updates)
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- sms.InTx(func(tx *StudentMysqlService) error {
+				current, err := tx.getStudentForUpdate("s1")
+				if err != nil {
+					return err
+				}
+				patch := &model.Student{ID: "s1", Grades: map[string]float64{fmt.Sprintf("科目%d", i): 60}, Version: current.Version}
+				_, err = tx.UpdateStudent(patch, nil)
+				return err
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("并发更新学生失败：%v", err)
+		}
+	}
+	student, err := sms.GetStudentFromMysql("s1")
+	if err != nil || student.Version != updates+1 || len(student.Grades) != updates+2 {
+		t.Fatalf("并发更新后的学生 = %+v, %v，期望第%d版和%d门成绩", student, err, updates+1, updates+2)
+	}
+}
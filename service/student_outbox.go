@@ -0,0 +1,307 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"memoryDataBase/model"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OutboxOptions 发件箱的配置
+type OutboxOptions struct {
+	// Origin 当前实例的标识 每个实例只处理自己写入的变更 因为内存是每个实例独有的
+	// 共享数据库的实例必须使用不同的标识 main 从 LoadOutboxOrigin 读取
+	Origin string
+	// RelayInterval 后台中继重试失败变更的间隔
+	RelayInterval time.Duration
+	// BatchSize 中继每次从发件箱读取的变更数
+	BatchSize int
+	// MaxAttempts 一条变更最多尝试的次数 之后从缓存和内存中淘汰该学生 下次读取时从数据库加载
+	MaxAttempts int
+	// MaxBackoff 失败的变更两次重试之间最长的间隔 第一次失败后等待 RelayInterval 之后每次加倍
+	MaxBackoff time.Duration
+}
+
+// DefaultOutboxOptions 返回发件箱的默认配置
+func DefaultOutboxOptions() OutboxOptions {
+	return OutboxOptions{
+		Origin:        "default",
+		RelayInterval: time.Second,
+		BatchSize:     100,
+		MaxAttempts:   10,
+		MaxBackoff:    time.Minute,
+	}
+}
+
+// outboxErrorLimit 发件箱中记录的错误信息的最大长度
+const outboxErrorLimit = 512
+
+// outboxLockStripes 按学号分段的锁的数量
+const outboxLockStripes = 64
+
+// studentOutbox 发件箱中继的状态 mu 保证后台中继不会并发执行
+// students 按学号分段加锁 请求应用自己的变更和中继应用同一个学生的变更不会并发 变更按写入顺序应用
+type studentOutbox struct {
+	options  OutboxOptions
+	mu       sync.Mutex
+	students [outboxLockStripes]sync.Mutex
+	wake     chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// studentLock 返回学生所在分段的锁
+func (o *studentOutbox) studentLock(id string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &o.students[h.Sum32()%outboxLockStripes]
+}
+
+// LoadOutboxOrigin 读取保存在 path 中的实例标识 第一次启动时生成随机标识并保存
+// 标识在重启后不变 实例重启后继续处理自己没有应用的变更
+func LoadOutboxOrigin(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("读取发件箱标识失败：%w", err)
+	}
+	buf := make([]byte, 16)
+	if _, err = rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成发件箱标识失败：%w", err)
+	}
+	origin := hex.EncodeToString(buf)
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("创建发件箱标识的目录失败：%w", err)
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, []byte(origin), 0o644); err != nil {
+		return "", fmt.Errorf("写入发件箱标识失败：%w", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("写入发件箱标识失败：%w", err)
+	}
+	return origin, nil
+}
+
+// startOutboxRelay 处理重启前没有应用的变更并启动后台中继 发件箱表由数据库迁移创建
+// 中继定期重试失败的变更 请求没有应用自己的变更时通过 wakeOutbox 唤醒中继
+func (ss *StudentService) startOutboxRelay() {
+	ss.outbox.wake = make(chan struct{}, 1)
+	ss.outbox.quit = make(chan struct{})
+	ss.outbox.done = make(chan struct{})
+	go func() {
+		defer close(ss.outbox.done)
+		ticker := time.NewTicker(ss.outbox.options.RelayInterval)
+		defer ticker.Stop()
+		for {
+			ss.relayOutbox()
+			select {
+			case <-ss.outbox.quit:
+				return
+			case <-ticker.C:
+			case <-ss.outbox.wake:
+			}
+		}
+	}()
+}
+
+// wakeOutbox 唤醒后台中继 中继正在执行时合并成一次唤醒
+func (ss *StudentService) wakeOutbox() {
+	select {
+	case ss.outbox.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (ss *StudentService) stopOutboxRelay() {
+	if ss.outbox.quit == nil {
+		return
+	}
+	close(ss.outbox.quit)
+	<-ss.outbox.done
+	ss.outbox.quit = nil
+}
+
+// recordOutbox 在 tx 的事务中写入一条缓存和内存的变更 删除时 student 为nil 返回写入的变更
+func (ss *StudentService) recordOutbox(tx *StudentMysqlService, op string, id string, student *model.Student) (*model.OutboxEvent, error) {
+	event := &model.OutboxEvent{StudentId: id, Op: op, Origin: ss.outbox.options.Origin}
+	if student != nil {
+		payload, err := json.Marshal(student)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = string(payload)
+	}
+	if err := tx.AddOutboxEvent(event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// applyOwnOutboxEvent 提交后立即把请求自己写入的变更应用到缓存和内存 不处理发件箱中的其他变更
+// 该学生还有更早的变更没有应用或者应用失败时交给后台中继 保证同一个学生的变更按写入顺序应用
+func (ss *StudentService) applyOwnOutboxEvent(event *model.OutboxEvent) {
+	lock := ss.outbox.studentLock(event.StudentId)
+	lock.Lock()
+	defer lock.Unlock()
+	first, err := ss.MysqlService.GetFirstOutboxEvent(ss.outbox.options.Origin, event.StudentId)
+	if err != nil {
+		log.Printf("读取发件箱中学生：%s的变更失败：%v", event.StudentId, err)
+		ss.wakeOutbox()
+		return
+	}
+	// 中继已经应用了这条变更
+	if first == nil || first.ID > event.ID {
+		return
+	}
+	if first.ID != event.ID {
+		ss.wakeOutbox()
+		return
+	}
+	if err = ss.applyOutboxEvent(event); err != nil {
+		log.Printf("应用发件箱中学生：%s的变更失败，由中继重试：%v", event.StudentId, err)
+		ss.wakeOutbox()
+		return
+	}
+	// 删除失败时变更会被中继再次应用 应用是幂等的
+	if err = ss.MysqlService.DeleteOutboxEvent(event.ID); err != nil {
+		log.Printf("删除发件箱变更：%d失败：%v", event.ID, err)
+	}
+}
+
+// relayOutbox 按写入顺序把本实例发件箱中的变更应用到缓存和内存 应用成功后删除
+// 一个学生的变更失败时跳过该学生之后的变更 保证同一个学生的变更不会乱序
+// 每条变更在一次处理中最多尝试一次 失败后记录下次重试的时间 到时间之前连同该学生之后的变更一起跳过
+func (ss *StudentService) relayOutbox() {
+	ss.outbox.mu.Lock()
+	defer ss.outbox.mu.Unlock()
+	now := time.Now().UTC()
+	blocked := make(map[string]bool)
+	var afterId uint64
+	for {
+		events, err := ss.MysqlService.GetPendingOutboxEvents(ss.outbox.options.Origin, afterId, ss.outbox.options.BatchSize)
+		if err != nil {
+			log.Printf("读取发件箱失败：%v", err)
+			return
+		}
+		for _, event := range events {
+			afterId = event.ID
+			if blocked[event.StudentId] {
+				continue
+			}
+			if !ss.relayOutboxEvent(event, now) {
+				blocked[event.StudentId] = true
+			}
+		}
+		if len(events) < ss.outbox.options.BatchSize {
+			return
+		}
+	}
+}
+
+// relayOutboxEvent 持有学生的锁应用一条变更 返回false时跳过该学生之后的变更
+// 读取这一批变更之后 请求可能已经应用了自己的变更 所以加锁后重新读取该学生最早的变更
+func (ss *StudentService) relayOutboxEvent(event *model.OutboxEvent, now time.Time) bool {
+	lock := ss.outbox.studentLock(event.StudentId)
+	lock.Lock()
+	defer lock.Unlock()
+	first, err := ss.MysqlService.GetFirstOutboxEvent(ss.outbox.options.Origin, event.StudentId)
+	if err != nil {
+		log.Printf("读取发件箱中学生：%s的变更失败：%v", event.StudentId, err)
+		return false
+	}
+	if first == nil || first.ID > event.ID {
+		return true
+	}
+	if first.ID != event.ID {
+		return false
+	}
+	event = first
+	if event.NextAttemptAt != nil && now.Before(*event.NextAttemptAt) {
+		return false
+	}
+	err = ss.applyOutboxEvent(event)
+	if err != nil && int(event.Attempts)+1 >= ss.outbox.options.MaxAttempts {
+		log.Printf("发件箱中学生：%s的变更多次应用失败，从缓存和内存中淘汰：%v", event.StudentId, err)
+		err = ss.evictStudent(event.StudentId)
+	}
+	if err != nil {
+		backoff := ss.outbox.options.backoff(int(event.Attempts) + 1)
+		log.Printf("应用发件箱中学生：%s的变更失败，%v后重试：%v", event.StudentId, backoff, err)
+		reason := err.Error()
+		if len(reason) > outboxErrorLimit {
+			reason = reason[:outboxErrorLimit]
+		}
+		if err = ss.MysqlService.MarkOutboxEventFailed(event.ID, reason, now.Add(backoff)); err != nil {
+			log.Printf("记录发件箱变更：%d的错误失败：%v", event.ID, err)
+		}
+		return false
+	}
+	// 删除失败时变更会被再次应用 应用是幂等的
+	if err = ss.MysqlService.DeleteOutboxEvent(event.ID); err != nil {
+		log.Printf("删除发件箱变更：%d失败：%v", event.ID, err)
+		return false
+	}
+	return true
+}
+
+// backoff 第 attempts 次失败后到下次重试的等待时间
+func (o OutboxOptions) backoff(attempts int) time.Duration {
+	backoff := o.RelayInterval
+	for i := 1; i < attempts && backoff < o.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > o.MaxBackoff {
+		backoff = o.MaxBackoff
+	}
+	return backoff
+}
+
+// evictStudent 从缓存和内存中淘汰学生
+func (ss *StudentService) evictStudent(id string) error {
+	if err := ss.CacheService.DeleteStudent(id); err != nil && !ss.StudentNotFoundErr(id, err) {
+		return err
+	}
+	ss.MdbService.Evict(id)
+	return nil
+}
+
+// applyOutboxEvent 把一条变更应用到缓存和内存 重复应用的结果相同
+func (ss *StudentService) applyOutboxEvent(event *model.OutboxEvent) error {
+	switch event.Op {
+	case studentOpAdd, studentOpUpdate:
+		var student model.Student
+		if err := json.Unmarshal([]byte(event.Payload), &student); err != nil {
+			return fmt.Errorf("解析发件箱变更：%d失败：%w", event.ID, err)
+		}
+		if event.Op == studentOpAdd {
+			if err := ss.CacheService.AddStudent(student.Copy()); err != nil {
+				return err
+			}
+			ss.MdbService.AddStudent(&student)
+			return nil
+		}
+		// 缓存或内存中没有该学生时不需要更新 下次读取时从数据库加载
+		if err := ss.CacheService.UpdateStudent(student.Copy()); err != nil && !ss.StudentNotFoundErr(student.ID, err) {
+			return err
+		}
+		if err := ss.MdbService.UpdateStudent(&student); err != nil && !ss.StudentNotFoundErr(student.ID, err) {
+			return err
+		}
+		return nil
+	case studentOpDelete:
+		return ss.evictStudent(event.StudentId)
+	default:
+		return fmt.Errorf("未知的发件箱变更类型：%s", event.Op)
+	}
+}
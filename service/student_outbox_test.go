@@ -0,0 +1,131 @@
+package service
+
+import (
+	"memoryDataBase/model"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRelayOutboxBackoff 失败的变更在一次处理中只尝试一次 到重试时间之前不会再次应用
+func TestRelayOutboxBackoff(t *testing.T) {
+	options := DefaultStudentServiceOptions()
+	options.Outbox.RelayInterval, options.Outbox.MaxBackoff = time.Hour, time.Hour
+	db := newTestDB(t)
+	ss := newTestStudentServiceWithOptions(t, db, "node1", options)
+
+	// 无法解析的变更每次应用都会失败 同一个学生之后的变更要等它成功后才应用
+	bad := &model.OutboxEvent{StudentId: "s1", Op: studentOpAdd, Origin: options.Outbox.Origin, Payload: "{"}
+	if err := ss.MysqlService.AddOutboxEvent(bad); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.recordOutbox(ss.MysqlService, studentOpAdd, "s1", newTestStudent("s1", "张三")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.recordOutbox(ss.MysqlService, studentOpAdd, "s2", newTestStudent("s2", "李四")); err != nil {
+		t.Fatal(err)
+	}
+
+	ss.relayOutbox()
+	ss.relayOutbox()
+	events, err := ss.MysqlService.GetPendingOutboxEvents(ss.outbox.options.Origin, 0, ss.outbox.options.BatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Payload != bad.Payload {
+		t.Fatalf("发件箱中剩余的变更 = %+v，期望失败的变更和同一个学生之后的变更", events)
+	}
+	if events[0].Attempts != 1 || events[0].NextAttemptAt == nil || time.Until(*events[0].NextAttemptAt) < 30*time.Minute {
+		t.Fatalf("失败的变更 = %+v，期望尝试1次并在约1小时后重试", events[0])
+	}
+	if _, err = ss.CacheService.GetStudentFromCache("s1"); err == nil {
+		t.Fatalf("失败的变更之后的变更被提前应用了")
+	}
+	if _, err = ss.CacheService.GetStudentFromCache("s2"); err != nil {
+		t.Fatalf("其他学生的变更没有应用：%v", err)
+	}
+
+	// 到重试时间后再次尝试
+	if err = db.Exec("update student_outbox set next_attempt_at = ? where id = ?", time.Now().UTC().Add(-time.Second), events[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	ss.relayOutbox()
+	events, err = ss.MysqlService.GetPendingOutboxEvents(ss.outbox.options.Origin, 0, ss.outbox.options.BatchSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Attempts != 2 {
+		t.Fatalf("到重试时间后发件箱中的变更 = %+v，期望失败的变更尝试2次", events)
+	}
+}
+
+// TestRequestAppliesOnlyOwnEvent 请求提交后只应用自己的变更 不处理其他学生积压的变更
+func TestRequestAppliesOnlyOwnEvent(t *testing.T) {
+	ss := newTestStudentService(t, newTestDB(t), "node1")
+	ss.stopOutboxRelay()
+	audit := model.AuditContext{Actor: "tester"}
+
+	backlog, err := ss.recordOutbox(ss.MysqlService, studentOpAdd, "s1", newTestStudent("s1", "张三"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.AddStudentInternal(newTestStudent("s2", "李四"), audit); err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	if _, err = ss.CacheService.GetStudentFromCache("s2"); err != nil {
+		t.Fatalf("请求自己的变更没有应用：%v", err)
+	}
+	if _, err = ss.CacheService.GetStudentFromCache("s1"); err == nil {
+		t.Fatalf("请求应用了其他学生积压的变更")
+	}
+	events, err := ss.MysqlService.GetPendingOutboxEvents(ss.outbox.options.Origin, 0, ss.outbox.options.BatchSize)
+	if err != nil || len(events) != 1 || events[0].ID != backlog.ID {
+		t.Fatalf("发件箱中剩余的变更 = %+v, %v，期望只有积压的变更", events, err)
+	}
+
+	// 同一个学生有更早的变更时 请求的变更留给中继按顺序应用
+	if _, err = ss.recordOutbox(ss.MysqlService, studentOpUpdate, "s1", newTestStudent("s1", "王五")); err != nil {
+		t.Fatal(err)
+	}
+	update, err := ss.recordOutbox(ss.MysqlService, studentOpUpdate, "s1", newTestStudent("s1", "赵六"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss.applyOwnOutboxEvent(update)
+	if _, err = ss.CacheService.GetStudentFromCache("s1"); err == nil {
+		t.Fatalf("先于更早的变更应用了请求的变更")
+	}
+	ss.relayOutbox()
+	cached, err := ss.CacheService.GetStudentFromCache("s1")
+	if err != nil || cached.Name != "赵六" {
+		t.Fatalf("中继应用后缓存中的学生 = %+v, %v，期望赵六", cached, err)
+	}
+	if events, _ = ss.MysqlService.GetPendingOutboxEvents(ss.outbox.options.Origin, 0, ss.outbox.options.BatchSize); len(events) != 0 {
+		t.Fatalf("中继后发件箱中还有变更：%+v", events)
+	}
+}
+
+// TestLoadOutboxOrigin 第一次读取时生成标识 之后读取到同一个标识 不同的文件得到不同的标识
+func TestLoadOutboxOrigin(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data", "outbox-origin")
+	origin, err := LoadOutboxOrigin(path)
+	if err != nil || origin == "" {
+		t.Fatalf("LoadOutboxOrigin = %q, %v", origin, err)
+	}
+	if again, err := LoadOutboxOrigin(path); err != nil || again != origin {
+		t.Fatalf("再次读取的标识 = %q, %v，期望 %q", again, err, origin)
+	}
+	if other, err := LoadOutboxOrigin(filepath.Join(dir, "other")); err != nil || other == origin {
+		t.Fatalf("另一个实例的标识 = %q, %v，期望和 %q 不同", other, err, origin)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	options := OutboxOptions{RelayInterval: time.Second, MaxBackoff: 10 * time.Second}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 30: 10 * time.Second} {
+		if got := options.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v，期望 %v", attempts, got, want)
+		}
+	}
+}
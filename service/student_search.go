@@ -0,0 +1,66 @@
+package service
+
+import (
+	"log"
+	"memoryDataBase/bus"
+	"memoryDataBase/model"
+	"memoryDataBase/search"
+)
+
+// nameIndexBatchSize 构建姓名索引时每批从数据库读取的学生数
+const nameIndexBatchSize = 500
+
+// BuildNameIndex 启动时用数据库中的所有学生构建姓名索引 异步写数据库时加上队列中还没有写入的变更
+func (ss *StudentService) BuildNameIndex() error {
+	ss.nameIndex.Reset()
+	err := ss.MysqlService.StreamStudents(nameIndexBatchSize, func(students []*model.Student) error {
+		for _, student := range students {
+			ss.nameIndex.Put(student.ID, student.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ss.writeBehind != nil {
+		for id, state := range ss.writeBehind.pendingStates() {
+			if state == nil {
+				ss.nameIndex.Remove(id)
+			} else {
+				ss.nameIndex.Put(id, state.Name)
+			}
+		}
+	}
+	log.Printf("已用%d个学生构建姓名索引", ss.nameIndex.Len())
+	return nil
+}
+
+// reindexStudent 其他实例添加或修改学生后 用事件中的姓名更新索引
+// 异步写数据库的实例发布事件时学生可能还没有写入数据库 所以不从数据库读取
+// 升级前的实例发布的事件中没有姓名 只能从数据库读取
+func (ss *StudentService) reindexStudent(event bus.Event) {
+	id := event.StudentID
+	if event.Name != "" {
+		ss.nameIndex.Put(id, event.Name)
+		return
+	}
+	student, err := ss.MysqlService.GetStudentFromMysql(id)
+	if ss.MysqlService.StudentNotFoundErr(id, err) {
+		ss.nameIndex.Remove(id)
+		return
+	}
+	if err != nil {
+		log.Printf("更新学生：%s的姓名索引失败：%v", id, err)
+		return
+	}
+	ss.nameIndex.Put(id, student.Name)
+}
+
+// SearchStudents 按姓名模糊搜索学生 支持前缀 子串 拼写错误和拼音 返回最多 limit 个结果
+func (ss *StudentService) SearchStudents(query string, limit int) []search.Match {
+	matches := ss.nameIndex.Search(query, limit)
+	if matches == nil {
+		matches = make([]search.Match, 0)
+	}
+	return matches
+}
@@ -5,27 +5,100 @@ import (
 	"fmt"
 	raftfpk "github.com/hashicorp/raft"
 	"log"
+	"memoryDataBase/bloom"
+	"memoryDataBase/bus"
+	"memoryDataBase/hotkey"
 	"memoryDataBase/interfaces"
 	"memoryDataBase/model"
 	"memoryDataBase/raft"
 	"memoryDataBase/raft/fsm"
+	"memoryDataBase/search"
 	"strings"
+	"sync"
 	"time"
 )
 
+// StudentServiceOptions 学生服务的配置 由 main 根据命令行参数填写 从 DefaultStudentServiceOptions 开始修改
+type StudentServiceOptions struct {
+	Outbox      OutboxOptions
+	WriteBehind WriteBehindOptions
+	AccessCount AccessCountOptions
+	HotStudents HotStudentsOptions
+	Filter      StudentFilterOptions
+	SoftDelete  SoftDeleteOptions
+	// EarlyRefreshBeta 内存中的学生提前刷新的激进程度 越大越早刷新 0表示不提前刷新
+	EarlyRefreshBeta float64
+}
+
+// DefaultStudentServiceOptions 返回学生服务的默认配置
+func DefaultStudentServiceOptions() StudentServiceOptions {
+	return StudentServiceOptions{
+		Outbox:      DefaultOutboxOptions(),
+		WriteBehind: DefaultWriteBehindOptions(),
+		AccessCount: DefaultAccessCountOptions(),
+		HotStudents: DefaultHotStudentsOptions(),
+		Filter:      DefaultStudentFilterOptions(),
+		SoftDelete:  DefaultSoftDeleteOptions(),
+	}
+}
+
 type StudentService struct {
+	options      StudentServiceOptions
 	MdbService   *StudentMdbService
 	MysqlService *StudentMysqlService
 	CacheService *StudentCacheService
 	raftNode     *raftfpk.Raft
+	loader       studentLoader
+	// studentFilter 所有学号的布隆过滤器 没有启用时为nil filterReady 为1表示已经构建或从快照恢复
+	// filterRebuild 正在从数据库重新构建的过滤器 构建期间添加的学号同时写入 filterMu 保护这两个过滤器的添加和替换
+	studentFilter *bloom.Filter
+	filterReady   int32
+	filterRebuild *bloom.Filter
+	filterMu      sync.Mutex
+	// invalidationBus 跨实例的失效通知 没有启用时为nil
+	invalidationBus bus.InvalidationBus
+	// invalidationBase 启用失效通知时的时间 加在序号上 实例重启后序号仍然递增
+	// invalidationSeq 异步写数据库时发布的失效通知的序号 从 invalidationBase 开始
+	invalidationBase int64
+	invalidationSeq  int64
+	// outbox 发件箱中继的状态
+	outbox studentOutbox
+	// writeBehind 异步写数据库 没有启用时为nil 写请求同步写入数据库
+	writeBehind *studentWriteBehind
+	// accessCounter 在内存中累积访问次数 定期批量写入数据库
+	accessCounter *accessCounter
+	// hotStudents 按时间衰减统计的热点学生 决定重新加载缓存和启动时加载到内存的学生
+	hotStudents *hotkey.TopK
+	// nameIndex 姓名的倒排索引 用于按姓名模糊搜索学生
+	nameIndex *search.Index
 }
 
-func NewStudentService(mdbService *StudentMdbService, mysqlService *StudentMysqlService, cacheService *StudentCacheService, localID string) (*StudentService, error) {
+func NewStudentService(mdbService *StudentMdbService, mysqlService *StudentMysqlService, cacheService *StudentCacheService,
+	localID string, options StudentServiceOptions) (*StudentService, error) {
 	ss := &StudentService{
+		options:      options,
 		MdbService:   mdbService,
 		MysqlService: mysqlService,
 		CacheService: cacheService,
 	}
+	ss.loader.earlyRefreshBeta = options.EarlyRefreshBeta
+	if options.Filter.Enabled {
+		ss.studentFilter = bloom.New(options.Filter.ExpectedItems, options.Filter.FalsePositiveRate)
+	}
+	if options.WriteBehind.Enabled {
+		writeBehind, err := newStudentWriteBehind(mysqlService, options.WriteBehind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open write-behind journal: %w", err)
+		}
+		ss.writeBehind = writeBehind
+		log.Printf("已启用异步写数据库，本地日志目录：%s", options.WriteBehind.Dir)
+	}
+
+	ss.outbox.options = options.Outbox
+	ss.startOutboxRelay()
+	ss.accessCounter = newAccessCounter(mysqlService, options.AccessCount)
+	ss.hotStudents = hotkey.New(options.HotStudents.K, options.HotStudents.HalfLife)
+	ss.nameIndex = search.New()
 
 	initializer := &raft.RaftInitializerImpl{}
 	// 初始化 Raft 节点
@@ -47,12 +120,13 @@ func (ss *StudentService) StudentNotFoundErr(studentId string, err error) bool {
 	return strings.Contains(err.Error(), studentNotFoundErrMsg)
 }
 
-func (ss *StudentService) applyRaftCommand(operation string, student *model.Student, id string) error {
+func (ss *StudentService) applyRaftCommand(operation string, student *model.Student, id string, audit model.AuditContext) error {
 	// 创建 Raft 命令
 	cmd := fsm.StudentCommand{
 		Operation: operation,
 		Student:   student,
 		Id:        id,
+		Audit:     audit,
 	}
 	// 序列化命令
 	cmdData, err := json.Marshal(cmd)
@@ -72,23 +146,8 @@ func (ss *StudentService) applyRaftCommand(operation string, student *model.Stud
 	return nil
 }
 
-// RestoreCacheData 恢复缓存机制 mysql有事务可以很方便地回滚 此函数专门用于恢复缓存的数据
-func (ss *StudentService) RestoreCacheData(id string) error {
-	//如果要恢复数据 mysql的事务会回滚 所以这个时候找到的学生还是一开始的学生
-	studentBackUp, err := ss.MysqlService.GetStudentFromMysql(id)
-	if err != nil {
-		log.Printf("尝试通过学生id：%s获取学生时失败：%v", id, err)
-		return err
-	}
-	if err = ss.CacheService.AddStudent(studentBackUp); err != nil {
-		log.Printf("尝试恢复缓存数据时失败: %v", err)
-		return err
-	}
-	return nil
-}
-
 func (ss *StudentService) ReloadCacheDataInternal() {
-	students, err := ss.MysqlService.GetHotStudentsFromMysql()
+	students, err := ss.hotStudentsFromStore()
 	if err != nil {
 		log.Printf("获得访问最多的学生时出错：%v", err)
 	}
@@ -118,7 +177,7 @@ func (ss *StudentService) LoadCacheToMemory() error {
 }
 
 func (ss *StudentService) LoadDateBaseToMemory() error {
-	students, err := ss.MysqlService.GetHotStudentsFromMysql()
+	students, err := ss.hotStudentsFromStore()
 	if err != nil {
 		log.Printf("从数据库中获取热门学生时失败：%v", err)
 		return err
@@ -130,55 +189,81 @@ func (ss *StudentService) LoadDateBaseToMemory() error {
 	return nil
 }
 
-func (ss *StudentService) AddStudentInternal(student *model.Student) error {
-	// 开始 MySQL 事务
-	tx := ss.MysqlService.mysqlDao.DB.Begin()
-	if tx.Error != nil {
-		log.Printf("开启 MySQL 事务失败：%v", tx.Error)
-		return tx.Error
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			// 发生 panic 时回滚事务
-			tx.Rollback()
-			log.Printf("事务已回滚：%v", r)
-		}
-	}()
-
-	// 在 MySQL 数据库事务中添加学生信息
-	if err := ss.MysqlService.AddStudentToMysql(tx, student); err != nil {
-		return err
+// AddStudentInternal 添加学生 新学生的版本号从1开始 audit 是发起变更的人和请求 写入审计记录
+func (ss *StudentService) AddStudentInternal(student *model.Student, audit model.AuditContext) error {
+	if ss.writeBehind != nil {
+		return ss.addStudentWriteBehind(student, audit)
 	}
-
-	// MySQL 数据库事务提交成功后，尝试添加到缓存
-	if err := ss.CacheService.AddStudent(student); err != nil {
-		tx.Rollback()
-		log.Printf("事务已回滚")
+	student.Version = 1
+	var event *model.OutboxEvent
+	// 在 MySQL 数据库事务中添加学生信息
+	err := ss.MysqlService.InTx(func(tx *StudentMysqlService) error {
+		if err := tx.AddStudentToMysql(student, &audit); err != nil {
+			return err
+		}
+		if err := tx.AddStudentCount(student.ID); err != nil {
+			return err
+		}
+		// 缓存和内存的变更写入发件箱 和学生数据在同一个事务中提交
+		var err error
+		event, err = ss.recordOutbox(tx, studentOpAdd, student.ID, student)
 		return err
-	}
-	// 最后添加到内存数据库
-	ss.MdbService.AddStudent(student)
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("提交事务失败：%v", err)
+	})
+	if err != nil {
+		log.Printf("添加学生：%s的事务失败：%v", student.ID, err)
 		return err
 	}
-	ss.MysqlService.AddStudentCount(student.ID)
+	// 提交后立即应用到缓存和内存 失败的变更由中继在后台重试
+	ss.applyOwnOutboxEvent(event)
+	ss.rememberStudent(student.ID)
+	ss.nameIndex.Put(student.ID, student.Name)
+	ss.publishChange(bus.OpAdd, student.ID, student.Name, ss.outboxSeq(event))
 	return nil
 }
 
 func (ss *StudentService) GetStudent(id string) (*model.Student, error) {
 	// 先从内存中查找学生
-	student, memoryErr := ss.MdbService.GetStudent(id)
+	student, _ := ss.MdbService.GetStudent(id)
 	if student != nil {
-		ss.MysqlService.AddStudentCount(id)
+		ss.accessCounter.record(id)
+		ss.hotStudents.Add(id)
 		log.Printf("从内存中查找到了学生：%s", id)
+		ss.refreshEarly(id)
+		return student, nil
+	}
+
+	// 最近确认过不存在或者布隆过滤器中没有的学号直接返回 不访问缓存和数据库
+	if ss.rejectMissingStudent(id) {
+		return nil, studentNotFound(id)
+	}
+
+	// 内存中没有时合并同一个学生的并发请求 只有一个请求去缓存和数据库中加载
+	student, err := ss.loader.load(id, func() (*model.Student, error) {
+		student, err := ss.loadStudent(id)
+		if ss.MysqlService.StudentNotFoundErr(id, err) {
+			ss.MdbService.MarkMissing(id)
+		}
+		return student, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ss.accessCounter.record(id)
+	ss.hotStudents.Add(id)
+	return student, nil
+}
+
+// loadStudent 依次从内存 缓存和数据库中查找学生 并写回上层
+func (ss *StudentService) loadStudent(id string) (*model.Student, error) {
+	// 等待加载期间其他请求可能已经把学生写回了内存
+	student, memoryErr := ss.MdbService.GetStudent(id)
+	if student != nil {
 		return student, nil
 	}
 
 	//再从缓存中查找学生
 	student, cacheErr := ss.CacheService.GetStudentFromCache(id)
 	if student != nil {
-		ss.MysqlService.AddStudentCount(id)
 		log.Printf("从缓存中查找到了学生：%s", id)
 		//向内存中添加学生
 		if ss.StudentNotFoundErr(id, memoryErr) {
@@ -189,120 +274,106 @@ func (ss *StudentService) GetStudent(id string) (*model.Student, error) {
 	}
 
 	// 最后从数据库中查找学生
-	student, mysqlErr := ss.MysqlService.GetStudentFromMysql(id)
+	student, mysqlErr := ss.studentFromStore(id)
 	if mysqlErr != nil {
 		return nil, mysqlErr
 	}
-	if student != nil {
-		ss.MysqlService.AddStudentCount(id)
-		log.Printf("在数据库中查找到了学生：%s", id)
-		//向内存和缓存中添加学生
-		if ss.StudentNotFoundErr(id, memoryErr) {
-			ss.MdbService.AddStudent(student)
-			log.Printf("从数据库向内存中添加学生：%s", id)
-		}
-		if ss.StudentNotFoundErr(id, cacheErr) {
-			err := ss.CacheService.AddStudent(student)
-			if err != nil {
-				log.Printf("从数据库向缓存中添加学生：%s失败：%v", id, err)
-			} else {
-				log.Printf("从数据库向缓存中添加学生：%s", id)
-			}
+	log.Printf("在数据库中查找到了学生：%s", id)
+	//向内存和缓存中添加学生
+	if ss.StudentNotFoundErr(id, memoryErr) {
+		ss.MdbService.AddStudent(student)
+		log.Printf("从数据库向内存中添加学生：%s", id)
+	}
+	if ss.StudentNotFoundErr(id, cacheErr) {
+		err := ss.CacheService.AddStudent(student)
+		if err != nil {
+			log.Printf("从数据库向缓存中添加学生：%s失败：%v", id, err)
+		} else {
+			log.Printf("从数据库向缓存中添加学生：%s", id)
 		}
-		return student, nil
 	}
-	//其实这是不可到达的代码 因为不存在既没有错误又没有学生的情况。。没有学生也是一个错误
-	return nil, nil
+	return student, nil
 }
 
-func (ss *StudentService) UpdateStudentInternal(student *model.Student) error {
-	// 开始 MySQL 事务
-	tx := ss.MysqlService.mysqlDao.DB.Begin()
-	if tx.Error != nil {
-		log.Printf("开启 MySQL 事务失败：%v", tx.Error)
-		return tx.Error
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			// 发生 panic 时回滚事务
-			tx.Rollback()
-			log.Printf("事务已回滚：%v", r)
+// refreshEarly 内存中的学生快要过期时 按概率在后台从数据库重新加载 避免过期后大量请求同时穿透
+func (ss *StudentService) refreshEarly(id string) {
+	remaining, exists := ss.MdbService.StudentTTL(id)
+	if !exists || !ss.loader.shouldRefreshEarly(remaining) {
+		return
+	}
+	log.Printf("学生：%s即将过期，在后台提前刷新", id)
+	ss.loader.refresh(id, func() error {
+		student, err := ss.studentFromStore(id)
+		if err != nil {
+			return err
 		}
-	}()
+		ss.MdbService.AddStudent(student)
+		return ss.CacheService.AddStudent(student)
+	})
+}
 
-	if err := ss.MysqlService.UpdateStudent(tx, student); err != nil {
-		log.Printf("更新学生：%s时失败：%v", student.ID, err)
-		return err
-	}
-	if err := ss.CacheService.UpdateStudent(student); err != nil {
-		if !ss.StudentNotFoundErr(student.ID, err) {
-			log.Printf("已回滚数据库事务")
-			log.Printf("更新缓存中的学生：%s时失败：%v", student.ID, err)
-			tx.Rollback()
+// LoaderStats 返回读穿透加载的统计信息
+func (ss *StudentService) LoaderStats() LoaderStats {
+	return ss.loader.stats()
+}
+
+// UpdateStudentInternal 更新学生 只有学生当前的版本号等于 student.Version 才会更新
+// 更新成功后 student.Version 是更新后的版本号
+func (ss *StudentService) UpdateStudentInternal(student *model.Student, audit model.AuditContext) error {
+	if ss.writeBehind != nil {
+		return ss.updateStudentWriteBehind(student, audit)
+	}
+	var state *model.Student
+	var event *model.OutboxEvent
+	err := ss.MysqlService.InTx(func(tx *StudentMysqlService) error {
+		var err error
+		state, err = tx.UpdateStudent(student, &audit)
+		if err != nil {
 			return err
 		}
-	}
-	if err := ss.MdbService.UpdateStudent(student); err != nil {
-		if !ss.StudentNotFoundErr(student.ID, err) {
-			log.Printf("更新内存中的学生：%s时失败：%v", student.ID, err)
-			tx.Rollback()
-			if err = ss.RestoreCacheData(student.ID); err != nil {
-				log.Printf("尝试恢复缓存数据时失败：%v", err)
-				return err
-			}
-			log.Printf("回滚数据库事务并恢复缓存数据")
+		if err = tx.AddStudentCount(student.ID); err != nil {
 			return err
 		}
-	}
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("回滚事务失败：%v", err)
+		// 发件箱中记录更新后的完整状态 缓存和内存中的版本号和数据库一致
+		event, err = ss.recordOutbox(tx, studentOpUpdate, student.ID, state)
+		return err
+	})
+	if err != nil {
+		log.Printf("更新学生：%s时失败：%v", student.ID, err)
 		return err
 	}
-	ss.MysqlService.AddStudentCount(student.ID)
+	student.Version = state.Version
+	ss.applyOwnOutboxEvent(event)
+	ss.nameIndex.Put(state.ID, state.Name)
+	ss.publishChange(bus.OpUpdate, student.ID, state.Name, ss.outboxSeq(event))
 	return nil
 }
 
-func (ss *StudentService) DeleteStudentInternal(id string) error {
-	// 开始 MySQL 事务
-	tx := ss.MysqlService.mysqlDao.DB.Begin()
-	if tx.Error != nil {
-		log.Printf("开启 MySQL 事务失败：%v", tx.Error)
-		return tx.Error
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			// 发生 panic 时回滚事务
-			tx.Rollback()
-			log.Printf("事务已回滚：%v", r)
-		}
-	}()
-
-	if err := ss.CacheService.DeleteStudent(id); err != nil {
-		if !ss.StudentNotFoundErr(id, err) {
-			tx.Rollback()
-			log.Printf("已回滚数据库事务")
-			log.Printf("从缓存中删除学生：%s失败：%v", id, err)
+// DeleteStudentInternal 删除学生 只有学生当前的版本号等于 version 才会删除
+func (ss *StudentService) DeleteStudentInternal(id string, version int64, audit model.AuditContext) error {
+	if ss.writeBehind != nil {
+		return ss.deleteStudentWriteBehind(id, version, audit)
+	}
+	var event *model.OutboxEvent
+	err := ss.MysqlService.InTx(func(tx *StudentMysqlService) error {
+		err := tx.DeleteStudent(id, version, &audit)
+		if err != nil {
 			return err
 		}
-		log.Printf("缓存中不存在学生：%s", id)
-	}
-	if err := ss.MdbService.DeleteStudent(id); err != nil {
-		if !ss.StudentNotFoundErr(id, err) {
-			tx.Rollback()
-			log.Printf("从内存中删除学生：%s失败：%v", id, err)
-			if err = ss.RestoreCacheData(id); err != nil {
-				log.Printf("尝试恢复缓存数据时失败：%v", err)
-				return err
-			}
-			log.Printf("回滚数据库事务并恢复缓存")
-		}
-		log.Printf("内存中不存在学生：%s", id)
-	}
-	if err := tx.Commit().Error; err != nil {
-		log.Printf("回滚事务失败：%v", err)
+		event, err = ss.recordOutbox(tx, studentOpDelete, id, nil)
+		return err
+	})
+	if err != nil {
+		log.Printf("删除学生：%s时失败：%v", id, err)
 		return err
 	}
-	ss.MysqlService.DeleteStudentCount(id)
+	ss.applyOwnOutboxEvent(event)
+	ss.forgetStudent(id)
+	ss.nameIndex.Remove(id)
+	ss.publishChange(bus.OpDelete, id, "", ss.outboxSeq(event))
+	// 访问次数保留到学生被彻底删除 恢复的学生仍然有之前的访问次数
+	ss.accessCounter.forget(id)
+	ss.hotStudents.Remove(id)
 	return nil
 }
 
@@ -312,7 +383,7 @@ func (ss *StudentService) ReloadCacheData(interval time.Duration) {
 	for {
 		select {
 		case <-ticker.C:
-			err := ss.applyRaftCommand("reloadCacheData", nil, "")
+			err := ss.applyRaftCommand("reloadCacheData", nil, "", model.AuditContext{})
 			if err != nil {
 				log.Printf("分布式加载缓存数据失败: %v，跳过这次操作：%v", err, time.Now())
 				continue
@@ -327,7 +398,7 @@ func (ss *StudentService) PeriodicDelete(interval time.Duration) {
 	for {
 		select {
 		case <-ticker.C:
-			err := ss.applyRaftCommand("periodicDelete", nil, "")
+			err := ss.applyRaftCommand("periodicDelete", nil, "", model.AuditContext{})
 			if err != nil {
 				log.Printf("分布式删除内存数据库过期键失败：: %v，跳过这次操作：%v", err, time.Now())
 				continue
@@ -336,14 +407,19 @@ func (ss *StudentService) PeriodicDelete(interval time.Duration) {
 	}
 }
 
-func (ss *StudentService) AddStudent(student *model.Student) error {
-	return ss.applyRaftCommand("add", student, "")
+func (ss *StudentService) AddStudent(student *model.Student, audit model.AuditContext) error {
+	return ss.applyRaftCommand("add", student, "", audit)
 }
 
-func (ss *StudentService) UpdateStudent(student *model.Student) error {
-	return ss.applyRaftCommand("update", student, "")
+func (ss *StudentService) UpdateStudent(student *model.Student, audit model.AuditContext) error {
+	return ss.applyRaftCommand("update", student, "", audit)
 }
 
-func (ss *StudentService) DeleteStudent(id string) error {
-	return ss.applyRaftCommand("delete", nil, id)
+// DeleteStudent 通过 Raft 删除学生 条件删除时期望的版本号放在命令的学生中
+func (ss *StudentService) DeleteStudent(id string, version int64, audit model.AuditContext) error {
+	var expected *model.Student
+	if version > 0 {
+		expected = &model.Student{ID: id, Version: version}
+	}
+	return ss.applyRaftCommand("delete", expected, id, audit)
 }
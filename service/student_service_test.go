@@ -0,0 +1,146 @@
+package service
+
+import (
+	"gorm.io/gorm"
+	"memoryDataBase/dao"
+	"memoryDataBase/database"
+	"memoryDataBase/migrations"
+	"memoryDataBase/model"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB 在临时目录中创建 SQLite 数据库并执行所有迁移
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	if err := database.InitDB(database.DriverSQLite, filepath.Join(t.TempDir(), "students.db")); err != nil {
+		t.Fatalf("打开 SQLite 数据库失败：%v", err)
+	}
+	db := database.DB
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("读取数据库迁移失败：%v", err)
+	}
+	if _, err = migrator.Up(); err != nil {
+		t.Fatalf("执行数据库迁移失败：%v", err)
+	}
+	return db
+}
+
+// newTestStudentService 创建使用 SQLite 和进程内缓存的学生服务 不需要 Redis
+func newTestStudentService(t *testing.T, db *gorm.DB, localID string) *StudentService {
+	t.Helper()
+	return newTestStudentServiceWithOptions(t, db, localID, DefaultStudentServiceOptions())
+}
+
+// newTestStudentServiceWithOptions 和 newTestStudentService 相同 使用指定的配置
+func newTestStudentServiceWithOptions(t *testing.T, db *gorm.DB, localID string, options StudentServiceOptions) *StudentService {
+	t.Helper()
+	// Raft 的快照写在工作目录下 切换到临时目录
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(db))
+	cacheService := NewStudentCacheService(dao.NewStudentLocalCacheDao(dao.DefaultStudentCacheOptions()))
+	mdbService := NewStudentMdbService(dao.NewMemoryNamespaceDao(), DefaultNegativeCacheTTL)
+	ss, err := NewStudentService(mdbService, mysqlService, cacheService, localID, options)
+	if err != nil {
+		t.Fatalf("创建学生服务失败：%v", err)
+	}
+	t.Cleanup(func() {
+		ss.Close()
+		ss.raftNode.Shutdown().Error()
+	})
+	return ss
+}
+
+func newTestStudent(id, name string) *model.Student {
+	return &model.Student{
+		ID:     id,
+		Name:   name,
+		Gender: "男",
+		Class:  "一班",
+		Grades: map[string]float64{"数学": 90, "语文": 85},
+	}
+}
+
+// TestStudentServiceWithoutRedis 学生服务只依赖 SQLite 和进程内缓存时可以完成增删改查
+func TestStudentServiceWithoutRedis(t *testing.T) {
+	ss := newTestStudentService(t, newTestDB(t), "node1")
+	audit := model.AuditContext{Actor: "tester"}
+
+	if err := ss.AddStudentInternal(newTestStudent("s1", "张三"), audit); err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	student, err := ss.GetStudent("s1")
+	if err != nil || student.Name != "张三" || student.Version != 1 {
+		t.Fatalf("GetStudent = %+v, %v，期望张三的第1版", student, err)
+	}
+	cached, err := ss.CacheService.GetStudentFromCache("s1")
+	if err != nil || cached.Grades["数学"] != 90 {
+		t.Fatalf("缓存中的学生 = %+v, %v", cached, err)
+	}
+
+	update := &model.Student{ID: "s1", Name: "李四", Grades: map[string]float64{"数学": 95}, Version: 1}
+	if err = ss.UpdateStudentInternal(update, audit); err != nil {
+		t.Fatalf("更新学生失败：%v", err)
+	}
+	if update.Version != 2 {
+		t.Fatalf("更新后的版本号 = %d，期望 2", update.Version)
+	}
+	stored, err := ss.MysqlService.GetStudentFromMysql("s1")
+	if err != nil || stored.Name != "李四" || stored.Grades["数学"] != 95 || stored.Grades["语文"] != 85 {
+		t.Fatalf("数据库中的学生 = %+v, %v", stored, err)
+	}
+	// 没有版本号或者使用旧的版本号更新会失败 数据库中的学生不变
+	if err = ss.UpdateStudentInternal(&model.Student{ID: "s1", Name: "王五"}, audit); !ss.VersionMismatchErr(err) {
+		t.Fatalf("没有版本号的更新返回 %v，期望版本号不匹配", err)
+	}
+	stale := &model.Student{ID: "s1", Name: "王五", Version: 1}
+	if err = ss.UpdateStudentInternal(stale, audit); err == nil {
+		t.Fatalf("使用旧的版本号更新成功了")
+	}
+	if stored, _ = ss.MysqlService.GetStudentFromMysql("s1"); stored.Name != "李四" {
+		t.Fatalf("版本号冲突的更新写入了数据库：%+v", stored)
+	}
+
+	if err = ss.DeleteStudentInternal("s1", 2, audit); err != nil {
+		t.Fatalf("删除学生失败：%v", err)
+	}
+	if _, err = ss.GetStudent("s1"); !ss.MysqlService.StudentNotFoundErr("s1", err) {
+		t.Fatalf("删除后 GetStudent 的错误 = %v，期望找不到学生", err)
+	}
+	if _, err = ss.CacheService.GetStudentFromCache("s1"); err == nil {
+		t.Fatalf("删除后缓存中仍然有学生")
+	}
+}
+
+// TestStudentMysqlServiceRollback InTx 中返回错误时之前的写入都被回滚
+func TestStudentMysqlServiceRollback(t *testing.T) {
+	sms := NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t)))
+	err := sms.InTx(func(tx *StudentMysqlService) error {
+		if err := tx.AddStudentToMysql(newTestStudent("s1", "张三"), nil); err != nil {
+			return err
+		}
+		// 学号重复 插入失败
+		return tx.AddStudentToMysql(newTestStudent("s1", "张三"), nil)
+	})
+	if err == nil {
+		t.Fatalf("重复添加学生成功了")
+	}
+	if _, err = sms.GetStudentFromMysql("s1"); !sms.StudentNotFoundErr("s1", err) {
+		t.Fatalf("事务失败后学生仍然写入了数据库：%v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"memoryDataBase/bus"
+	"memoryDataBase/dao"
+	"memoryDataBase/model"
+	"strings"
+	"time"
+)
+
+// SoftDeleteOptions 软删除的配置
+type SoftDeleteOptions struct {
+	// Retention 删除的学生在这段时间内可以恢复 之后由清理任务彻底删除
+	Retention time.Duration
+	// PurgeBatchSize 清理任务每个事务最多彻底删除的学生数
+	PurgeBatchSize int
+}
+
+// DefaultSoftDeleteOptions 返回软删除的默认配置
+func DefaultSoftDeleteOptions() SoftDeleteOptions {
+	return SoftDeleteOptions{Retention: 7 * 24 * time.Hour, PurgeBatchSize: 500}
+}
+
+// studentOpRestore 恢复学生 只用于审计记录 发件箱中按添加处理
+const studentOpRestore = "restore"
+
+// 恢复和添加失败时的错误信息
+const (
+	restoreExpiredErrMsg = "已经超过保留期"
+	restorePendingErrMsg = "还有没有写入数据库的变更"
+	// deletedConflictErrMsg 学号属于保留期内被删除的学生 需要先恢复或者等待彻底删除
+	deletedConflictErrMsg = "已被删除，可以恢复或在彻底删除后重新添加"
+)
+
+// deletedConflict 返回学号属于被软删除的学生时添加失败的错误
+func deletedConflict(id string) error {
+	return fmt.Errorf("学生：%s%s", id, deletedConflictErrMsg)
+}
+
+// checkNotDeleted 学号属于还没有彻底删除的学生时返回错误 添加不会覆盖可以恢复的学生
+func (sms *StudentMysqlService) checkNotDeleted(id string) error {
+	_, err := sms.mysqlDao.GetDeletedStudent(id)
+	if err == nil {
+		return deletedConflict(id)
+	}
+	if strings.Contains(err.Error(), fmt.Sprintf("数据库不存在被删除的学生：%s", id)) {
+		return nil
+	}
+	return err
+}
+
+// RestoreStudent 恢复保留期 retention 内被软删除的学生 需要在 InTx 中调用 返回恢复后学生的完整状态
+func (sms *StudentMysqlService) RestoreStudent(id string, retention time.Duration, audit *model.AuditContext) (*model.Student, error) {
+	deleted, err := sms.mysqlDao.GetDeletedStudent(id)
+	if err != nil {
+		return nil, err
+	}
+	deletedAfter := time.Now().UTC().Add(-retention)
+	if deleted.DeletedAt == nil || deleted.DeletedAt.Before(deletedAfter) {
+		return nil, fmt.Errorf("学生：%s删除%s，无法恢复", id, restoreExpiredErrMsg)
+	}
+	// 条件更新只恢复仍然在保留期内被删除的学生 之前读到的状态可能已经过时
+	if err = sms.mysqlDao.RestoreStudent(id, deletedAfter); err != nil {
+		log.Printf("恢复学生：%s失败：%v", id, err)
+		return nil, err
+	}
+	// 恢复后在同一个事务中重新读取并锁住学生 返回的版本号就是这次写入的版本号
+	state, err := sms.getStudentForUpdate(id)
+	if err != nil {
+		return nil, err
+	}
+	if audit != nil {
+		if err = sms.RecordChange(studentOpRestore, nil, state, *audit); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("恢复学生：%s", id)
+	return state, nil
+}
+
+// PurgeDeletedStudents 彻底删除超过保留期的学生 返回删除的学生数
+func (sms *StudentMysqlService) PurgeDeletedStudents(options SoftDeleteOptions) (int, error) {
+	purged := 0
+	for {
+		ids, err := sms.mysqlDao.GetStudentsDeletedBefore(time.Now().UTC().Add(-options.Retention), options.PurgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		if len(ids) == 0 {
+			return purged, nil
+		}
+		err = sms.mysqlDao.InTx(func(repo dao.StudentRepository) error {
+			for _, id := range ids {
+				if err := repo.PurgeStudent(id); err != nil {
+					return fmt.Errorf("彻底删除学生：%s失败：%w", id, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += len(ids)
+		if len(ids) < options.PurgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// RestoreStudentInternal 恢复保留期内被删除的学生 恢复后版本号加一
+func (ss *StudentService) RestoreStudentInternal(id string, audit model.AuditContext) error {
+	// 异步写数据库时 队列中的删除还没有写入数据库 恢复会被之后写入的删除覆盖
+	if ss.writeBehind != nil {
+		if _, found := ss.writeBehind.lookup(id); found {
+			return fmt.Errorf("学生：%s%s，请稍后重试", id, restorePendingErrMsg)
+		}
+	}
+	var state *model.Student
+	var event *model.OutboxEvent
+	err := ss.MysqlService.InTx(func(tx *StudentMysqlService) error {
+		var err error
+		state, err = tx.RestoreStudent(id, ss.options.SoftDelete.Retention, &audit)
+		if err != nil {
+			return err
+		}
+		// 恢复的学生按添加写入缓存和内存
+		event, err = ss.recordOutbox(tx, studentOpAdd, id, state)
+		return err
+	})
+	if err != nil {
+		log.Printf("恢复学生：%s时失败：%v", id, err)
+		return err
+	}
+	ss.applyOwnOutboxEvent(event)
+	ss.rememberStudent(id)
+	ss.nameIndex.Put(state.ID, state.Name)
+	ss.publishChange(bus.OpAdd, id, state.Name, ss.outboxSeq(event))
+	return nil
+}
+
+// RestoreStudent 通过 Raft 恢复学生
+func (ss *StudentService) RestoreStudent(id string, audit model.AuditContext) error {
+	return ss.applyRaftCommand("restore", nil, id, audit)
+}
+
+// RestoreExpiredErr 判断错误是不是学生不存在或者删除已经超过保留期
+func (ss *StudentService) RestoreExpiredErr(id string, err error) bool {
+	return err != nil && (strings.Contains(err.Error(), restoreExpiredErrMsg) ||
+		strings.Contains(err.Error(), fmt.Sprintf("数据库不存在被删除的学生：%s", id)))
+}
+
+// DeletedConflictErr 判断错误是不是添加的学号属于还没有彻底删除的学生
+func (ss *StudentService) DeletedConflictErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), deletedConflictErrMsg)
+}
+
+// RestorePendingErr 判断错误是不是学生还有没有写入数据库的变更
+func (ss *StudentService) RestorePendingErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), restorePendingErrMsg)
+}
+
+// PurgeDeletedStudents 定期彻底删除超过保留期的学生 数据库是共享的 每个节点都执行也不会重复删除
+func (ss *StudentService) PurgeDeletedStudents(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		purged, err := ss.MysqlService.PurgeDeletedStudents(ss.options.SoftDelete)
+		if err != nil {
+			log.Printf("彻底删除超过保留期的学生失败：%v，已删除%d个", err, purged)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("彻底删除了%d个超过保留期的学生", purged)
+		}
+	}
+}
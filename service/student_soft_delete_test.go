@@ -0,0 +1,101 @@
+package service
+
+import (
+	"memoryDataBase/model"
+	"testing"
+	"time"
+)
+
+// TestSoftDeleteAndRestore 删除的学生保留访问次数 恢复后返回实际写入的版本号 学号在彻底删除前不能重新添加
+func TestSoftDeleteAndRestore(t *testing.T) {
+	ss := newTestStudentService(t, newTestDB(t), "node1")
+	audit := model.AuditContext{Actor: "tester"}
+
+	if err := ss.AddStudentInternal(newTestStudent("s1", "张三"), audit); err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	if err := ss.MysqlService.AddStudentCounts(map[string]int64{"s1": 3}); err != nil {
+		t.Fatalf("写入访问次数失败：%v", err)
+	}
+	if err := ss.DeleteStudentInternal("s1", 1, audit); err != nil {
+		t.Fatalf("删除学生失败：%v", err)
+	}
+	// 添加和删除本身也会记一次访问
+	deletedCount, err := ss.MysqlService.GetStudentCountFromMysql("s1")
+	if err != nil || deletedCount.Count < 3 {
+		t.Fatalf("删除后的访问次数 = %+v, %v，期望保留", deletedCount, err)
+	}
+
+	// 保留期内的学号不能被重新添加 之前的学生仍然可以恢复
+	err = ss.AddStudentInternal(newTestStudent("s1", "李四"), audit)
+	if !ss.DeletedConflictErr(err) {
+		t.Fatalf("添加被删除的学号返回 %v，期望冲突", err)
+	}
+
+	var restored *model.Student
+	err = ss.MysqlService.InTx(func(tx *StudentMysqlService) error {
+		var err error
+		restored, err = tx.RestoreStudent("s1", DefaultSoftDeleteOptions().Retention, nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("恢复学生失败：%v", err)
+	}
+	stored, err := ss.MysqlService.GetStudentFromMysql("s1")
+	if err != nil || stored.Name != "张三" || stored.Grades["数学"] != 90 {
+		t.Fatalf("恢复后数据库中的学生 = %+v, %v", stored, err)
+	}
+	if restored.Version != stored.Version || restored.Version <= 1 {
+		t.Fatalf("恢复返回的版本号 = %d，数据库中的版本号 = %d", restored.Version, stored.Version)
+	}
+	if count, err := ss.MysqlService.GetStudentCountFromMysql("s1"); err != nil || count.Count != deletedCount.Count {
+		t.Fatalf("恢复后的访问次数 = %+v, %v，期望%d", count, err, deletedCount.Count)
+	}
+}
+
+// TestPurgeDeletedStudents 超过保留期的学生连同成绩和访问次数一起彻底删除 之后不能恢复 学号可以重新添加
+func TestPurgeDeletedStudents(t *testing.T) {
+	// 保留期为负数 删除的学生立即超过保留期
+	options := DefaultStudentServiceOptions()
+	options.SoftDelete.Retention = -time.Minute
+	ss := newTestStudentServiceWithOptions(t, newTestDB(t), "node1", options)
+	audit := model.AuditContext{Actor: "tester"}
+
+	for _, id := range []string{"s1", "s2"} {
+		if err := ss.AddStudentInternal(newTestStudent(id, "张三"), audit); err != nil {
+			t.Fatalf("添加学生失败：%v", err)
+		}
+	}
+	if err := ss.MysqlService.AddStudentCounts(map[string]int64{"s1": 3, "s2": 5}); err != nil {
+		t.Fatalf("写入访问次数失败：%v", err)
+	}
+	if err := ss.DeleteStudentInternal("s1", 1, audit); err != nil {
+		t.Fatalf("删除学生失败：%v", err)
+	}
+
+	err := ss.RestoreStudentInternal("s1", audit)
+	if !ss.RestoreExpiredErr("s1", err) {
+		t.Fatalf("恢复超过保留期的学生返回 %v，期望已过期", err)
+	}
+	purged, err := ss.MysqlService.PurgeDeletedStudents(options.SoftDelete)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeDeletedStudents = %d, %v，期望删除1个", purged, err)
+	}
+	if count, err := ss.MysqlService.GetStudentCountFromMysql("s1"); err == nil && count.Count != 0 {
+		t.Fatalf("彻底删除后的访问次数 = %+v，期望已删除", count)
+	}
+	if count, err := ss.MysqlService.GetStudentCountFromMysql("s2"); err != nil || count.Count < 5 {
+		t.Fatalf("没有删除的学生的访问次数 = %+v, %v，期望保留", count, err)
+	}
+	if err = ss.RestoreStudentInternal("s1", audit); !ss.RestoreExpiredErr("s1", err) {
+		t.Fatalf("恢复彻底删除的学生返回 %v，期望不存在", err)
+	}
+
+	if err = ss.AddStudentInternal(newTestStudent("s1", "李四"), audit); err != nil {
+		t.Fatalf("彻底删除后重新添加学生失败：%v", err)
+	}
+	stored, err := ss.MysqlService.GetStudentFromMysql("s1")
+	if err != nil || stored.Name != "李四" || stored.Version != 1 || len(stored.Grades) != 2 {
+		t.Fatalf("重新添加的学生 = %+v, %v", stored, err)
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"memoryDataBase/model"
+	"strings"
+)
+
+// 版本号检查失败时的错误信息 通过错误信息判断错误类型
+const (
+	versionMismatchErrMsg = "的版本号不匹配"
+	studentModifiedErrMsg = "已经被其他请求修改"
+)
+
+// versionMismatch 请求中的版本号和学生当前的版本号不同
+func versionMismatch(id string, expected, current int64) error {
+	return fmt.Errorf("学生：%s%s，请求的版本：%d，当前版本：%d", id, versionMismatchErrMsg, expected, current)
+}
+
+// skipVersionCheck 不检查版本号 只有异步写数据库使用 它写入的是已经按请求顺序检查并合并好的最终状态
+// 请求中的版本号都大于0 请求不能绕过版本号检查
+const skipVersionCheck int64 = -1
+
+// checkVersion expected 必须等于学生当前的版本号 没有指定版本号也按不匹配处理
+func checkVersion(id string, expected, current int64) error {
+	if expected == skipVersionCheck {
+		return nil
+	}
+	if expected <= 0 {
+		return fmt.Errorf("学生：%s%s，请求没有指定版本号，当前版本：%d", id, versionMismatchErrMsg, current)
+	}
+	if expected != current {
+		return versionMismatch(id, expected, current)
+	}
+	return nil
+}
+
+// VersionMismatchErr 判断错误是不是请求中的版本号不是学生当前的版本号
+func (ss *StudentService) VersionMismatchErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), versionMismatchErrMsg)
+}
+
+// StudentModifiedErr 判断错误是不是检查版本号之后 写入之前学生被其他请求修改了
+func (ss *StudentService) StudentModifiedErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), studentModifiedErrMsg)
+}
+
+// mergeStudent 把请求中不为空的字段和成绩合并到学生当前的状态上 返回新的学生 不修改参数
+func mergeStudent(current, patch *model.Student) *model.Student {
+	state := current.Copy()
+	if patch.Name != "" {
+		state.Name = patch.Name
+	}
+	if patch.Gender != "" {
+		state.Gender = patch.Gender
+	}
+	if patch.Class != "" {
+		state.Class = patch.Class
+	}
+	if state.Grades == nil {
+		state.Grades = make(map[string]float64)
+	}
+	for subject, score := range patch.Grades {
+		state.Grades[subject] = score
+	}
+	return state
+}
@@ -0,0 +1,666 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"memoryDataBase/bus"
+	"memoryDataBase/model"
+	"memoryDataBase/queue"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WriteBehindOptions 异步写数据库的配置
+type WriteBehindOptions struct {
+	// Enabled 为 true 时写请求在更新内存和缓存并写入本地日志后立即返回 由后台批量写入数据库
+	Enabled bool
+	// Dir 本地日志所在的目录
+	Dir string
+	// BatchSize 每个数据库事务最多写入的变更数
+	BatchSize int
+	// FlushInterval 没有新变更时检查队列的间隔
+	FlushInterval time.Duration
+	// MaxPending 队列中最多积压的变更数 超过后写请求等待 BlockTimeout 后失败
+	MaxPending   int
+	BlockTimeout time.Duration
+	// MaxAttempts 单个学生的变更写入失败时的最多尝试次数 之后写入死信文件
+	MaxAttempts int
+}
+
+// DefaultWriteBehindOptions 返回异步写数据库的默认配置 默认不启用
+func DefaultWriteBehindOptions() WriteBehindOptions {
+	return WriteBehindOptions{
+		Dir:           "data/write-behind",
+		BatchSize:     100,
+		FlushInterval: 200 * time.Millisecond,
+		MaxPending:    10000,
+		BlockTimeout:  5 * time.Second,
+		MaxAttempts:   5,
+	}
+}
+
+// 学生变更的类型 异步写数据库和发件箱共用
+const (
+	studentOpAdd    = "add"
+	studentOpUpdate = "update"
+	studentOpDelete = "delete"
+
+	deadLetterFileName = "dead-letter.log"
+	// writeBehindUnavailableWait 数据库无法连接时重试的间隔
+	writeBehindUnavailableWait = time.Second
+)
+
+// errWriteBehindFull 队列积压过多时返回给写请求
+var errWriteBehindFull = errors.New("写入队列已满，请稍后重试")
+
+// writeBehindOp 一次学生变更 Student 是请求中的学生 State 是变更后学生的完整状态 删除时为nil
+// Audit 是发起变更的人和请求 At 是请求到达的时间 写入数据库时用它们生成审计记录和删除时间
+type writeBehindOp struct {
+	Op      string             `json:"op"`
+	ID      string             `json:"id"`
+	Student *model.Student     `json:"student,omitempty"`
+	State   *model.Student     `json:"state,omitempty"`
+	Audit   model.AuditContext `json:"audit"`
+	At      time.Time          `json:"at"`
+}
+
+// requestTime 返回请求到达的时间 升级前写入日志的变更没有记录时间 使用写入数据库的时间
+func (op writeBehindOp) requestTime() time.Time {
+	if op.At.IsZero() {
+		return time.Now().UTC()
+	}
+	return op.At
+}
+
+type queuedOp struct {
+	seq uint64
+	op  writeBehindOp
+}
+
+// pendingStudent 还没有写入数据库的学生的最新状态
+type pendingStudent struct {
+	seq   uint64
+	state *model.Student
+}
+
+// WriteBehindStats 异步写数据库的统计信息
+type WriteBehindStats struct {
+	Pending     int   `json:"pending"`
+	Flushed     int64 `json:"flushed"`
+	Batches     int64 `json:"batches"`
+	Retries     int64 `json:"retries"`
+	DeadLetters int64 `json:"dead_letters"`
+}
+
+// studentWriteBehind 把学生变更写入本地日志 由后台协程按顺序批量写入数据库
+type studentWriteBehind struct {
+	mysqlService *StudentMysqlService
+	journal      *queue.Journal
+	// journalId 日志的标识 数据库中按它记录每个学生已经写入的序号
+	journalId string
+	dir       string
+	options   WriteBehindOptions
+
+	mu      sync.Mutex
+	queue   []queuedOp
+	pending map[string]pendingStudent
+	// writeMu 串行化写请求 读取学生当前状态 检查版本号和入队之间不会插入其他写请求
+	writeMu sync.Mutex
+
+	// slots 用于背压 每个积压的变更占用一个位置
+	slots chan struct{}
+	wake  chan struct{}
+	quit  chan struct{}
+	done  chan struct{}
+
+	flushed     int64
+	batches     int64
+	retries     int64
+	deadLetters int64
+}
+
+// newStudentWriteBehind 打开 options.Dir 中的本地日志 重启前没有写入数据库的变更会重新排队
+func newStudentWriteBehind(mysqlService *StudentMysqlService, options WriteBehindOptions) (*studentWriteBehind, error) {
+	journal, records, err := queue.Open(options.Dir)
+	if err != nil {
+		return nil, err
+	}
+	maxPending := options.MaxPending
+	if maxPending < len(records) {
+		maxPending = len(records)
+	}
+	wb := &studentWriteBehind{
+		mysqlService: mysqlService,
+		journal:      journal,
+		journalId:    journal.ID(),
+		dir:          options.Dir,
+		options:      options,
+		pending:      make(map[string]pendingStudent),
+		slots:        make(chan struct{}, maxPending),
+		wake:         make(chan struct{}, 1),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	for _, record := range records {
+		var op writeBehindOp
+		if err = json.Unmarshal(record.Data, &op); err != nil {
+			journal.Close()
+			return nil, fmt.Errorf("解析日志记录：%d失败：%w", record.Seq, err)
+		}
+		wb.slots <- struct{}{}
+		wb.queue = append(wb.queue, queuedOp{seq: record.Seq, op: op})
+		wb.pending[op.ID] = pendingStudent{seq: record.Seq, state: op.State}
+	}
+	if len(records) > 0 {
+		log.Printf("从本地日志恢复了%d条没有写入数据库的变更", len(records))
+	}
+	go wb.run()
+	return wb, nil
+}
+
+// enqueue 把变更写入本地日志并排队 队列已满时最多等待 options.BlockTimeout
+func (wb *studentWriteBehind) enqueue(op writeBehindOp) error {
+	if op.At.IsZero() {
+		op.At = time.Now().UTC()
+	}
+	timer := time.NewTimer(wb.options.BlockTimeout)
+	defer timer.Stop()
+	select {
+	case wb.slots <- struct{}{}:
+	case <-timer.C:
+		log.Printf("写入队列已满，拒绝学生：%s的变更", op.ID)
+		return errWriteBehindFull
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		<-wb.slots
+		return err
+	}
+	wb.mu.Lock()
+	// 在锁内追加日志 保证队列中的顺序和日志中的序号一致
+	seq, err := wb.journal.Append(data)
+	if err != nil {
+		wb.mu.Unlock()
+		<-wb.slots
+		log.Printf("写入本地日志失败：%v", err)
+		return err
+	}
+	wb.queue = append(wb.queue, queuedOp{seq: seq, op: op})
+	wb.pending[op.ID] = pendingStudent{seq: seq, state: op.State}
+	wb.mu.Unlock()
+
+	select {
+	case wb.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// lookup 返回还没有写入数据库的学生的最新状态 found 为 false 表示没有待写入的变更 state 为nil表示学生已被删除
+func (wb *studentWriteBehind) lookup(id string) (state *model.Student, found bool) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	p, found := wb.pending[id]
+	if !found || p.state == nil {
+		return nil, found
+	}
+	return p.state.Copy(), true
+}
+
+func (wb *studentWriteBehind) run() {
+	defer close(wb.done)
+	ticker := time.NewTicker(wb.options.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wb.quit:
+			// 退出前尽量写完队列 没有写完的变更留在日志中 下次启动时继续
+			wb.flushAll()
+			return
+		case <-wb.wake:
+		case <-ticker.C:
+		}
+		wb.flushAll()
+	}
+}
+
+func (wb *studentWriteBehind) flushAll() {
+	for {
+		wb.mu.Lock()
+		n := len(wb.queue)
+		if n > wb.options.BatchSize {
+			n = wb.options.BatchSize
+		}
+		batch := append([]queuedOp(nil), wb.queue[:n]...)
+		wb.mu.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+		if !wb.flushBatch(batch) {
+			return
+		}
+	}
+}
+
+// flushBatch 把一批变更写入数据库 数据库无法连接时返回 false 下次再试
+func (wb *studentWriteBehind) flushBatch(batch []queuedOp) bool {
+	groups := groupByStudent(batch)
+	// started 为 false 说明事务没有开启 数据库无法连接
+	started := false
+	err := wb.mysqlService.InTx(func(tx *StudentMysqlService) error {
+		started = true
+		// 已经确认的变更不会再被重新写入 顺便删除它们的序号
+		if err := tx.DeleteWriteBehindSeqs(wb.journalId, wb.journal.Acked()); err != nil {
+			return err
+		}
+		for _, group := range groups {
+			if err := wb.applyQueued(tx, group); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !started {
+		log.Printf("异步写入时开启数据库事务失败：%v", err)
+		atomic.AddInt64(&wb.retries, 1)
+		return false
+	}
+	if err != nil {
+		// 整批写入失败时逐个学生重试 一个学生的错误数据不会阻塞其他学生
+		log.Printf("批量写入数据库失败：%v，逐个学生重试", err)
+		for _, group := range groups {
+			if !wb.applyStudentWithRetry(group) {
+				// 正在退出 这批变更留在日志中 下次启动时重新写入
+				return false
+			}
+		}
+	}
+	atomic.AddInt64(&wb.batches, 1)
+	atomic.AddInt64(&wb.flushed, int64(len(batch)))
+	wb.complete(batch)
+	return true
+}
+
+// complete 从队列中移除已经写入数据库的变更并确认日志
+func (wb *studentWriteBehind) complete(batch []queuedOp) {
+	last := batch[len(batch)-1].seq
+	wb.mu.Lock()
+	wb.queue = wb.queue[len(batch):]
+	for _, queued := range batch {
+		if p, exists := wb.pending[queued.op.ID]; exists && p.seq <= last {
+			delete(wb.pending, queued.op.ID)
+		}
+	}
+	wb.mu.Unlock()
+	if err := wb.journal.Ack(last); err != nil {
+		log.Printf("确认本地日志失败：%v", err)
+	}
+	for range batch {
+		<-wb.slots
+	}
+}
+
+// groupByStudent 按学生分组 保持每个学生的变更顺序和学生第一次出现的顺序
+func groupByStudent(batch []queuedOp) [][]queuedOp {
+	index := make(map[string]int)
+	var groups [][]queuedOp
+	for _, queued := range batch {
+		i, exists := index[queued.op.ID]
+		if !exists {
+			i = len(groups)
+			index[queued.op.ID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], queued)
+	}
+	return groups
+}
+
+// applyQueued 在 tx 中写入一个学生还没有写入数据库的变更 并在同一个事务中记录写入的序号
+// 提交之后确认日志之前崩溃 或者逐个学生重试时部分学生已经提交 重新写入时跳过序号不大于记录的变更
+func (wb *studentWriteBehind) applyQueued(tx *StudentMysqlService, group []queuedOp) error {
+	id := group[0].op.ID
+	applied, err := tx.GetWriteBehindSeq(wb.journalId, id)
+	if err != nil {
+		return err
+	}
+	var ops []writeBehindOp
+	for _, queued := range group {
+		if queued.seq > applied {
+			ops = append(ops, queued.op)
+		}
+	}
+	if len(ops) == 0 {
+		log.Printf("学生：%s的变更已经写入数据库，跳过", id)
+		return nil
+	}
+	if err = wb.applyStudent(tx, ops); err != nil {
+		return err
+	}
+	return tx.SetWriteBehindSeq(wb.journalId, id, group[len(group)-1].seq)
+}
+
+// studentOps 返回一个学生排队的变更
+func studentOps(group []queuedOp) []writeBehindOp {
+	ops := make([]writeBehindOp, len(group))
+	for i, queued := range group {
+		ops[i] = queued.op
+	}
+	return ops
+}
+
+// applyStudent 把一个学生的多次变更合并后写入数据库
+// 学生在这批变更之前是否存在由第一次变更决定 之后是否存在由最后的状态决定
+// 同一批中添加后又删除的学生先写入删除前的状态再软删除 和同步写入一样可以恢复 也不能用同一个学号重新添加
+func (wb *studentWriteBehind) applyStudent(tx *StudentMysqlService, ops []writeBehindOp) error {
+	id := ops[0].ID
+	existedBefore := ops[0].Op != studentOpAdd
+	final := ops[len(ops)-1].State
+	deleted := false
+	var deletedAt time.Time
+	var lastState *model.Student
+	for _, op := range ops {
+		if op.Op == studentOpDelete {
+			deleted = true
+			deletedAt = op.requestTime()
+		}
+		if op.State != nil {
+			lastState = op.State
+		}
+	}
+	// 合并写入前读取学生原来的状态 每次变更分别生成审计记录
+	var before *model.Student
+	if existedBefore {
+		current, err := tx.GetStudentFromMysql(id)
+		if err != nil {
+			return err
+		}
+		before = current
+	}
+	if !existedBefore && deleted && lastState != nil {
+		if err := tx.AddStudentToMysql(lastState.Copy(), nil); err != nil {
+			return err
+		}
+	}
+	if deleted || (existedBefore && final == nil) {
+		if err := tx.deleteStudentAt(id, skipVersionCheck, nil, deletedAt); err != nil {
+			return err
+		}
+	}
+	if final != nil {
+		var err error
+		if existedBefore && !deleted {
+			err = tx.overwriteStudent(final.Copy())
+		} else {
+			err = tx.AddStudentToMysql(final.Copy(), nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, op := range ops {
+		if err := tx.recordChangeAt(op.Op, before, op.State, op.Audit, op.requestTime()); err != nil {
+			return err
+		}
+		before = op.State
+	}
+	return nil
+}
+
+// applyStudentWithRetry 在单独的事务中写入一个学生的变更 多次失败后写入死信文件 等待数据库或重试期间退出时返回 false
+func (wb *studentWriteBehind) applyStudentWithRetry(group []queuedOp) bool {
+	id := group[0].op.ID
+	backoff := 100 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= wb.options.MaxAttempts; attempt++ {
+		started := false
+		err = wb.mysqlService.InTx(func(tx *StudentMysqlService) error {
+			started = true
+			return wb.applyQueued(tx, group)
+		})
+		if err == nil {
+			return true
+		}
+		if !started {
+			// 数据库无法连接时一直等待 不计入尝试次数 避免把正常的变更写入死信文件
+			select {
+			case <-wb.quit:
+				return false
+			case <-time.After(writeBehindUnavailableWait):
+			}
+			attempt--
+			continue
+		}
+		log.Printf("第%d次写入学生：%s失败：%v", attempt, id, err)
+		if attempt < wb.options.MaxAttempts {
+			atomic.AddInt64(&wb.retries, 1)
+			// 退出时不再等待 这个学生的变更留在日志中 下次启动时重新写入
+			select {
+			case <-wb.quit:
+				return false
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+	wb.deadLetter(studentOps(group), err)
+	return true
+}
+
+// deadLetter 多次写入失败的变更追加到死信文件 需要人工处理
+func (wb *studentWriteBehind) deadLetter(ops []writeBehindOp, cause error) {
+	atomic.AddInt64(&wb.deadLetters, 1)
+	log.Printf("学生：%s的变更多次写入失败，写入死信文件：%v", ops[0].ID, cause)
+	file, err := os.OpenFile(filepath.Join(wb.dir, deadLetterFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Printf("打开死信文件失败：%v", err)
+		return
+	}
+	defer file.Close()
+	for _, op := range ops {
+		line, err := json.Marshal(struct {
+			writeBehindOp
+			Error string `json:"error"`
+		}{op, cause.Error()})
+		if err != nil {
+			continue
+		}
+		if _, err = file.Write(append(line, '\n')); err != nil {
+			log.Printf("写入死信文件失败：%v", err)
+			return
+		}
+	}
+	file.Sync()
+}
+
+func (wb *studentWriteBehind) stats() WriteBehindStats {
+	wb.mu.Lock()
+	pending := len(wb.queue)
+	wb.mu.Unlock()
+	return WriteBehindStats{
+		Pending:     pending,
+		Flushed:     atomic.LoadInt64(&wb.flushed),
+		Batches:     atomic.LoadInt64(&wb.batches),
+		Retries:     atomic.LoadInt64(&wb.retries),
+		DeadLetters: atomic.LoadInt64(&wb.deadLetters),
+	}
+}
+
+// close 停止后台协程并关闭日志
+func (wb *studentWriteBehind) close() error {
+	close(wb.quit)
+	<-wb.done
+	return wb.journal.Close()
+}
+
+// studentFromStore 从待写入的变更或数据库中获取学生 异步写数据库时数据库中的数据可能落后
+func (ss *StudentService) studentFromStore(id string) (*model.Student, error) {
+	if ss.writeBehind != nil {
+		if state, found := ss.writeBehind.lookup(id); found {
+			if state == nil {
+				return nil, fmt.Errorf("数据库不存在学生：%s", id)
+			}
+			return state, nil
+		}
+	}
+	return ss.MysqlService.GetStudentFromMysql(id)
+}
+
+// addStudentWriteBehind 写入本地日志后更新内存和缓存 由后台写入数据库
+func (ss *StudentService) addStudentWriteBehind(student *model.Student, audit model.AuditContext) error {
+	ss.writeBehind.writeMu.Lock()
+	defer ss.writeBehind.writeMu.Unlock()
+	// 队列中的删除还没有写入数据库时 学生写入后同样是被软删除的学生
+	if state, found := ss.writeBehind.lookup(student.ID); found && state == nil {
+		return deletedConflict(student.ID)
+	}
+	_, err := ss.studentFromStore(student.ID)
+	if err == nil {
+		return fmt.Errorf("学生：%s已存在", student.ID)
+	}
+	if !ss.MysqlService.StudentNotFoundErr(student.ID, err) {
+		return err
+	}
+	if err = ss.MysqlService.checkNotDeleted(student.ID); err != nil {
+		return err
+	}
+	student.Version = 1
+	op := writeBehindOp{Op: studentOpAdd, ID: student.ID, Student: student.Copy(), State: student.Copy(), Audit: audit}
+	if err = ss.writeBehind.enqueue(op); err != nil {
+		return err
+	}
+	ss.MdbService.AddStudent(student)
+	if err = ss.CacheService.AddStudent(student); err != nil {
+		log.Printf("向缓存添加学生：%s失败：%v", student.ID, err)
+	}
+	ss.rememberStudent(student.ID)
+	ss.nameIndex.Put(student.ID, student.Name)
+	ss.publishChange(bus.OpAdd, student.ID, student.Name, ss.nextInvalidationSeq())
+	ss.accessCounter.record(student.ID)
+	return nil
+}
+
+// updateStudentWriteBehind 计算更新后学生的完整状态并写入本地日志 再更新内存和缓存
+func (ss *StudentService) updateStudentWriteBehind(student *model.Student, audit model.AuditContext) error {
+	ss.writeBehind.writeMu.Lock()
+	defer ss.writeBehind.writeMu.Unlock()
+	current, err := ss.studentFromStore(student.ID)
+	if err != nil {
+		log.Printf("更新学生：%s时失败：%v", student.ID, err)
+		return err
+	}
+	if err = checkVersion(student.ID, student.Version, current.Version); err != nil {
+		return err
+	}
+	state := mergeStudent(current, student)
+	state.Version = current.Version + 1
+	op := writeBehindOp{Op: studentOpUpdate, ID: student.ID, Student: student.Copy(), State: state, Audit: audit}
+	if err = ss.writeBehind.enqueue(op); err != nil {
+		return err
+	}
+	student.Version = state.Version
+	if err = ss.CacheService.UpdateStudent(state.Copy()); err != nil && !ss.StudentNotFoundErr(student.ID, err) {
+		log.Printf("更新缓存中的学生：%s时失败：%v", student.ID, err)
+		ss.CacheService.DeleteStudent(student.ID)
+	}
+	if err = ss.MdbService.UpdateStudent(state.Copy()); err != nil && !ss.StudentNotFoundErr(student.ID, err) {
+		log.Printf("更新内存中的学生：%s时失败：%v", student.ID, err)
+		ss.MdbService.Evict(student.ID)
+	}
+	ss.nameIndex.Put(state.ID, state.Name)
+	ss.publishChange(bus.OpUpdate, student.ID, state.Name, ss.nextInvalidationSeq())
+	ss.accessCounter.record(student.ID)
+	return nil
+}
+
+// deleteStudentWriteBehind 写入本地日志后从内存和缓存中删除学生
+func (ss *StudentService) deleteStudentWriteBehind(id string, version int64, audit model.AuditContext) error {
+	ss.writeBehind.writeMu.Lock()
+	defer ss.writeBehind.writeMu.Unlock()
+	current, err := ss.studentFromStore(id)
+	if err != nil {
+		log.Printf("删除学生：%s时失败：%v", id, err)
+		return err
+	}
+	if err = checkVersion(id, version, current.Version); err != nil {
+		return err
+	}
+	if err = ss.writeBehind.enqueue(writeBehindOp{Op: studentOpDelete, ID: id, Audit: audit}); err != nil {
+		return err
+	}
+	if err = ss.CacheService.DeleteStudent(id); err != nil && !ss.StudentNotFoundErr(id, err) {
+		log.Printf("从缓存中删除学生：%s失败：%v", id, err)
+	}
+	ss.MdbService.Evict(id)
+	ss.forgetStudent(id)
+	ss.nameIndex.Remove(id)
+	ss.publishChange(bus.OpDelete, id, "", ss.nextInvalidationSeq())
+	ss.accessCounter.forget(id)
+	ss.hotStudents.Remove(id)
+	return nil
+}
+
+// pendingStates 返回队列中每个学生的最新状态 删除的学生状态为nil
+func (wb *studentWriteBehind) pendingStates() map[string]*model.Student {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	states := make(map[string]*model.Student, len(wb.pending))
+	for id, p := range wb.pending {
+		if p.state == nil {
+			states[id] = nil
+		} else {
+			states[id] = p.state.Copy()
+		}
+	}
+	return states
+}
+
+// isPending 学生是否有还没有写入数据库的变更 包括删除
+func (wb *studentWriteBehind) isPending(id string) bool {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	_, exists := wb.pending[id]
+	return exists
+}
+
+// pendingStudentIDs 返回还没有写入数据库的新学生 构建布隆过滤器时需要加上
+func (wb *studentWriteBehind) pendingStudentIDs() []string {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	var ids []string
+	for id, p := range wb.pending {
+		if p.state != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// writePending 学生是否有还没有写入数据库的变更 这时数据库中的学生比缓存和内存旧
+func (ss *StudentService) writePending(id string) bool {
+	return ss.writeBehind != nil && ss.writeBehind.isPending(id)
+}
+
+// WriteBehindStats 返回异步写数据库的统计信息 没有启用时返回nil
+func (ss *StudentService) WriteBehindStats() *WriteBehindStats {
+	if ss.writeBehind == nil {
+		return nil
+	}
+	stats := ss.writeBehind.stats()
+	return &stats
+}
+
+// Close 写入累积的访问次数 停止发件箱中继和后台写数据库 队列中剩余的变更保留在本地日志中
+func (ss *StudentService) Close() error {
+	ss.accessCounter.close()
+	ss.stopOutboxRelay()
+	if ss.writeBehind == nil {
+		return nil
+	}
+	return ss.writeBehind.close()
+}
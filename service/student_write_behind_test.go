@@ -0,0 +1,216 @@
+package service
+
+import (
+	"encoding/json"
+	"memoryDataBase/dao"
+	"memoryDataBase/model"
+	"memoryDataBase/queue"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteBehindUsesRequestTime 异步写入的审计记录和删除时间使用请求到达的时间 而不是写入数据库的时间
+func TestWriteBehindUsesRequestTime(t *testing.T) {
+	mysqlDao := dao.NewStudentMysqlDao(newTestDB(t))
+	wb := &studentWriteBehind{mysqlService: NewStudentMysqlService(mysqlDao)}
+	addedAt := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Second)
+	deletedAt := addedAt.Add(time.Hour)
+	student := newTestStudent("s1", "张三")
+	student.Version = 1
+	ops := []writeBehindOp{
+		{Op: studentOpAdd, ID: "s1", Student: student, State: student, Audit: model.AuditContext{Actor: "a"}, At: addedAt},
+	}
+	if err := wb.mysqlService.InTx(func(tx *StudentMysqlService) error { return wb.applyStudent(tx, ops) }); err != nil {
+		t.Fatalf("写入添加失败：%v", err)
+	}
+	ops = []writeBehindOp{{Op: studentOpDelete, ID: "s1", Audit: model.AuditContext{Actor: "b"}, At: deletedAt}}
+	if err := wb.mysqlService.InTx(func(tx *StudentMysqlService) error { return wb.applyStudent(tx, ops) }); err != nil {
+		t.Fatalf("写入删除失败：%v", err)
+	}
+
+	deleted, err := mysqlDao.GetDeletedStudent("s1")
+	if err != nil || deleted.DeletedAt == nil || !deleted.DeletedAt.Equal(deletedAt) {
+		t.Fatalf("删除时间 = %v, %v，期望 %v", deleted, err, deletedAt)
+	}
+	entries, _, err := wb.mysqlService.GetStudentHistory("s1", 1, 100)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("审计记录 = %v, %v", entries, err)
+	}
+	for _, entry := range entries {
+		want := addedAt
+		if entry.Action == studentOpDelete {
+			want = deletedAt
+		}
+		if !entry.CreatedAt.Equal(want) {
+			t.Fatalf("%s的审计记录时间 = %v，期望 %v", entry.Action, entry.CreatedAt, want)
+		}
+	}
+}
+
+// TestWriteBehindRetryStopsOnQuit 重试的等待期间收到退出信号时立即返回 变更留在日志中
+func TestWriteBehindRetryStopsOnQuit(t *testing.T) {
+	wb := &studentWriteBehind{
+		mysqlService: NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t))),
+		options:      DefaultWriteBehindOptions(),
+		quit:         make(chan struct{}),
+	}
+	close(wb.quit)
+	// 更新不存在的学生 每次都会失败
+	group := []queuedOp{{seq: 1, op: writeBehindOp{Op: studentOpUpdate, ID: "missing", State: newTestStudent("missing", "张三")}}}
+	start := time.Now()
+	if wb.applyStudentWithRetry(group) {
+		t.Fatalf("退出时仍然继续重试或写入了死信文件")
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("退出前等待了%v", elapsed)
+	}
+}
+
+// TestWriteBehindReplayAfterCommit 变更提交后确认日志前崩溃 重启后重新写入时跳过已经提交的变更 不会写入死信文件或重复的审计记录
+func TestWriteBehindReplayAfterCommit(t *testing.T) {
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t)))
+	dir := t.TempDir()
+	journal, _, err := queue.Open(dir)
+	if err != nil {
+		t.Fatalf("打开日志失败：%v", err)
+	}
+	added := newTestStudent("s1", "张三")
+	added.Version = 1
+	updated := added.Copy()
+	updated.Name = "李四"
+	updated.Version = 2
+	audit := model.AuditContext{Actor: "tester"}
+	ops := []writeBehindOp{
+		{Op: studentOpAdd, ID: "s1", Student: added, State: added, Audit: audit},
+		{Op: studentOpUpdate, ID: "s1", Student: &model.Student{ID: "s1", Name: "李四"}, State: updated, Audit: audit},
+	}
+	var batch []queuedOp
+	for _, op := range ops {
+		data, err := json.Marshal(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, err := journal.Append(data)
+		if err != nil {
+			t.Fatalf("追加日志失败：%v", err)
+		}
+		batch = append(batch, queuedOp{seq: seq, op: op})
+	}
+	// 提交这批变更后不确认日志 模拟崩溃
+	wb := &studentWriteBehind{mysqlService: mysqlService, journal: journal, journalId: journal.ID()}
+	err = mysqlService.InTx(func(tx *StudentMysqlService) error {
+		return wb.applyQueued(tx, groupByStudent(batch)[0])
+	})
+	if err != nil {
+		t.Fatalf("写入变更失败：%v", err)
+	}
+	_, audits, err := mysqlService.GetStudentHistory("s1", 1, 100)
+	if err != nil {
+		t.Fatalf("读取审计记录失败：%v", err)
+	}
+	journal.Close()
+
+	// 重启后日志中的两条变更重新排队 关闭前写完
+	options := DefaultWriteBehindOptions()
+	options.Dir = dir
+	wb, err = newStudentWriteBehind(mysqlService, options)
+	if err != nil {
+		t.Fatalf("重新打开日志失败：%v", err)
+	}
+	if err = wb.close(); err != nil {
+		t.Fatalf("关闭失败：%v", err)
+	}
+	if stats := wb.stats(); stats.Pending != 0 || stats.DeadLetters != 0 || stats.Retries != 0 {
+		t.Fatalf("重新写入后的统计 = %+v，期望全部写入且没有重试", stats)
+	}
+	student, err := mysqlService.GetStudentFromMysql("s1")
+	if err != nil || student.Name != "李四" || student.Version != 2 {
+		t.Fatalf("重新写入后的学生 = %+v, %v", student, err)
+	}
+	if _, total, err := mysqlService.GetStudentHistory("s1", 1, 100); err != nil || total != audits {
+		t.Fatalf("重新写入后的审计记录 = %d条, %v，期望%d条", total, err, audits)
+	}
+	// 再次启动时没有需要写入的变更
+	journal, pending, err := queue.Open(dir)
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("日志中剩余的变更 = %v, %v", pending, err)
+	}
+	journal.Close()
+}
+
+// TestWriteBehindDeleteKeepsCounts 异步删除学生时保留访问次数 恢复的学生仍然有之前的访问次数
+func TestWriteBehindDeleteKeepsCounts(t *testing.T) {
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t)))
+	student := newTestStudent("s1", "张三")
+	err := mysqlService.InTx(func(tx *StudentMysqlService) error {
+		return tx.AddStudentToMysql(student, nil)
+	})
+	if err != nil {
+		t.Fatalf("添加学生失败：%v", err)
+	}
+	if err = mysqlService.AddStudentCounts(map[string]int64{"s1": 3}); err != nil {
+		t.Fatalf("写入访问次数失败：%v", err)
+	}
+	wb := &studentWriteBehind{mysqlService: mysqlService}
+	ops := []writeBehindOp{{Op: studentOpDelete, ID: "s1"}}
+	err = mysqlService.InTx(func(tx *StudentMysqlService) error { return wb.applyStudent(tx, ops) })
+	if err != nil {
+		t.Fatalf("写入删除失败：%v", err)
+	}
+	if count, err := mysqlService.GetStudentCountFromMysql("s1"); err != nil || count.Count != 3 {
+		t.Fatalf("删除后的访问次数 = %+v, %v，期望保留3", count, err)
+	}
+}
+
+// TestWriteBehindAddThenDeleteInBatch 同一批中添加 更新后又删除的学生留下软删除的记录 可以恢复到删除前的状态 不能重新添加
+func TestWriteBehindAddThenDeleteInBatch(t *testing.T) {
+	mysqlService := NewStudentMysqlService(dao.NewStudentMysqlDao(newTestDB(t)))
+	wb := &studentWriteBehind{mysqlService: mysqlService}
+	added := newTestStudent("s1", "张三")
+	added.Version = 1
+	updated := added.Copy()
+	updated.Name = "李四"
+	updated.Version = 2
+	audit := model.AuditContext{Actor: "tester"}
+	ops := []writeBehindOp{
+		{Op: studentOpAdd, ID: "s1", Student: added, State: added, Audit: audit},
+		{Op: studentOpUpdate, ID: "s1", Student: &model.Student{ID: "s1", Name: "李四"}, State: updated, Audit: audit},
+		{Op: studentOpDelete, ID: "s1", Audit: audit},
+	}
+	err := mysqlService.InTx(func(tx *StudentMysqlService) error { return wb.applyStudent(tx, ops) })
+	if err != nil {
+		t.Fatalf("写入变更失败：%v", err)
+	}
+	if _, err = mysqlService.GetStudentFromMysql("s1"); err == nil {
+		t.Fatalf("删除的学生仍然可以读到")
+	}
+	err = mysqlService.InTx(func(tx *StudentMysqlService) error {
+		return tx.AddStudentToMysql(newTestStudent("s1", "王五"), nil)
+	})
+	if err == nil || !strings.Contains(err.Error(), deletedConflictErrMsg) {
+		t.Fatalf("重新添加被删除的学生 = %v，期望冲突", err)
+	}
+
+	var restored *model.Student
+	err = mysqlService.InTx(func(tx *StudentMysqlService) error {
+		restored, err = tx.RestoreStudent("s1", DefaultSoftDeleteOptions().Retention, &audit)
+		return err
+	})
+	if err != nil || restored.Name != "李四" {
+		t.Fatalf("恢复的学生 = %+v, %v，期望李四", restored, err)
+	}
+	entries, _, err := mysqlService.GetStudentHistory("s1", 1, 100)
+	if err != nil {
+		t.Fatalf("读取审计记录失败：%v", err)
+	}
+	actions := make(map[string]bool)
+	for _, entry := range entries {
+		actions[entry.Action] = true
+	}
+	for _, action := range []string{studentOpAdd, studentOpUpdate, studentOpDelete, studentOpRestore} {
+		if !actions[action] {
+			t.Fatalf("审计记录中没有%s：%v", action, actions)
+		}
+	}
+}